@@ -0,0 +1,128 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// authenticator protects handlers with HTTP basic auth, a bearer token,
+// or both of them, in which case either of them is accepted. Passwords
+// are hashed by bcrypt, e.g. generated by `htpasswd -nBC 10 "" | tr -d ':'`.
+type authenticator struct {
+	mu    sync.RWMutex
+	users map[string][]byte
+	token []byte
+
+	// bcrypt takes tens of milliseconds by design, the credentials
+	// verified already are remembered by their digest until reloaded
+	verified map[[sha256.Size]byte]bool
+}
+
+// dummyHash is compared against if the user is unknown, so it takes the
+// same time as a known one.
+var dummyHash = []byte("$2a$10$Yk6/72N1s1tEtCjiDHuT8.FKEcotE4QYwE980ov46Y8Y42u.9sgbu")
+
+func (a *authenticator) load(hashes map[string]string, tokenFile string) error {
+	var users map[string][]byte
+	if len(hashes) > 0 {
+		users = make(map[string][]byte, len(hashes))
+		for user, hash := range hashes {
+			if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+				return fmt.Errorf("invalid password hash of user %s, bcrypt expected, %s", user, err)
+			}
+
+			users[user] = []byte(hash)
+		}
+	}
+
+	var token []byte
+	if tokenFile != "" {
+		data, err := os.ReadFile(tokenFile)
+		if err != nil {
+			return fmt.Errorf("read bearer token file failed, %s", err)
+		}
+
+		token = []byte(strings.TrimSpace(string(data)))
+		if len(token) == 0 {
+			return fmt.Errorf("bearer token file %s is empty", tokenFile)
+		}
+	}
+
+	a.mu.Lock()
+	a.users = users
+	a.token = token
+	a.verified = make(map[[sha256.Size]byte]bool)
+	a.mu.Unlock()
+
+	return nil
+}
+
+func (a *authenticator) authorized(r *http.Request) bool {
+	a.mu.RLock()
+	users, token := a.users, a.token
+	a.mu.RUnlock()
+
+	// nothing configured
+	if users == nil && token == nil {
+		return true
+	}
+
+	if user, password, ok := r.BasicAuth(); ok && users != nil {
+		return a.verify(users, user, password)
+	}
+
+	auth := r.Header.Get("Authorization")
+	if strings.HasPrefix(auth, "Bearer ") && token != nil {
+		return subtle.ConstantTimeCompare([]byte(auth[len("Bearer "):]), token) == 1
+	}
+
+	return false
+}
+
+func (a *authenticator) verify(users map[string][]byte, user, password string) bool {
+	hash, found := users[user]
+	key := sha256.Sum256([]byte(user + "\x00" + password + "\x00" + string(hash)))
+
+	a.mu.RLock()
+	ok := a.verified[key]
+	a.mu.RUnlock()
+	if ok {
+		return true
+	}
+
+	// compare even if the user is unknown, so it takes the same time
+	if !found {
+		hash = dummyHash
+	}
+
+	if bcrypt.CompareHashAndPassword(hash, []byte(password)) != nil || !found {
+		return false
+	}
+
+	a.mu.Lock()
+	if a.verified != nil {
+		a.verified[key] = true
+	}
+	a.mu.Unlock()
+
+	return true
+}
+
+func (a *authenticator) wrap(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !a.authorized(r) {
+			w.Header().Set("WWW-Authenticate", `Basic realm="libvirt_exporter"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		h.ServeHTTP(w, r)
+	})
+}
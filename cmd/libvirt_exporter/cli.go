@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/f1shl3gs/libvirt_exporter/exporter"
+)
+
+// checkHosts dials, connects and lists the domains of every hypervisor
+// step by step, and prints a diagnosis of the failed step. The exit code
+// is 1 if any of them failed.
+func checkHosts(w io.Writer, lc *exporter.MultiExporter) int {
+	code := 0
+	for _, e := range lc.Exporters() {
+		fmt.Fprintf(w, "%s\n", e.URI())
+		for _, step := range e.Check(context.Background()) {
+			if step.Err != nil {
+				fmt.Fprintf(w, "  FAIL %-13s %s\n", step.Name, step.Err)
+				if hint := checkHint(step); hint != "" {
+					fmt.Fprintf(w, "       %s\n", hint)
+				}
+				code = 1
+				continue
+			}
+
+			fmt.Fprintf(w, "  OK   %-13s %s %s\n", step.Name, step.Duration.Round(time.Millisecond), step.Detail)
+		}
+	}
+
+	return code
+}
+
+// checkHint tells the likely cause of the failed step.
+func checkHint(step exporter.CheckStep) string {
+	msg := strings.ToLower(step.Err.Error())
+	switch {
+	case strings.Contains(msg, "permission denied"):
+		return "the socket is not accessible, add the user to the libvirt group or use the read-only socket libvirt-sock-ro"
+	case strings.Contains(msg, "no such file"):
+		return "libvirtd is not running or the socket path is wrong, see --libvirt.uri"
+	case strings.Contains(msg, "connection refused"):
+		return "libvirtd doesn't listen on the address, check listen_tcp and listen_tls of libvirtd.conf"
+	case strings.Contains(msg, "certificate") || strings.Contains(msg, "x509"):
+		return "the TLS certificates are not trusted, check the client certificates under /etc/pki"
+	case strings.Contains(msg, "timeout") || strings.Contains(msg, "deadline"):
+		return "the hypervisor is unreachable or libvirtd is hung, see --libvirt.timeout"
+	case step.Name == "connect":
+		return "the authentication failed, check auth_unix_ro, auth_unix_rw and the polkit rules of libvirtd"
+	case step.Name == "list-domains" || step.Name == "read-domain":
+		return "the connection lacks permissions, check the access control rules of libvirtd"
+	}
+
+	return ""
+}
+
+// listDomains prints the domains of every hypervisor, and why the ones
+// not collected are skipped. The exit code is 1 if any hypervisor
+// failed.
+func listDomains(w io.Writer, lc *exporter.MultiExporter) int {
+	code := 0
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "HOST\tNAME\tUUID\tSTATE\tCOLLECTED\n")
+	for _, e := range lc.Exporters() {
+		domains, err := e.ListDomains(context.Background())
+		if err != nil {
+			log.Printf("list domains of %s failed, %s\n", e.URI(), err)
+			code = 1
+			continue
+		}
+
+		for _, domain := range domains {
+			collected := "yes"
+			if domain.Skipped != "" {
+				collected = "no, " + domain.Skipped
+			}
+
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", e.URI(), domain.Name, domain.UUID, domain.State, collected)
+		}
+	}
+
+	if err := tw.Flush(); err != nil {
+		return 1
+	}
+
+	return code
+}
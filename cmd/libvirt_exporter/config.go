@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/f1shl3gs/libvirt_exporter/exporter"
+)
+
+// Config is loaded from the file specified by --config.file, it could be
+// reloaded by SIGHUP or a POST to /-/reload.
+type Config struct {
+	Domain  exporter.DomainFilterConfig `yaml:"domain"`
+	Relabel exporter.RelabelConfig      `yaml:"relabel"`
+}
+
+func loadConfig(path string) (*Config, error) {
+	cfg := &Config{}
+	if path == "" {
+		return cfg, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = yaml.UnmarshalStrict(data, cfg); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+// metadataFlag collects repeated name=regexp flags
+type metadataFlag map[string]string
+
+func (m metadataFlag) String() string {
+	pairs := make([]string, 0, len(m))
+	for name, pattern := range m {
+		pairs = append(pairs, name+"="+pattern)
+	}
+
+	return strings.Join(pairs, ",")
+}
+
+func (m metadataFlag) Set(value string) error {
+	i := strings.Index(value, "=")
+	if i <= 0 {
+		return fmt.Errorf("expect name=regexp, got %q", value)
+	}
+
+	m[value[:i]] = value[i+1:]
+	return nil
+}
+
+func (m metadataFlag) IsCumulative() bool {
+	return true
+}
+
+// labelsFlag collects repeated or comma separated name=value labels
+type labelsFlag map[string]string
+
+func (l labelsFlag) String() string {
+	pairs := make([]string, 0, len(l))
+	for name, value := range l {
+		pairs = append(pairs, name+"="+value)
+	}
+
+	return strings.Join(pairs, ",")
+}
+
+func (l labelsFlag) Set(value string) error {
+	for _, pair := range strings.Split(value, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+
+		i := strings.Index(pair, "=")
+		if i <= 0 {
+			return fmt.Errorf("expect name=value, got %q", pair)
+		}
+
+		if !exporter.ValidLabelName(pair[:i]) {
+			return fmt.Errorf("invalid label name %q", pair[:i])
+		}
+
+		l[pair[:i]] = pair[i+1:]
+	}
+
+	return nil
+}
+
+func (l labelsFlag) IsCumulative() bool {
+	return true
+}
+
+// listFlag collects repeated or comma separated values
+type listFlag []string
+
+func (l *listFlag) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *listFlag) Set(value string) error {
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			*l = append(*l, item)
+		}
+	}
+
+	return nil
+}
+
+func (l *listFlag) IsCumulative() bool {
+	return true
+}
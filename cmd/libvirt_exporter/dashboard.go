@@ -0,0 +1,220 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/f1shl3gs/libvirt_exporter/exporter"
+)
+
+// panel is a Grafana panel, either a graph or a row of graphs.
+type panel struct {
+	Type       string       `json:"type"`
+	Title      string       `json:"title"`
+	Datasource string       `json:"datasource,omitempty"`
+	GridPos    gridPos      `json:"gridPos"`
+	Targets    []target     `json:"targets,omitempty"`
+	Collapsed  *bool        `json:"collapsed,omitempty"`
+	Panels     []panel      `json:"panels,omitempty"`
+	FieldCfg   *fieldConfig `json:"fieldConfig,omitempty"`
+}
+
+type gridPos struct {
+	H int `json:"h"`
+	W int `json:"w"`
+	X int `json:"x"`
+	Y int `json:"y"`
+}
+
+type target struct {
+	Expr         string `json:"expr"`
+	LegendFormat string `json:"legendFormat"`
+	RefID        string `json:"refId"`
+}
+
+type fieldConfig struct {
+	Defaults struct {
+		Unit string `json:"unit"`
+	} `json:"defaults"`
+}
+
+// variable is a template variable of the dashboard.
+type variable struct {
+	Name       string      `json:"name"`
+	Label      string      `json:"label"`
+	Type       string      `json:"type"`
+	Query      string      `json:"query"`
+	Datasource string      `json:"datasource,omitempty"`
+	Refresh    int         `json:"refresh,omitempty"`
+	Multi      bool        `json:"multi"`
+	IncludeAll bool        `json:"includeAll"`
+	Current    interface{} `json:"current"`
+}
+
+// dashboard builds the Grafana dashboard of the metrics of e, a graph
+// per metric in a row per collector. Info metrics are used by the
+// template variables only.
+func dashboard(e *exporter.Exporter, namespace string) map[string]interface{} {
+	type row struct {
+		title   string
+		metrics []exporter.MetricInfo
+	}
+
+	var rows []*row
+	index := make(map[string]*row)
+	for _, metric := range e.Metrics() {
+		if strings.HasSuffix(metric.Name, "_info") {
+			continue
+		}
+
+		title := metric.Collector
+		if title == "" {
+			title = "exporter"
+		}
+
+		r, ok := index[title]
+		if !ok {
+			r = &row{title: title}
+			index[title] = r
+			rows = append(rows, r)
+		}
+		r.metrics = append(r.metrics, metric)
+	}
+
+	// two graphs side by side, the graphs of collapsed rows are nested
+	// in them
+	var panels []panel
+	y := 0
+	for _, r := range rows {
+		collapsed := r.title == "exporter"
+		p := panel{
+			Type:      "row",
+			Title:     r.title,
+			GridPos:   gridPos{H: 1, W: 24, X: 0, Y: y},
+			Collapsed: &collapsed,
+		}
+		y++
+
+		var graphs []panel
+		for i, metric := range r.metrics {
+			graphs = append(graphs, graphPanel(metric, gridPos{H: 8, W: 12, X: i % 2 * 12, Y: y + i/2*8}))
+		}
+
+		if collapsed {
+			p.Panels = graphs
+			panels = append(panels, p)
+			continue
+		}
+
+		y += (len(graphs) + 1) / 2 * 8
+		panels = append(panels, p)
+		panels = append(panels, graphs...)
+	}
+
+	return map[string]interface{}{
+		"title":         "Libvirt",
+		"uid":           namespace + "-exporter",
+		"tags":          []string{"libvirt"},
+		"editable":      true,
+		"schemaVersion": 27,
+		"refresh":       "1m",
+		"time":          map[string]string{"from": "now-6h", "to": "now"},
+		"templating": map[string]interface{}{
+			"list": []variable{
+				{
+					Name:    "datasource",
+					Label:   "Data source",
+					Type:    "datasource",
+					Query:   "prometheus",
+					Current: map[string]interface{}{},
+				},
+				{
+					Name:       "instance",
+					Label:      "Instance",
+					Type:       "query",
+					Query:      fmt.Sprintf("label_values(%s, instance)", prometheus.BuildFQName(namespace, "", "up")),
+					Datasource: "$datasource",
+					Refresh:    2,
+					Multi:      true,
+					IncludeAll: true,
+					Current:    map[string]interface{}{"text": "All", "value": "$__all"},
+				},
+				{
+					Name:       "domain",
+					Label:      "Domain",
+					Type:       "query",
+					Query:      fmt.Sprintf("label_values(%s{instance=~\"$instance\"}, domain)", prometheus.BuildFQName(namespace, "", "domain_info")),
+					Datasource: "$datasource",
+					Refresh:    2,
+					Multi:      true,
+					IncludeAll: true,
+					Current:    map[string]interface{}{"text": "All", "value": "$__all"},
+				},
+			},
+		},
+		"panels": panels,
+	}
+}
+
+// graphPanel graphs the metric, counters as rates.
+func graphPanel(metric exporter.MetricInfo, pos gridPos) panel {
+	selector := []string{`instance=~"$instance"`}
+	var legend []string
+	for _, label := range metric.Labels {
+		switch label {
+		case "domain":
+			selector = append(selector, `domain=~"$domain"`)
+		case "uuid":
+			continue
+		}
+
+		legend = append(legend, "{{"+label+"}}")
+	}
+	if len(legend) == 0 {
+		legend = []string{"{{instance}}"}
+	}
+
+	expr := metric.Name + "{" + strings.Join(selector, ",") + "}"
+	if metric.Counter() {
+		expr = "rate(" + expr + "[$__rate_interval])"
+	}
+
+	p := panel{
+		Type:       "graph",
+		Title:      metric.Help,
+		Datasource: "$datasource",
+		GridPos:    pos,
+		Targets:    []target{{Expr: expr, LegendFormat: strings.Join(legend, " "), RefID: "A"}},
+	}
+
+	switch {
+	case strings.HasSuffix(metric.Name, "_bytes_total"):
+		p.FieldCfg = unit("Bps")
+	case strings.HasSuffix(metric.Name, "_bytes"):
+		p.FieldCfg = unit("bytes")
+	case strings.HasSuffix(metric.Name, "_seconds_total"):
+		p.FieldCfg = unit("percentunit")
+	case strings.HasSuffix(metric.Name, "_seconds"):
+		p.FieldCfg = unit("s")
+	}
+
+	return p
+}
+
+func unit(name string) *fieldConfig {
+	cfg := &fieldConfig{}
+	cfg.Defaults.Unit = name
+	return cfg
+}
+
+// writeDashboard writes the dashboard JSON of the metrics of e, ready to
+// import into Grafana.
+func writeDashboard(w io.Writer, e *exporter.Exporter, namespace string) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(dashboard(e, namespace))
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/f1shl3gs/libvirt_exporter/exporter"
+)
+
+// the JSON report of the last collection of every host
+type (
+	scrapeReport struct {
+		URI        string            `json:"uri"`
+		Time       *time.Time        `json:"time,omitempty"`
+		Duration   float64           `json:"duration_seconds"`
+		Error      string            `json:"error,omitempty"`
+		Collectors []string          `json:"collectors"`
+		Failed     map[string]string `json:"failed_collectors,omitempty"`
+		Domains    []domainReport    `json:"domains"`
+		Phases     []phaseReport     `json:"phases"`
+	}
+
+	phaseReport struct {
+		Name     string  `json:"phase"`
+		Duration float64 `json:"duration_seconds"`
+	}
+
+	domainReport struct {
+		Name     string  `json:"domain"`
+		UUID     string  `json:"uuid"`
+		Duration float64 `json:"duration_seconds"`
+		Error    string  `json:"error,omitempty"`
+	}
+)
+
+// scrapeReportHandler serves the last collection of every host as JSON,
+// the domains are sorted from the slowest, so support cases could be
+// looked into without digging the logs.
+func scrapeReportHandler(lc *exporter.MultiExporter) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		exporters := lc.Exporters()
+		reports := make([]scrapeReport, 0, len(exporters))
+		for _, e := range exporters {
+			last := e.LastScrape()
+			report := scrapeReport{
+				URI:        e.URI(),
+				Duration:   last.Duration.Seconds(),
+				Error:      errorString(last.Err),
+				Collectors: last.Collectors,
+				Domains:    make([]domainReport, 0, len(last.Domains)),
+				Phases:     make([]phaseReport, 0, len(last.Phases)),
+			}
+			if !last.Time.IsZero() {
+				report.Time = &last.Time
+			}
+			if len(last.Failed) > 0 {
+				report.Failed = make(map[string]string, len(last.Failed))
+				for name, err := range last.Failed {
+					report.Failed[name] = err.Error()
+				}
+			}
+			for _, d := range last.Domains {
+				report.Domains = append(report.Domains, domainReport{
+					Name:     d.Name,
+					UUID:     d.UUID,
+					Duration: d.Duration.Seconds(),
+					Error:    errorString(d.Err),
+				})
+			}
+			for _, p := range last.Phases {
+				report.Phases = append(report.Phases, phaseReport{Name: p.Name, Duration: p.Duration.Seconds()})
+			}
+			sort.SliceStable(report.Domains, func(i, j int) bool {
+				return report.Domains[i].Duration > report.Domains[j].Duration
+			})
+
+			reports = append(reports, report)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(reports); err != nil {
+			log.Printf("encode scrape report failed, %s\n", err)
+		}
+	})
+}
+
+func errorString(err error) string {
+	if err == nil {
+		return ""
+	}
+
+	return err.Error()
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/f1shl3gs/libvirt_exporter/exporter"
+)
+
+// discoverer resolves the hypervisors to collect
+type discoverer interface {
+	discover() ([]exporter.Target, error)
+}
+
+// targetURI builds the libvirt URI of the discovered host, e.g.
+// qemu+tls://hv01:16514/system
+func targetURI(scheme, host string, port int) string {
+	host = strings.TrimSuffix(host, ".")
+	if port > 0 {
+		host = net.JoinHostPort(host, strconv.Itoa(port))
+	}
+
+	return scheme + "://" + host + "/system"
+}
+
+// dnsSRV discovers the hypervisors by DNS SRV records, e.g.
+// _libvirt._tcp.example.com
+type dnsSRV struct {
+	names  []string
+	scheme string
+}
+
+func (d *dnsSRV) discover() ([]exporter.Target, error) {
+	var targets []exporter.Target
+	for _, name := range d.names {
+		_, records, err := net.LookupSRV("", "", name)
+		if err != nil {
+			return nil, fmt.Errorf("lookup SRV record %s failed, %s", name, err)
+		}
+
+		for _, record := range records {
+			targets = append(targets, exporter.Target{
+				URI: targetURI(d.scheme, record.Target, int(record.Port)),
+			})
+		}
+	}
+
+	return targets, nil
+}
+
+// consul discovers the healthy instances of a Consul service
+type consul struct {
+	server  string
+	service string
+	tag     string
+	scheme  string
+	client  *http.Client
+}
+
+type consulEntry struct {
+	Node struct {
+		Node    string
+		Address string
+	}
+	Service struct {
+		Address string
+		Port    int
+	}
+}
+
+func (c *consul) discover() ([]exporter.Target, error) {
+	server := c.server
+	if !strings.Contains(server, "://") {
+		server = "http://" + server
+	}
+
+	query := url.Values{"passing": []string{"true"}}
+	if c.tag != "" {
+		query.Set("tag", c.tag)
+	}
+
+	resp, err := c.client.Get(server + "/v1/health/service/" + url.PathEscape(c.service) + "?" + query.Encode())
+	if err != nil {
+		return nil, fmt.Errorf("query consul failed, %s", err)
+	}
+
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("query consul failed, %s", resp.Status)
+	}
+
+	var entries []consulEntry
+	if err = json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+		return nil, fmt.Errorf("decode consul response failed, %s", err)
+	}
+
+	targets := make([]exporter.Target, 0, len(entries))
+	for _, entry := range entries {
+		// the service address defaults to the address of the node
+		address := entry.Service.Address
+		if address == "" {
+			address = entry.Node.Address
+		}
+
+		targets = append(targets, exporter.Target{
+			URI: targetURI(c.scheme, address, entry.Service.Port),
+		})
+	}
+
+	return targets, nil
+}
+
+// discoverTargets refreshes the targets every interval, the targets are
+// kept if the discovery fails.
+func discoverTargets(d discoverer, interval time.Duration, lc *exporter.MultiExporter) {
+	for range time.Tick(interval) {
+		targets, err := d.discover()
+		if err != nil {
+			log.Printf("discover targets failed, %s\n", err)
+			continue
+		}
+
+		lc.SetTargets(targets)
+	}
+}
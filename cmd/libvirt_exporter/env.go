@@ -0,0 +1,39 @@
+package main
+
+import (
+	"os"
+
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+// explicitFlags returns the names of the flags given on the command line
+// or by environment variables, which kingpin names after the flags
+// prefixed with LIBVIRT_EXPORTER_, e.g. LIBVIRT_EXPORTER_WEB_LISTEN_ADDRESS
+// for web.listen-address. They take precedence over the config files, so
+// the precedence is flag > env > config file.
+func explicitFlags(app *kingpin.Application, args []string) map[string]bool {
+	explicit := make(map[string]bool)
+
+	// args are parsed already, so parsing them again won't fail
+	if ctx, err := app.ParseContext(args); err == nil {
+		for _, element := range ctx.Elements {
+			if flag, ok := element.Clause.(*kingpin.FlagClause); ok {
+				explicit[flag.Model().Name] = true
+			}
+		}
+	}
+
+	model := app.Model()
+	flags := model.Flags
+	for _, cmd := range model.Commands {
+		flags = append(flags, cmd.Flags...)
+	}
+
+	for _, flag := range flags {
+		if flag.Envar != "" && os.Getenv(flag.Envar) != "" {
+			explicit[flag.Name] = true
+		}
+	}
+
+	return explicit
+}
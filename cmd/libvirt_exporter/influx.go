@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+var (
+	measurementEscaper = strings.NewReplacer(",", `\,`, " ", `\ `)
+	tagEscaper         = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+)
+
+// encodeInflux encodes the samples in the InfluxDB line protocol, every
+// sample is a point of the measurement named after it, with the labels
+// as tags and the value in the value field. NaN and infinite values are
+// skipped, InfluxDB can't store them.
+func encodeInflux(samples []sample, now time.Time) []byte {
+	var buf bytes.Buffer
+	for _, s := range samples {
+		if math.IsNaN(s.value) || math.IsInf(s.value, 0) {
+			continue
+		}
+
+		timestamp := now.UnixNano()
+		if s.timestamp != 0 {
+			timestamp = s.timestamp * int64(time.Millisecond)
+		}
+
+		buf.WriteString(measurementEscaper.Replace(s.name))
+		for _, l := range s.labels {
+			// empty tag values are not allowed
+			if l.value == "" {
+				continue
+			}
+
+			buf.WriteByte(',')
+			buf.WriteString(tagEscaper.Replace(l.name))
+			buf.WriteByte('=')
+			buf.WriteString(tagEscaper.Replace(l.value))
+		}
+
+		buf.WriteString(" value=")
+		buf.WriteString(strconv.FormatFloat(s.value, 'g', -1, 64))
+		buf.WriteByte(' ')
+		buf.WriteString(strconv.FormatInt(timestamp, 10))
+		buf.WriteByte('\n')
+	}
+
+	return buf.Bytes()
+}
+
+// influxWriter pushes the metrics to the write endpoint of InfluxDB, e.g.
+// http://influxdb:8086/write?db=libvirt or /api/v2/write?org=o&bucket=b
+type influxWriter struct {
+	pusher
+}
+
+func (w *influxWriter) write(families []*dto.MetricFamily, now time.Time) error {
+	return w.post(encodeInflux(flatten(families), now), http.Header{
+		"Content-Type": []string{"text/plain; charset=utf-8"},
+	})
+}
+
+// influxHandler serves the metrics in the InfluxDB line protocol, e.g.
+// for the http input of Telegraf. Like /metrics, the collection is
+// canceled once the client goes away.
+func influxHandler(gatherer func(ctx context.Context) prometheus.Gatherer) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		families, err := gatherer(r.Context()).Gather()
+		if err != nil {
+			http.Error(w, "An error has occurred while serving metrics:\n\n"+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write(encodeInflux(flatten(families), time.Now()))
+	})
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"html/template"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/common/version"
+
+	"github.com/f1shl3gs/libvirt_exporter/exporter"
+)
+
+var landingTemplate = template.Must(template.New("landing").Parse(`<html>
+<head><title>Libvirt Exporter</title></head>
+<body>
+<h1>Libvirt Exporter</h1>
+<p><a href="{{ .MetricsPath }}">Metrics</a></p>
+<h2>Build</h2>
+<table>
+<tr><td>Version</td><td>{{ .Version }}</td></tr>
+<tr><td>Revision</td><td>{{ .Revision }}</td></tr>
+<tr><td>Build date</td><td>{{ .BuildDate }}</td></tr>
+<tr><td>Go version</td><td>{{ .GoVersion }}</td></tr>
+</table>
+<h2>Status</h2>
+<table>
+<tr><td>Collectors</td><td>{{ range $i, $name := .Collectors }}{{ if $i }}, {{ end }}{{ $name }}{{ end }}</td></tr>
+</table>
+<h2>Hypervisors</h2>
+<table>
+<tr><th>Libvirt URI</th><th>Last scrape</th><th>Duration</th><th>Result</th></tr>
+{{- range .Hosts }}
+<tr><td>{{ .URI }}</td>
+{{- with .LastScrape }}
+{{- if .Time.IsZero }}
+<td>never</td><td></td><td></td>
+{{- else }}
+<td>{{ .Time.Format "2006-01-02T15:04:05Z07:00" }}</td><td>{{ .Duration }}</td><td>{{ if .Err }}failed, {{ .Err }}{{ else }}success{{ end }}</td>
+{{- end }}
+{{- end }}
+</tr>
+{{- end }}
+</table>
+</body>
+</html>
+`))
+
+// landingPage shows the build info and the collection status, so the
+// exporter could be checked on the host without a Prometheus server.
+func landingPage(metricsPath string, lc *exporter.MultiExporter) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+
+		var collectors []string
+		exporters := lc.Exporters()
+		if len(exporters) > 0 {
+			collectors = exporters[0].EnabledCollectors()
+		}
+
+		hosts := make([]map[string]interface{}, 0, len(exporters))
+		for _, e := range exporters {
+			last := e.LastScrape()
+			last.Duration = last.Duration.Round(time.Millisecond)
+			hosts = append(hosts, map[string]interface{}{
+				"URI":        e.URI(),
+				"LastScrape": last,
+			})
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		err := landingTemplate.Execute(w, map[string]interface{}{
+			"MetricsPath": metricsPath,
+			"Version":     version.Version,
+			"Revision":    version.Revision,
+			"BuildDate":   version.BuildDate,
+			"GoVersion":   version.GoVersion,
+			"Collectors":  collectors,
+			"Hosts":       hosts,
+		})
+		if err != nil {
+			log.Printf("render landing page failed, %s\n", err)
+		}
+	})
+}
@@ -1,58 +1,667 @@
 package main
 
 import (
-	"flag"
+	"context"
+	"crypto/tls"
+	"expvar"
+	"fmt"
 	"log"
 	"net"
 	"net/http"
+	"net/http/pprof"
 	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/NYTimes/gziphandler"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/common/version"
+	"gopkg.in/alecthomas/kingpin.v2"
 
 	"github.com/f1shl3gs/libvirt_exporter/exporter"
 )
 
 func main() {
+	app := kingpin.New("libvirt_exporter", "Prometheus exporter of libvirt hypervisors and their domains.").DefaultEnvars()
+	app.Version(version.Print("libvirt_exporter"))
+	app.HelpFlag.Short('h')
+
+	// serve is run if no command is given, so the exporter starts as before
 	var (
-		listenAddress = flag.String("web.listen-address", ":5900", "Address to listen on for web interface and telemetry.")
-		metricsPath   = flag.String("web.telemetry-path", "/metrics", "Path under which to expose metrics.")
-		libvirtURI    = flag.String("libvirt.uri", "/var/run/libvirt/libvirt-sock", "Libvirt URI from which to extract metrics.")
-		namespace     = flag.String("namespace", "libvirt", "Namespace for metrics")
-		compress      = flag.Bool("web.gzip", true, "Enable gzip for http response")
+		serveCmd     = app.Command("serve", "Serve the metrics over HTTP, and push them if configured").Default()
+		scrapeCmd    = app.Command("scrape", "Collect once, print the metrics to stdout and exit")
+		checkCmd     = app.Command("check", "Dial, connect and list the domains of every hypervisor, and diagnose failures")
+		validateCmd  = app.Command("validate-config", "Check the config files and libvirt URIs without connecting, and exit")
+		listCmd      = app.Command("list-domains", "List the domains of every hypervisor and whether they are collected")
+		dashboardCmd = app.Command("dashboard", "Print the Grafana dashboard of the metrics of the enabled collectors")
+		rulesCmd     = app.Command("rules", "Print the recommended Prometheus recording and alerting rules")
+		versionCmd   = app.Command("version", "Print the version and exit")
 	)
-	flag.Parse()
-
-	lc := exporter.NewExporter(*libvirtURI, exporter.WithNamespace(*namespace))
-
-	prometheus.MustRegister(lc)
-	http.Handle(*metricsPath, promhttp.Handler())
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		w.Write([]byte(`
-			<html>
-			<head><title>Libvirt Exporter</title></head>
-			<body>
-			<h1>Libvirt Exporter</h1>
-			<p><a href='` + *metricsPath + `'>Metrics</a></p>
-			</body>
-			</html>`))
-	})
-
-	var handler http.Handler = http.DefaultServeMux
-	if *compress {
-		handler = gziphandler.GzipHandler(http.DefaultServeMux)
+
+	// the flags of collecting, shared by the commands
+	var (
+		libvirtURIs   = listFlag{}
+		namespace     = app.Flag("namespace", "Namespace for metrics").Default("libvirt").String()
+		srvNames      = listFlag{}
+		consulServer  = app.Flag("discovery.consul-server", "Address of the Consul agent").Default("localhost:8500").String()
+		consulService = app.Flag("discovery.consul-service", "Consul service of the hypervisors to collect, its healthy instances are collected").String()
+		consulTag     = app.Flag("discovery.consul-tag", "Tag the instances of the Consul service must have").String()
+		discoverAs    = app.Flag("discovery.scheme", "Scheme of the libvirt URIs of the discovered hypervisors").Default("qemu+tls").String()
+		discoverEvery = app.Flag("discovery.refresh-interval", "Interval of refreshing the discovered hypervisors").Default("1m").Duration()
+		hostTimeout   = app.Flag("libvirt.timeout", "Timeout of collecting a hypervisor, 0 means no timeout").Default("0s").Duration()
+		rpcTimeout    = app.Flag("libvirt.rpc-timeout", "Timeout of every libvirt RPC, e.g. of a domain with a stuck qemu monitor, 0 means no timeout").Default("10s").Duration()
+		scrapeBudget  = app.Flag("scrape.timeout", "Time budget of collecting all hypervisors, the ones not collected in time are reported down, 0 means no limit").Default("0s").Duration()
+		slowScrape    = app.Flag("scrape.slow-threshold", "Log a warning with the slowest domains and phases of every collection of a hypervisor taking longer, 0 means never").Default("0s").Duration()
+		aggregate     = app.Flag("cluster.aggregate", "Add the series aggregated over all hypervisors, e.g. running domains and their vCPUs and memory").Bool()
+		targetsFile   = app.Flag("targets.file", "Path of the file listing the hypervisors to collect and their labels, in the format of Prometheus file based service discovery, reloaded on SIGHUP or, with --web.enable-lifecycle, a POST to /-/reload too, it overrides --libvirt.uri").String()
+		targetsCheck  = app.Flag("targets.refresh-interval", "Interval to check the targets file for changes").Default("30s").Duration()
+		legacyNames   = app.Flag("metrics.legacy-names", "Expose the renamed metrics under their former names too, for the transition of dashboards and alerts").Bool()
+		once          = app.Flag("once", "Collect once, print the metrics to stdout and exit, same as the scrape command").Bool()
+		configFile    = app.Flag("config.file", "Path of the config file, it is reloaded on SIGHUP or, with --web.enable-lifecycle, a POST to /-/reload").String()
+		includes      = app.Flag("domain.include", "Regexp of domain names to collect, all domains are collected if empty").String()
+		excludes      = app.Flag("domain.exclude", "Regexp of domain names not to collect").String()
+		matchUUID     = app.Flag("domain.match-uuid", "Match domain UUIDs against the include and exclude regexps too").Bool()
+		titles        = app.Flag("domain.title", "Regexp of domain titles to collect").String()
+		metadata      = metadataFlag{}
+		labels        = labelsFlag{}
+		inactive      = app.Flag("domain.include-inactive", "Collect defined but not running domains").Default("true").Bool()
+		inactiveDevs  = app.Flag("domain.inactive-devices", "Report the block and interface statistics of inactive domains as zeros, they are omitted by default").Bool()
+		maxDomains    = app.Flag("domain.max", "Maximum number of domains collected per scrape, 0 means unlimited").Default("0").Int()
+		volumes       = app.Flag("storage.volumes", "Enable per-volume metrics of active storage pools").Bool()
+		volumesLimit  = app.Flag("storage.volumes-limit", "Maximum number of volumes reported per storage pool, the ones with the largest allocation, 0 means unlimited").Default("100").Int()
+		leaseInfo     = app.Flag("network.dhcp-lease-info", "Enable the info metric of DHCP leases of virtual networks").Bool()
+		deviceInfo    = app.Flag("nodedev.info", "Enable the info metric of passthrough capable node devices").Bool()
+		agentTimeout  = app.Flag("guest-agent.timeout", "Timeout of every guest agent command").Default("2s").Duration()
+		agentInFlight = app.Flag("guest-agent.max-in-flight", "Maximum number of guest agent commands running at the same time").Default("8").Int()
+		agentBudget   = app.Flag("guest-agent.budget", "Time the guest agent commands of a collection could take altogether, 0 means no limit").Default("10s").Duration()
+		dirtyRateCalc = app.Flag("dirty-rate.calc-time", "Time the dirty rate of domains is measured for after every collection, in whole seconds").Default("1s").Duration()
+	)
+
+	app.Flag("libvirt.uri", "Libvirt URI from which to extract metrics, either the path of the unix socket or a URI like qemu+tcp://hv01/system, could be repeated or comma separated to collect several hypervisors (default the detected libvirt socket)").SetValue(&libvirtURIs)
+	app.Flag("discovery.dns-srv", "DNS SRV record of the hypervisors to collect, e.g. _libvirt._tcp.example.com, could be repeated or comma separated").SetValue(&srvNames)
+	app.Flag("labels", "Labels attached to all metrics, in the form of name=value, could be repeated or comma separated").SetValue(labels)
+	app.Flag("domain.metadata", "Collect domains whose metadata element or attribute matches, in the form of name=regexp, could be repeated").SetValue(metadata)
+
+	collectors := make(map[string]*bool, len(exporter.Collectors))
+	for name, enabled := range exporter.Collectors {
+		collectors[name] = app.Flag("collector."+name, fmt.Sprintf("Enable the %s collector", name)).Default(fmt.Sprint(enabled)).Bool()
+	}
+
+	// the flags of serving and pushing
+	var (
+		listenAddrs   = listFlag{}
+		metricsPath   = serveCmd.Flag("web.telemetry-path", "Path under which to expose metrics.").Default("/metrics").String()
+		compress      = serveCmd.Flag("web.gzip", "Enable gzip for http response").Default("true").Bool()
+		maxRequests   = serveCmd.Flag("web.max-requests", "Maximum number of scrapes served at the same time, excess ones are rejected with 503, 0 means unlimited").Default("40").Int()
+		enablePprof   = serveCmd.Flag("web.enable-pprof", "Enable the profiling endpoints under /debug/pprof").Bool()
+		enableExpvar  = serveCmd.Flag("web.enable-expvar", "Enable the internal counters under /debug/vars").Bool()
+		enableReload  = serveCmd.Flag("web.enable-lifecycle", "Enable reloading the config by a POST to /-/reload").Bool()
+		systemdSocket = serveCmd.Flag("web.systemd-socket", "Use the sockets passed by systemd socket activation instead of --web.listen-address").Bool()
+		serveWeb      = addWebFlags(serveCmd)
+		probeTimeout  = serveCmd.Flag("probe.timeout", "Timeout of /probe if Prometheus doesn't tell its scrape timeout").Default("10s").Duration()
+		probeOffset   = serveCmd.Flag("probe.timeout-offset", "Offset subtracted from the scrape timeout of Prometheus, left for sending the response of /probe").Default("500ms").Duration()
+		pushURL       = serveCmd.Flag("push.remote-write-url", "URL of the Prometheus remote write endpoint the metrics are pushed to every --push.interval").String()
+		pushOTLP      = serveCmd.Flag("push.otlp-endpoint", "Endpoint of the OTLP receiver of an OpenTelemetry collector the metrics are pushed to every --push.interval, e.g. http://collector:4318, or collector:4317 for gRPC").String()
+		pushOTLPProto = serveCmd.Flag("push.otlp-protocol", "Protocol of --push.otlp-endpoint, one of grpc, http/protobuf or http/json").Default(otlpHTTPJSON).Enum(otlpGRPC, otlpHTTPProtobuf, otlpHTTPJSON)
+		traceOTLP     = serveCmd.Flag("tracing.otlp-endpoint", "Endpoint of the OTLP/HTTP receiver of an OpenTelemetry collector the traces of the collections are sent to, e.g. http://collector:4318").String()
+		pushGateway   = serveCmd.Flag("push.gateway", "URL of the Pushgateway the metrics are pushed to every --push.interval").String()
+		pushJob       = serveCmd.Flag("push.job", "Job name of the metrics pushed to the Pushgateway").Default("libvirt").String()
+		pushGrouping  = labelsFlag{}
+		textfilePath  = serveCmd.Flag("textfile.path", "Path of the .prom file the metrics are written to every --textfile.interval for the textfile collector of node_exporter, nothing is listened on unless --web.listen-address is given").String()
+		textfileEvery = serveCmd.Flag("textfile.interval", "Interval of writing the textfile").Default("15s").Duration()
+		pushInflux    = serveCmd.Flag("push.influx-url", "URL of the write endpoint of InfluxDB the metrics are pushed to every --push.interval in the line protocol, e.g. http://influxdb:8086/write?db=libvirt").String()
+		statsdAddr    = serveCmd.Flag("push.statsd-address", "Address of the statsd server the gauges and counters are sent to every --push.interval, e.g. localhost:8125").String()
+		statsdPrefix  = serveCmd.Flag("push.statsd-prefix", "Prefix of the statsd metric names").String()
+		dogstatsd     = serveCmd.Flag("push.statsd-dogstatsd", "Send the labels as DogStatsD tags instead of appending them to the statsd metric names").Bool()
+		pushInterval  = serveCmd.Flag("push.interval", "Interval of pushing the metrics").Default("15s").Duration()
+		pushTimeout   = serveCmd.Flag("push.timeout", "Timeout of every push").Default("10s").Duration()
+		pushRetries   = serveCmd.Flag("push.retries", "Number of retries of a failed push, with exponential backoff").Default("3").Int()
+		pushUsername  = serveCmd.Flag("push.basic-auth-username", "Username of the basic auth of pushes").String()
+		pushPassword  = serveCmd.Flag("push.basic-auth-password-file", "Path of the file containing the basic auth password of pushes").String()
+		pushToken     = serveCmd.Flag("push.bearer-token-file", "Path of the file containing the bearer token of pushes").String()
+		rwURL         = serveCmd.Flag("remote-write.url", "URL of the Prometheus remote write endpoint the samples of every scrape are mirrored to").String()
+		rwTimeout     = serveCmd.Flag("remote-write.timeout", "Timeout of every remote write").Default("10s").Duration()
+		rwUsername    = serveCmd.Flag("remote-write.basic-auth-username", "Username of the basic auth of remote writes").String()
+		rwPassword    = serveCmd.Flag("remote-write.basic-auth-password-file", "Path of the file containing the basic auth password of remote writes").String()
+		rwToken       = serveCmd.Flag("remote-write.bearer-token-file", "Path of the file containing the bearer token of remote writes").String()
+		pluginsDir    = serveCmd.Flag("plugins.dir", "Directory of the executables run on every scrape, whose output in the text exposition format is merged into the metrics").String()
+		pluginsWait   = serveCmd.Flag("plugins.timeout", "Timeout of every plugin run").Default("5s").Duration()
+	)
+
+	serveCmd.Flag("web.listen-address", "Address to listen on for web interface and telemetry, or unix:///path/to/socket to listen on a unix domain socket, could be repeated or comma separated (default :5900)").SetValue(&listenAddrs)
+	serveCmd.Flag("push.grouping", "Grouping labels of the metrics pushed to the Pushgateway, in the form of name=value, could be repeated or comma separated (default instance=<hostname>)").SetValue(pushGrouping)
+
+	validateWeb := addWebFlags(validateCmd)
+
+	command := kingpin.MustParse(app.Parse(os.Args[1:]))
+	if command == versionCmd.FullCommand() {
+		fmt.Println(version.Print("libvirt_exporter"))
+		return
+	}
+	if *once {
+		command = scrapeCmd.FullCommand()
+	}
+
+	explicit := explicitFlags(app, os.Args[1:])
+
+	opts := []exporter.Option{
+		exporter.WithNamespace(*namespace),
+		exporter.WithInactiveDomains(*inactive),
+		exporter.WithInactiveDevices(*inactiveDevs),
+		exporter.WithMaxDomains(*maxDomains),
+		exporter.WithTimeout(*hostTimeout),
+		exporter.WithRPCTimeout(*rpcTimeout),
+		exporter.WithSlowScrapeThreshold(*slowScrape),
+		exporter.WithConstLabels(prometheus.Labels(labels)),
+	}
+	if *volumes {
+		opts = append(opts, exporter.WithStorageVolumes(*volumesLimit))
+	}
+	if *leaseInfo {
+		opts = append(opts, exporter.WithDHCPLeaseInfo())
+	}
+	if *deviceInfo {
+		opts = append(opts, exporter.WithNodeDeviceInfo())
+	}
+	for name, enabled := range collectors {
+		opts = append(opts, exporter.WithCollector(name, *enabled))
+	}
+	if *collectors["guest-agent"] {
+		opts = append(opts, exporter.WithGuestAgent(*agentTimeout, *agentInFlight), exporter.WithGuestAgentBudget(*agentBudget))
+	}
+	if *collectors["dirty-rate"] {
+		opts = append(opts, exporter.WithDirtyRate(*dirtyRateCalc))
+	}
+	if *traceOTLP != "" {
+		// the traces share the auth and retries with the pushes
+		opts = append(opts, exporter.WithSpanExporter(newSpanWriter(pusher{
+			url:          otlpEndpoint(*traceOTLP, "traces"),
+			username:     *pushUsername,
+			passwordFile: *pushPassword,
+			tokenFile:    *pushToken,
+			retries:      *pushRetries,
+			timeout:      *pushTimeout,
+		})))
+	}
+
+	if command == validateCmd.FullCommand() {
+		v := &validation{
+			configFile:    *configFile,
+			webConfigFile: *validateWeb.configFile,
+			targetsFile:   *targetsFile,
+			uris:          libvirtURIs,
+			include:       *includes,
+			exclude:       *excludes,
+			title:         *titles,
+			tlsCertFile:   *validateWeb.tlsCertFile,
+			tlsKeyFile:    *validateWeb.tlsKeyFile,
+			tlsClientCA:   *validateWeb.tlsClientCA,
+			authUsers:     *validateWeb.authUsers,
+			authToken:     *validateWeb.authToken,
+		}
+		os.Exit(v.run(os.Stdout))
+	}
+
+	if command == dashboardCmd.FullCommand() {
+		if err := writeDashboard(os.Stdout, exporter.NewExporter("", opts...), *namespace); err != nil {
+			log.Printf("write dashboard failed, %s\n", err)
+			os.Exit(1)
+		}
+
+		return
 	}
 
-	listener, err := net.Listen("tcp", *listenAddress)
-	if err != nil {
-		log.Printf("listen to %s failed, %s\n", *listenAddress, err)
+	if command == rulesCmd.FullCommand() {
+		if err := writeRules(os.Stdout, exporter.NewExporter("", opts...), *namespace); err != nil {
+			log.Printf("write rules failed, %s\n", err)
+			os.Exit(1)
+		}
+
+		return
+	}
+
+	if len(libvirtURIs) == 0 {
+		socket, ok := exporter.DetectSocket()
+		if ok {
+			log.Printf("libvirt socket %s detected\n", socket)
+		} else {
+			socket = "/var/run/libvirt/libvirt-sock"
+			log.Printf("no libvirt socket found, %s is used\n", socket)
+		}
+
+		libvirtURIs = listFlag{socket}
+	}
+
+	lc := exporter.NewMultiExporter(libvirtURIs, opts...)
+	lc.SetBudget(*scrapeBudget)
+	if *aggregate {
+		lc.EnableAggregation()
+	}
+	if *targetsFile != "" {
+		// the targets are loaded along with the config below
+		go watchTargets(*targetsFile, *targetsCheck, lc)
+	}
+
+	var discovery discoverer
+	switch {
+	case len(srvNames) > 0 && *consulService != "":
+		log.Printf("DNS SRV and Consul discovery could not be used at the same time\n")
 		os.Exit(1)
+	case len(srvNames) > 0:
+		discovery = &dnsSRV{names: srvNames, scheme: *discoverAs}
+	case *consulService != "":
+		discovery = &consul{
+			server:  *consulServer,
+			service: *consulService,
+			tag:     *consulTag,
+			scheme:  *discoverAs,
+			client:  &http.Client{Timeout: 10 * time.Second},
+		}
+	}
+
+	if discovery != nil {
+		if *targetsFile != "" {
+			log.Printf("discovery could not be used along with --targets.file\n")
+			os.Exit(1)
+		}
+
+		targets, err := discovery.discover()
+		if err != nil {
+			log.Printf("discover targets failed, %s\n", err)
+			os.Exit(1)
+		}
+
+		lc.SetTargets(targets)
+		go discoverTargets(discovery, *discoverEvery, lc)
+	}
+	relabeler := &exporter.Relabeler{}
+	if *legacyNames {
+		relabeler.EnableLegacyNames(*namespace)
+	}
+
+	tlsCerts := &tlsReloader{}
+	auth := &authenticator{}
+
+	var reloadMtx sync.Mutex
+	reload := func() error {
+		reloadMtx.Lock()
+		defer reloadMtx.Unlock()
+
+		web, err := loadWebConfig(*serveWeb.configFile)
+		if err != nil {
+			return err
+		}
+
+		// flags and environment variables take precedence over the web config file
+		if explicit["web.tls-cert-file"] {
+			web.TLSServerConfig.CertFile = *serveWeb.tlsCertFile
+		}
+		if explicit["web.tls-key-file"] {
+			web.TLSServerConfig.KeyFile = *serveWeb.tlsKeyFile
+		}
+		if explicit["web.tls-client-ca-file"] {
+			web.TLSServerConfig.ClientCAFile = *serveWeb.tlsClientCA
+		}
+		if explicit["web.basic-auth-users-file"] {
+			if web.BasicAuthUsers, err = loadUsersFile(*serveWeb.authUsers); err != nil {
+				return err
+			}
+		}
+
+		if err = tlsCerts.load(web.TLSServerConfig); err != nil {
+			return err
+		}
+
+		if err = auth.load(web.BasicAuthUsers, *serveWeb.authToken); err != nil {
+			return err
+		}
+
+		cfg, err := loadConfig(*configFile)
+		if err != nil {
+			return err
+		}
+
+		// flags and environment variables take precedence over the config file
+		if explicit["domain.include"] {
+			cfg.Domain.Include = *includes
+		}
+		if explicit["domain.exclude"] {
+			cfg.Domain.Exclude = *excludes
+		}
+		if explicit["domain.match-uuid"] {
+			cfg.Domain.MatchUUID = *matchUUID
+		}
+		if explicit["domain.title"] {
+			cfg.Domain.Title = *titles
+		}
+		if explicit["domain.metadata"] {
+			cfg.Domain.Metadata = metadata
+		}
+
+		filter, err := cfg.Domain.Compile()
+		if err != nil {
+			return fmt.Errorf("invalid domain filter, %s", err)
+		}
+
+		if err = relabeler.Update(cfg.Relabel); err != nil {
+			return fmt.Errorf("invalid relabel config, %s", err)
+		}
+
+		lc.SetDomainFilter(filter)
+
+		// the targets file is reloaded as well, rather than waiting
+		// for the next check of --targets.refresh-interval
+		if *targetsFile != "" {
+			targets, err := loadTargets(*targetsFile)
+			if err != nil {
+				return fmt.Errorf("load targets failed, %s", err)
+			}
+
+			lc.SetTargets(targets)
+		}
+
+		return nil
 	}
 
-	log.Printf("Libvirt exporter started, listening at %s\n", *listenAddress)
-	if err = http.Serve(listener, handler); err != nil {
+	if err := reload(); err != nil {
+		log.Printf("load config failed, %s\n", err)
+		os.Exit(1)
+	}
+
+	switch command {
+	case scrapeCmd.FullCommand():
+		if err := scrapeOnce(os.Stdout, lc, relabeler); err != nil {
+			log.Printf("scrape failed, %s\n", err)
+			os.Exit(1)
+		}
+
+		return
+	case checkCmd.FullCommand():
+		os.Exit(checkHosts(os.Stdout, lc))
+	case listCmd.FullCommand():
+		os.Exit(listDomains(os.Stdout, lc))
+	}
+
+	go func() {
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+
+		for range hup {
+			if err := reload(); err != nil {
+				log.Printf("reload config failed, %s\n", err)
+				continue
+			}
+
+			log.Printf("config reloaded\n")
+		}
+	}()
+
+	// lc is gathered apart from the default registry, so the scrapes
+	// over HTTP collect it with the context of the request
+	prometheus.MustRegister(newBuildInfo())
+	var extras *plugins
+	if *pluginsDir != "" {
+		extras = newPlugins(*pluginsDir, *pluginsWait, *namespace)
+	}
+	gatherAll := func(ctx context.Context) prometheus.Gatherer {
+		registry := prometheus.NewRegistry()
+		registry.MustRegister(lc.WithContext(ctx))
+		gatherers := prometheus.Gatherers{prometheus.DefaultGatherer, registry}
+		if extras != nil {
+			gatherers = append(gatherers, extras.gatherer(ctx))
+		}
+		return gatherers
+	}
+
+	var writers []metricsWriter
+	push := pusher{
+		username:     *pushUsername,
+		passwordFile: *pushPassword,
+		tokenFile:    *pushToken,
+		retries:      *pushRetries,
+		timeout:      *pushTimeout,
+	}
+	if *pushURL != "" {
+		rw := &remoteWriter{pusher: push}
+		rw.url = *pushURL
+		writers = append(writers, rw)
+	}
+	if *pushOTLP != "" {
+		ow, err := newOTLPWriter(push, *pushOTLP, *pushOTLPProto)
+		if err != nil {
+			log.Printf("create otlp writer failed, %s\n", err)
+			os.Exit(1)
+		}
+		writers = append(writers, ow)
+	}
+	if *pushInflux != "" {
+		iw := &influxWriter{pusher: push}
+		iw.url = *pushInflux
+		writers = append(writers, iw)
+	}
+	if *statsdAddr != "" {
+		writers = append(writers, &statsdWriter{
+			address:   *statsdAddr,
+			prefix:    *statsdPrefix,
+			dogstatsd: *dogstatsd,
+		})
+	}
+	if *pushGateway != "" {
+		if len(pushGrouping) == 0 {
+			hostname, err := os.Hostname()
+			if err != nil {
+				log.Printf("get hostname failed, %s\n", err)
+				os.Exit(1)
+			}
+
+			pushGrouping["instance"] = hostname
+		}
+
+		pw := &pushgatewayWriter{pusher: push, job: *pushJob, grouping: pushGrouping}
+		pw.url = *pushGateway
+		writers = append(writers, pw)
+	}
+	if len(writers) > 0 {
+		go pushLoop(*pushInterval, relabeler.Gatherer(gatherAll(context.Background())), writers...)
+	}
+
+	if *textfilePath != "" {
+		// the go and process metrics would clash with node_exporter's own
+		registry := prometheus.NewRegistry()
+		registry.MustRegister(lc)
+
+		textfile := &textfileWriter{path: *textfilePath}
+		loop := func() {
+			pushLoop(*textfileEvery, relabeler.Gatherer(registry), textfile)
+		}
+
+		// nothing is listened on unless asked to, the textfile is written
+		// by the main goroutine for good then
+		if explicit["web.listen-address"] || *systemdSocket {
+			go loop()
+		} else {
+			log.Printf("Libvirt exporter started, writing to %s\n", *textfilePath)
+			if err := sdNotify("READY=1"); err != nil {
+				log.Printf("notify systemd failed, %s\n", err)
+			}
+			if interval := watchdogInterval(); interval > 0 {
+				go pingWatchdog(interval, lc)
+			}
+			loop()
+		}
+	}
+
+	// net/http/pprof registers itself to http.DefaultServeMux, so a
+	// dedicated mux keeps the profiles hidden unless they are enabled
+	mux := http.NewServeMux()
+	limited := limitRequests(*maxRequests)
+
+	mirrored := func(g prometheus.Gatherer) prometheus.Gatherer { return g }
+	if *rwURL != "" {
+		mirrored = mirror(&remoteWriter{pusher{
+			url:          *rwURL,
+			username:     *rwUsername,
+			passwordFile: *rwPassword,
+			tokenFile:    *rwToken,
+			retries:      3,
+			timeout:      *rwTimeout,
+		}})
+	}
+
+	// the rejected scrapes are instrumented too, so the overload shows up
+	// as promhttp_metric_handler_requests_total{code="503"}
+	om := &openMetrics{}
+	mux.Handle(*metricsPath, auth.wrap(promhttp.InstrumentMetricHandler(
+		prometheus.DefaultRegisterer,
+		limited(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// the collection is canceled once the client goes away
+			gatherer := gatherAll(r.Context())
+
+			// collect[] selects the collectors for this scrape only
+			if names := r.URL.Query()["collect[]"]; len(names) > 0 {
+				collector, err := lc.OnlyWithContext(r.Context(), names...)
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+
+				registry := prometheus.NewRegistry()
+				registry.MustRegister(collector)
+				gatherer = registry
+			}
+
+			gatherer = mirrored(relabeler.Gatherer(gatherer))
+			if om.accepts(r) {
+				om.serve(w, gatherer)
+				return
+			}
+
+			promhttp.HandlerFor(gatherer, promhttp.HandlerOpts{}).ServeHTTP(w, r)
+		})),
+	)))
+	mux.Handle("/probe", auth.wrap(limited(probeHandler(func() []exporter.Option {
+		return append(opts[:len(opts):len(opts)], exporter.WithDomainFilter(lc.DomainFilter()))
+	}, *probeTimeout, *probeOffset, relabeler))))
+	mux.Handle("/influx", auth.wrap(limited(influxHandler(func(ctx context.Context) prometheus.Gatherer {
+		return relabeler.Gatherer(gatherAll(ctx))
+	}))))
+	mux.Handle("/sd", auth.wrap(sdHandler(lc, tlsCerts.enabled())))
+	// anyone reaching the port could make the files be read again, it's
+	// served only if asked, like the lifecycle API of Prometheus
+	if *enableReload {
+		mux.Handle("/-/reload", auth.wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodPost {
+				w.Header().Set("Allow", http.MethodPost)
+				w.WriteHeader(http.StatusMethodNotAllowed)
+				return
+			}
+
+			if err := reload(); err != nil {
+				http.Error(w, fmt.Sprintf("failed to reload config: %s", err), http.StatusInternalServerError)
+				return
+			}
+
+			log.Printf("config reloaded\n")
+		})))
+	}
+	if *enablePprof {
+		mux.Handle("/debug/pprof/", auth.wrap(http.HandlerFunc(pprof.Index)))
+		mux.Handle("/debug/pprof/cmdline", auth.wrap(http.HandlerFunc(pprof.Cmdline)))
+		mux.Handle("/debug/pprof/profile", auth.wrap(http.HandlerFunc(pprof.Profile)))
+		mux.Handle("/debug/pprof/symbol", auth.wrap(http.HandlerFunc(pprof.Symbol)))
+		mux.Handle("/debug/pprof/trace", auth.wrap(http.HandlerFunc(pprof.Trace)))
+	}
+	if *enableExpvar {
+		mux.Handle("/debug/vars", auth.wrap(expvar.Handler()))
+	}
+	mux.Handle("/debug/scrape", auth.wrap(scrapeReportHandler(lc)))
+	mux.Handle("/", auth.wrap(landingPage(*metricsPath, lc)))
+
+	var handler http.Handler = mux
+	if *compress {
+		handler = gziphandler.GzipHandler(mux)
+	}
+
+	var (
+		listeners []net.Listener
+		err       error
+	)
+	if *systemdSocket {
+		listeners, err = systemdListeners()
+		if err != nil {
+			log.Printf("socket activation failed, %s\n", err)
+			os.Exit(1)
+		}
+
+		listenAddrs = listenAddrs[:0]
+		for _, listener := range listeners {
+			listenAddrs = append(listenAddrs, listener.Addr().String())
+		}
+	} else {
+		if len(listenAddrs) == 0 {
+			listenAddrs = listFlag{":5900"}
+		}
+
+		for _, address := range listenAddrs {
+			listener, err := listen(address)
+			if err != nil {
+				log.Printf("listen to %s failed, %s\n", address, err)
+				os.Exit(1)
+			}
+
+			listeners = append(listeners, listener)
+		}
+	}
+
+	if tlsCerts.enabled() {
+		for i, listener := range listeners {
+			listeners[i] = tls.NewListener(listener, tlsCerts.config())
+		}
+	}
+
+	// every listener is served by the same handlers, the exporter exits
+	// once any of them fails
+	errCh := make(chan error, len(listeners))
+	for _, listener := range listeners {
+		go func(listener net.Listener) {
+			errCh <- http.Serve(listener, handler)
+		}(listener)
+	}
+
+	log.Printf("Libvirt exporter started, listening at %s\n", listenAddrs.String())
+	if err = sdNotify("READY=1"); err != nil {
+		log.Printf("notify systemd failed, %s\n", err)
+	}
+	if interval := watchdogInterval(); interval > 0 {
+		go pingWatchdog(interval, lc)
+	}
+	if err = <-errCh; err != nil {
 		log.Printf("http serve failed, %s\n", err)
 		os.Exit(1)
 	}
 }
+
+// limitRequests returns a wrapper rejecting requests with 503 while n of
+// them are being served by the wrapped handlers, so scrape storms, e.g.
+// Prometheus restarts, won't flood libvirtd.
+func limitRequests(n int) func(http.Handler) http.Handler {
+	if n <= 0 {
+		return func(h http.Handler) http.Handler { return h }
+	}
+
+	inFlight := make(chan struct{}, n)
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case inFlight <- struct{}{}:
+				defer func() { <-inFlight }()
+				h.ServeHTTP(w, r)
+			default:
+				http.Error(w, fmt.Sprintf("limit of concurrent requests reached (%d), try again later", n), http.StatusServiceUnavailable)
+			}
+		})
+	}
+}
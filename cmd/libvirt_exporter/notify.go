@@ -0,0 +1,68 @@
+package main
+
+import (
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/f1shl3gs/libvirt_exporter/exporter"
+)
+
+// sdNotify sends the state to systemd, see sd_notify(3). Nothing is sent
+// unless NOTIFY_SOCKET is set, i.e. the unit is of Type=notify.
+func sdNotify(state string) error {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return nil
+	}
+
+	// abstract sockets start with @
+	if socket[0] == '@' {
+		socket = "\x00" + socket[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.Write([]byte(state))
+	return err
+}
+
+// watchdogInterval returns the interval of pinging the watchdog, half of
+// WATCHDOG_USEC like sd_watchdog_enabled(3) suggests, 0 if the watchdog
+// is not enabled for this process.
+func watchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+
+	return time.Duration(usec) * time.Microsecond / 2
+}
+
+// pingWatchdog pings the watchdog of systemd every interval unless a
+// collection is wedged, i.e. made no progress for the timeout of the
+// watchdog, twice the interval. Nothing is asked from the hypervisors, an
+// unreachable one fails its dial and RPCs in time, so it doesn't get the
+// exporter restarted.
+func pingWatchdog(interval time.Duration, lc *exporter.MultiExporter) {
+	for range time.Tick(interval) {
+		if lc.Stalled(2 * interval) {
+			log.Printf("collection is wedged, the watchdog is not pinged\n")
+			continue
+		}
+
+		if err := sdNotify("WATCHDOG=1"); err != nil {
+			log.Printf("ping watchdog failed, %s\n", err)
+		}
+	}
+}
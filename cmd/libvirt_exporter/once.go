@@ -0,0 +1,47 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+
+	"github.com/f1shl3gs/libvirt_exporter/exporter"
+)
+
+// scrapeOnce collects the hypervisors once and writes the metrics in the
+// text format, for debugging and cron based pipelines. The metrics are
+// written even if some hypervisors failed, which is reported by the
+// error then.
+func scrapeOnce(w io.Writer, lc *exporter.MultiExporter, relabeler *exporter.Relabeler) error {
+	registry := prometheus.NewRegistry()
+	if err := registry.Register(lc); err != nil {
+		return err
+	}
+
+	families, err := relabeler.Gatherer(registry).Gather()
+	if err != nil {
+		return err
+	}
+
+	bw := bufio.NewWriter(w)
+	for _, family := range families {
+		if _, err = expfmt.MetricFamilyToText(bw, family); err != nil {
+			return err
+		}
+	}
+
+	if err = bw.Flush(); err != nil {
+		return err
+	}
+
+	for _, e := range lc.Exporters() {
+		if err = e.LastScrape().Err; err != nil {
+			return fmt.Errorf("collect %s failed, %s", e.URI(), err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+// createdTracker remembers when every counter, summary and histogram was
+// seen first, or seen reset, since libvirt doesn't tell when the counters
+// were created. That's enough for the consumers relying on _created to
+// tell counter resets.
+type createdTracker struct {
+	mu     sync.Mutex
+	series map[string]*createdState
+}
+
+type createdState struct {
+	created  time.Time
+	seen     time.Time
+	previous float64
+}
+
+// series not seen for so long are forgotten
+const createdRetention = time.Hour
+
+func (t *createdTracker) created(key string, value float64, now time.Time) time.Time {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.series == nil {
+		t.series = make(map[string]*createdState)
+	}
+
+	state, ok := t.series[key]
+	if !ok || value < state.previous {
+		state = &createdState{created: now}
+		t.series[key] = state
+	}
+
+	state.seen = now
+	state.previous = value
+	return state.created
+}
+
+func (t *createdTracker) prune(now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for key, state := range t.series {
+		if now.Sub(state.seen) > createdRetention {
+			delete(t.series, key)
+		}
+	}
+}
+
+// openMetrics writes the metrics in the OpenMetrics format, including the
+// _created series of counters, summaries and histograms, which the
+// encoder of client_golang doesn't write.
+type openMetrics struct {
+	created createdTracker
+}
+
+// accepts tells whether the scraper asks for the OpenMetrics format.
+func (o *openMetrics) accepts(r *http.Request) bool {
+	return expfmt.NegotiateIncludingOpenMetrics(r.Header) == expfmt.FmtOpenMetrics
+}
+
+func (o *openMetrics) serve(w http.ResponseWriter, gatherer prometheus.Gatherer) {
+	families, err := gatherer.Gather()
+	if err != nil {
+		http.Error(w, "An error has occurred while serving metrics:\n\n"+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", string(expfmt.FmtOpenMetrics))
+	o.write(w, families, time.Now())
+}
+
+func (o *openMetrics) write(w io.Writer, families []*dto.MetricFamily, now time.Time) {
+	defer o.created.prune(now)
+
+	bw := bufio.NewWriter(w)
+	for _, family := range families {
+		o.writeFamily(bw, family, now)
+	}
+
+	bw.WriteString("# EOF\n")
+	bw.Flush()
+}
+
+func (o *openMetrics) writeFamily(w *bufio.Writer, family *dto.MetricFamily, now time.Time) {
+	name := family.GetName()
+	typ := "unknown"
+	switch family.GetType() {
+	case dto.MetricType_COUNTER:
+		// the family of counters is named without the _total suffix
+		typ = "counter"
+		name = strings.TrimSuffix(name, "_total")
+	case dto.MetricType_GAUGE:
+		typ = "gauge"
+	case dto.MetricType_SUMMARY:
+		typ = "summary"
+	case dto.MetricType_HISTOGRAM:
+		typ = "histogram"
+	}
+
+	w.WriteString("# TYPE " + name + " " + typ + "\n")
+	if help := family.GetHelp(); help != "" {
+		w.WriteString("# HELP " + name + " " + escaper.Replace(help) + "\n")
+	}
+
+	for _, m := range family.GetMetric() {
+		labels := sortedLabels(m)
+		key := name + formatLabels(labels)
+		ts := ""
+		if m.GetTimestampMs() != 0 {
+			ts = " " + formatFloat(float64(m.GetTimestampMs())/1000)
+		}
+
+		line := func(suffix string, value float64, extra ...label) {
+			ls := labels
+			if len(extra) > 0 {
+				ls = append(labels[:len(labels):len(labels)], extra...)
+			}
+
+			w.WriteString(name + suffix + formatLabels(ls) + " " + formatFloat(value) + ts + "\n")
+		}
+
+		created := func(value float64) {
+			line("_created", float64(o.created.created(key, value, now).UnixNano())/1e9)
+		}
+
+		switch family.GetType() {
+		case dto.MetricType_COUNTER:
+			value := m.GetCounter().GetValue()
+			line("_total", value)
+			created(value)
+		case dto.MetricType_GAUGE:
+			line("", m.GetGauge().GetValue())
+		case dto.MetricType_SUMMARY:
+			summary := m.GetSummary()
+			for _, q := range summary.GetQuantile() {
+				line("", q.GetValue(), label{name: "quantile", value: formatFloat(q.GetQuantile())})
+			}
+			line("_sum", summary.GetSampleSum())
+			line("_count", float64(summary.GetSampleCount()))
+			created(float64(summary.GetSampleCount()))
+		case dto.MetricType_HISTOGRAM:
+			histogram := m.GetHistogram()
+			infSeen := false
+			for _, b := range histogram.GetBucket() {
+				if math.IsInf(b.GetUpperBound(), +1) {
+					infSeen = true
+				}
+				line("_bucket", float64(b.GetCumulativeCount()), label{name: "le", value: formatFloat(b.GetUpperBound())})
+			}
+			if !infSeen {
+				line("_bucket", float64(histogram.GetSampleCount()), label{name: "le", value: "+Inf"})
+			}
+			line("_sum", histogram.GetSampleSum())
+			line("_count", float64(histogram.GetSampleCount()))
+			created(float64(histogram.GetSampleCount()))
+		default:
+			line("", m.GetUntyped().GetValue())
+		}
+	}
+}
+
+// help texts and label values are escaped the same way in OpenMetrics
+var escaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+
+func formatLabels(labels []label) string {
+	if len(labels) == 0 {
+		return ""
+	}
+
+	var sb strings.Builder
+	sb.WriteByte('{')
+	for i, l := range labels {
+		if i > 0 {
+			sb.WriteByte(',')
+		}
+
+		sb.WriteString(l.name + `="` + escaper.Replace(l.value) + `"`)
+	}
+	sb.WriteByte('}')
+
+	return sb.String()
+}
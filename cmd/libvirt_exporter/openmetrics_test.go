@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+func readFamilies(t *testing.T, read float64) []*dto.MetricFamily {
+	registry := prometheus.NewRegistry()
+	reads := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "libvirt_domain_block_read_bytes_total",
+		Help: "Number of bytes read from a block device, in bytes.",
+	}, []string{"domain", "target_device"})
+	reads.WithLabelValues("web", "vda").Add(read)
+	state := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "libvirt_domain_state_code",
+		Help: "Code of the domain state",
+	})
+	state.Set(1)
+	registry.MustRegister(reads, state)
+
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return families
+}
+
+func TestOpenMetricsCreated(t *testing.T) {
+	start := time.Unix(1600000000, 0)
+
+	om := &openMetrics{}
+	for _, tc := range []struct {
+		name     string
+		read     float64
+		now      time.Time
+		expected string
+	}{
+		{
+			name: "first seen",
+			read: 4096,
+			now:  start,
+			expected: `# TYPE libvirt_domain_block_read_bytes counter
+# HELP libvirt_domain_block_read_bytes Number of bytes read from a block device, in bytes.
+libvirt_domain_block_read_bytes_total{domain="web",target_device="vda"} 4096
+libvirt_domain_block_read_bytes_created{domain="web",target_device="vda"} 1.6e+09
+# TYPE libvirt_domain_state_code gauge
+# HELP libvirt_domain_state_code Code of the domain state
+libvirt_domain_state_code 1
+# EOF
+`,
+		},
+		{
+			name: "increased",
+			read: 8192,
+			now:  start.Add(time.Minute),
+			expected: `# TYPE libvirt_domain_block_read_bytes counter
+# HELP libvirt_domain_block_read_bytes Number of bytes read from a block device, in bytes.
+libvirt_domain_block_read_bytes_total{domain="web",target_device="vda"} 8192
+libvirt_domain_block_read_bytes_created{domain="web",target_device="vda"} 1.6e+09
+# TYPE libvirt_domain_state_code gauge
+# HELP libvirt_domain_state_code Code of the domain state
+libvirt_domain_state_code 1
+# EOF
+`,
+		},
+		{
+			// e.g. the domain was restarted
+			name: "reset",
+			read: 512,
+			now:  start.Add(2 * time.Minute),
+			expected: `# TYPE libvirt_domain_block_read_bytes counter
+# HELP libvirt_domain_block_read_bytes Number of bytes read from a block device, in bytes.
+libvirt_domain_block_read_bytes_total{domain="web",target_device="vda"} 512
+libvirt_domain_block_read_bytes_created{domain="web",target_device="vda"} 1.60000012e+09
+# TYPE libvirt_domain_state_code gauge
+# HELP libvirt_domain_state_code Code of the domain state
+libvirt_domain_state_code 1
+# EOF
+`,
+		},
+	} {
+		var buf bytes.Buffer
+		om.write(&buf, readFamilies(t, tc.read), tc.now)
+		if buf.String() != tc.expected {
+			t.Errorf("%s: expected\n%s\ngot\n%s", tc.name, tc.expected, buf.String())
+		}
+	}
+}
+
+func TestOpenMetricsNegotiation(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "libvirt_exporter_scrapes_total",
+		Help: "Number of scrapes.",
+	}))
+
+	om := &openMetrics{}
+	for _, tc := range []struct {
+		accept  string
+		accepts bool
+	}{
+		// what Prometheus sends
+		{"application/openmetrics-text; version=0.0.1,text/plain;version=0.0.4;q=0.5,*/*;q=0.1", true},
+		{"text/plain;version=0.0.4", false},
+		{"", false},
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		r.Header.Set("Accept", tc.accept)
+		if got := om.accepts(r); got != tc.accepts {
+			t.Errorf("Accept %q: expected %v, got %v", tc.accept, tc.accepts, got)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	om.serve(w, registry)
+	if got := w.Header().Get("Content-Type"); got != string(expfmt.FmtOpenMetrics) {
+		t.Errorf("expected content type %q, got %q", expfmt.FmtOpenMetrics, got)
+	}
+	body := w.Body.String()
+	if !strings.Contains(body, "\nlibvirt_exporter_scrapes_created ") || !strings.HasSuffix(body, "\n# EOF\n") {
+		t.Errorf("expected the _created series and EOF, got\n%s", body)
+	}
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/version"
+	collectormetrics "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// the transports of OTLP, named like OTEL_EXPORTER_OTLP_PROTOCOL
+const (
+	otlpGRPC         = "grpc"
+	otlpHTTPProtobuf = "http/protobuf"
+	otlpHTTPJSON     = "http/json"
+)
+
+// otlpWriter sends the metrics to an OpenTelemetry collector over
+// OTLP/gRPC, or OTLP/HTTP in the protobuf or the JSON encoding.
+type otlpWriter struct {
+	pusher
+
+	protocol string
+
+	// client of the gRPC protocol
+	client collectormetrics.MetricsServiceClient
+
+	// start time of the cumulative sums, it's moved on counter resets
+	starts createdTracker
+}
+
+// newOTLPWriter returns the writer of the protocol. The endpoint of gRPC
+// is either host:port, or a URL whose https scheme enables TLS, e.g.
+// http://collector:4317, the path of the signal is appended to the
+// endpoint of HTTP if it's missing.
+func newOTLPWriter(p pusher, endpoint, protocol string) (*otlpWriter, error) {
+	w := &otlpWriter{pusher: p, protocol: protocol}
+	if protocol != otlpGRPC {
+		w.url = otlpEndpoint(endpoint, "metrics")
+		return w, nil
+	}
+
+	target, creds := endpoint, insecure.NewCredentials()
+	if u, err := url.Parse(endpoint); err == nil && u.Host != "" {
+		target = u.Host
+		if u.Scheme == "https" {
+			creds = credentials.NewTLS(&tls.Config{})
+		}
+	}
+
+	conn, err := grpc.NewClient(target, grpc.WithTransportCredentials(creds),
+		grpc.WithUserAgent("libvirt_exporter/"+version.Version))
+	if err != nil {
+		return nil, err
+	}
+
+	w.url = target
+	w.client = collectormetrics.NewMetricsServiceClient(conn)
+	return w, nil
+}
+
+func otlpAttributes(m *dto.Metric) []*commonpb.KeyValue {
+	attrs := make([]*commonpb.KeyValue, 0, len(m.GetLabel()))
+	for _, pair := range sortedLabels(m) {
+		attrs = append(attrs, otlpString(pair.name, pair.value))
+	}
+
+	return attrs
+}
+
+func otlpString(key, value string) *commonpb.KeyValue {
+	return &commonpb.KeyValue{
+		Key:   key,
+		Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: value}},
+	}
+}
+
+func (w *otlpWriter) request(families []*dto.MetricFamily, now time.Time) *collectormetrics.ExportMetricsServiceRequest {
+	metrics := make([]*metricspb.Metric, 0, len(families))
+	for _, family := range families {
+		var (
+			gauge     *metricspb.Gauge
+			sum       *metricspb.Sum
+			histogram *metricspb.Histogram
+			summary   *metricspb.Summary
+		)
+
+		for _, m := range family.GetMetric() {
+			ts := now
+			if m.GetTimestampMs() != 0 {
+				ts = time.Unix(0, m.GetTimestampMs()*int64(time.Millisecond))
+			}
+
+			attrs := otlpAttributes(m)
+			key := family.GetName() + formatLabels(sortedLabels(m))
+
+			switch family.GetType() {
+			case dto.MetricType_COUNTER:
+				if sum == nil {
+					sum = &metricspb.Sum{AggregationTemporality: metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE, IsMonotonic: true}
+				}
+
+				value := m.GetCounter().GetValue()
+				sum.DataPoints = append(sum.DataPoints, &metricspb.NumberDataPoint{
+					Attributes:        attrs,
+					StartTimeUnixNano: uint64(w.starts.created(key, value, now).UnixNano()),
+					TimeUnixNano:      uint64(ts.UnixNano()),
+					Value:             &metricspb.NumberDataPoint_AsDouble{AsDouble: value},
+				})
+
+			case dto.MetricType_HISTOGRAM:
+				if histogram == nil {
+					histogram = &metricspb.Histogram{AggregationTemporality: metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE}
+				}
+
+				h := m.GetHistogram()
+				point := &metricspb.HistogramDataPoint{
+					Attributes:        attrs,
+					StartTimeUnixNano: uint64(w.starts.created(key, float64(h.GetSampleCount()), now).UnixNano()),
+					TimeUnixNano:      uint64(ts.UnixNano()),
+					Count:             h.GetSampleCount(),
+					Sum:               proto.Float64(h.GetSampleSum()),
+				}
+
+				// OTLP buckets are not cumulative, and the +Inf one is implicit
+				var previous uint64
+				for _, b := range h.GetBucket() {
+					if math.IsInf(b.GetUpperBound(), +1) {
+						continue
+					}
+
+					point.ExplicitBounds = append(point.ExplicitBounds, b.GetUpperBound())
+					point.BucketCounts = append(point.BucketCounts, b.GetCumulativeCount()-previous)
+					previous = b.GetCumulativeCount()
+				}
+				point.BucketCounts = append(point.BucketCounts, h.GetSampleCount()-previous)
+
+				histogram.DataPoints = append(histogram.DataPoints, point)
+
+			case dto.MetricType_SUMMARY:
+				if summary == nil {
+					summary = &metricspb.Summary{}
+				}
+
+				s := m.GetSummary()
+				point := &metricspb.SummaryDataPoint{
+					Attributes:        attrs,
+					StartTimeUnixNano: uint64(w.starts.created(key, float64(s.GetSampleCount()), now).UnixNano()),
+					TimeUnixNano:      uint64(ts.UnixNano()),
+					Count:             s.GetSampleCount(),
+					Sum:               s.GetSampleSum(),
+				}
+				for _, q := range s.GetQuantile() {
+					point.QuantileValues = append(point.QuantileValues, &metricspb.SummaryDataPoint_ValueAtQuantile{
+						Quantile: q.GetQuantile(),
+						Value:    q.GetValue(),
+					})
+				}
+
+				summary.DataPoints = append(summary.DataPoints, point)
+
+			default:
+				if gauge == nil {
+					gauge = &metricspb.Gauge{}
+				}
+
+				value := m.GetGauge().GetValue()
+				if family.GetType() == dto.MetricType_UNTYPED {
+					value = m.GetUntyped().GetValue()
+				}
+
+				gauge.DataPoints = append(gauge.DataPoints, &metricspb.NumberDataPoint{
+					Attributes:   attrs,
+					TimeUnixNano: uint64(ts.UnixNano()),
+					Value:        &metricspb.NumberDataPoint_AsDouble{AsDouble: value},
+				})
+			}
+		}
+
+		metric := &metricspb.Metric{
+			Name:        family.GetName(),
+			Description: family.GetHelp(),
+		}
+		switch {
+		case sum != nil:
+			metric.Data = &metricspb.Metric_Sum{Sum: sum}
+		case histogram != nil:
+			metric.Data = &metricspb.Metric_Histogram{Histogram: histogram}
+		case summary != nil:
+			metric.Data = &metricspb.Metric_Summary{Summary: summary}
+		case gauge != nil:
+			metric.Data = &metricspb.Metric_Gauge{Gauge: gauge}
+		}
+
+		metrics = append(metrics, metric)
+	}
+
+	w.starts.prune(now)
+
+	return &collectormetrics.ExportMetricsServiceRequest{
+		ResourceMetrics: []*metricspb.ResourceMetrics{{
+			Resource: &resourcepb.Resource{
+				Attributes: []*commonpb.KeyValue{otlpString("service.name", "libvirt_exporter")},
+			},
+			ScopeMetrics: []*metricspb.ScopeMetrics{{
+				Scope:   &commonpb.InstrumentationScope{Name: "libvirt_exporter", Version: version.Version},
+				Metrics: metrics,
+			}},
+		}},
+	}
+}
+
+func (w *otlpWriter) write(families []*dto.MetricFamily, now time.Time) error {
+	req := w.request(families, now)
+
+	switch w.protocol {
+	case otlpGRPC:
+		return w.export(req)
+
+	case otlpHTTPProtobuf:
+		body, err := proto.Marshal(req)
+		if err != nil {
+			return err
+		}
+
+		return w.post(body, http.Header{
+			"Content-Type": []string{"application/x-protobuf"},
+		})
+
+	default:
+		// OTLP/JSON requires the enums as numbers
+		body, err := protojson.MarshalOptions{UseEnumNumbers: true}.Marshal(req)
+		if err != nil {
+			return err
+		}
+
+		return w.post(body, http.Header{
+			"Content-Type": []string{"application/json"},
+		})
+	}
+}
+
+// export sends the request over gRPC with the retries and credentials
+// of the pusher, the codes worth retrying are the ones of the OTLP spec.
+func (w *otlpWriter) export(req *collectormetrics.ExportMetricsServiceRequest) error {
+	return w.retry(func() (bool, error) {
+		authorization, err := w.authorization()
+		if err != nil {
+			return false, err
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), w.timeout)
+		defer cancel()
+
+		if authorization != "" {
+			ctx = metadata.AppendToOutgoingContext(ctx, "authorization", authorization)
+		}
+
+		resp, err := w.client.Export(ctx, req)
+		if err != nil {
+			switch status.Code(err) {
+			case codes.Canceled, codes.DeadlineExceeded, codes.ResourceExhausted, codes.Aborted,
+				codes.OutOfRange, codes.Unavailable, codes.DataLoss:
+				return true, fmt.Errorf("export to %s failed, %s", w.url, err)
+			}
+
+			return false, fmt.Errorf("export to %s failed, %s", w.url, err)
+		}
+
+		if rejected := resp.GetPartialSuccess().GetRejectedDataPoints(); rejected > 0 {
+			return false, fmt.Errorf("%s rejected %d data points: %s", w.url, rejected, resp.GetPartialSuccess().GetErrorMessage())
+		}
+
+		return false, nil
+	})
+}
+
+func sortedLabels(m *dto.Metric) []label {
+	labels := make([]label, 0, len(m.GetLabel()))
+	for _, pair := range m.GetLabel() {
+		labels = append(labels, label{name: pair.GetName(), value: pair.GetValue()})
+	}
+
+	sort.Slice(labels, func(i, j int) bool { return labels[i].name < labels[j].name })
+	return labels
+}
+
+// otlpEndpoint appends the path of the signal, i.e. metrics or traces,
+// to the endpoint if it's missing, e.g. http://collector:4318
+func otlpEndpoint(endpoint, signal string) string {
+	if strings.HasSuffix(endpoint, "/v1/"+signal) {
+		return endpoint
+	}
+
+	return strings.TrimSuffix(endpoint, "/") + "/v1/" + signal
+}
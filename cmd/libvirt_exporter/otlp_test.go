@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	collectormetrics "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// collector receives the requests of the gRPC protocol
+type collector struct {
+	collectormetrics.UnimplementedMetricsServiceServer
+
+	requests      chan *collectormetrics.ExportMetricsServiceRequest
+	authorization string
+}
+
+func (c *collector) Export(ctx context.Context, req *collectormetrics.ExportMetricsServiceRequest) (*collectormetrics.ExportMetricsServiceResponse, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if values := md.Get("authorization"); len(values) > 0 {
+		c.authorization = values[0]
+	}
+
+	c.requests <- req
+	return &collectormetrics.ExportMetricsServiceResponse{}, nil
+}
+
+func checkRequest(t *testing.T, req *collectormetrics.ExportMetricsServiceRequest, now time.Time) {
+	metrics := req.GetResourceMetrics()[0].GetScopeMetrics()[0].GetMetrics()
+	if len(metrics) != 2 {
+		t.Fatalf("expected 2 metrics, got %d", len(metrics))
+	}
+
+	sum := metrics[0].GetSum()
+	if metrics[0].GetName() != "libvirt_domain_block_read_bytes_total" || sum == nil || !sum.GetIsMonotonic() {
+		t.Fatalf("expected a monotonic sum, got %v", metrics[0])
+	}
+
+	point := sum.GetDataPoints()[0]
+	if point.GetAsDouble() != 4096 || point.GetTimeUnixNano() != uint64(now.UnixNano()) {
+		t.Errorf("unexpected data point %v", point)
+	}
+	if attrs := point.GetAttributes(); len(attrs) != 2 || attrs[0].GetKey() != "domain" || attrs[0].GetValue().GetStringValue() != "web" {
+		t.Errorf("unexpected attributes %v", attrs)
+	}
+
+	if gauge := metrics[1].GetGauge(); gauge == nil || gauge.GetDataPoints()[0].GetAsDouble() != 1 {
+		t.Errorf("expected a gauge of 1, got %v", metrics[1])
+	}
+}
+
+func TestOTLPWriterGRPC(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := &collector{requests: make(chan *collectormetrics.ExportMetricsServiceRequest, 1)}
+	server := grpc.NewServer()
+	collectormetrics.RegisterMetricsServiceServer(server, c)
+	go server.Serve(listener)
+	defer server.Stop()
+
+	token := t.TempDir() + "/token"
+	if err = os.WriteFile(token, []byte("secret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := newOTLPWriter(pusher{tokenFile: token, timeout: time.Second}, "http://"+listener.Addr().String(), otlpGRPC)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Unix(1600000000, 0)
+	if err = w.write(testFamilies(t), now); err != nil {
+		t.Fatal(err)
+	}
+
+	checkRequest(t, <-c.requests, now)
+	if c.authorization != "Bearer secret" {
+		t.Errorf("expected the bearer token, got %q", c.authorization)
+	}
+}
+
+func TestOTLPWriterHTTPJSON(t *testing.T) {
+	requests := make(chan *collectormetrics.ExportMetricsServiceRequest, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/metrics" || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("unexpected request %s %s", r.URL.Path, r.Header.Get("Content-Type"))
+		}
+
+		body, _ := io.ReadAll(r.Body)
+		req := &collectormetrics.ExportMetricsServiceRequest{}
+		if err := protojson.Unmarshal(body, req); err != nil {
+			t.Error(err)
+		}
+
+		requests <- req
+	}))
+	defer server.Close()
+
+	w, err := newOTLPWriter(pusher{timeout: time.Second}, server.URL, otlpHTTPJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Unix(1600000000, 0)
+	if err = w.write(testFamilies(t), now); err != nil {
+		t.Fatal(err)
+	}
+
+	checkRequest(t, <-requests, now)
+}
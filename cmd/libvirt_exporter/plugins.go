@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+// plugins runs the executables of a directory on every scrape, and
+// merges the metrics they print in the text exposition format, so site
+// specific metrics could be added without forking. The metrics of the
+// plugins must not clash with each other or the exporter.
+type plugins struct {
+	dir     string
+	timeout time.Duration
+
+	success  *prometheus.Desc
+	duration *prometheus.Desc
+}
+
+func newPlugins(dir string, timeout time.Duration, namespace string) *plugins {
+	return &plugins{
+		dir:     dir,
+		timeout: timeout,
+		success: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace+"_exporter", "plugin", "success"),
+			"Whether the plugin succeeded.",
+			[]string{"plugin"},
+			nil),
+		duration: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace+"_exporter", "plugin", "duration_seconds"),
+			"Time the plugin took, in seconds.",
+			[]string{"plugin"},
+			nil),
+	}
+}
+
+// pluginWaitDelay is how long the output of a plugin is read once it's
+// killed or exited
+const pluginWaitDelay = time.Second
+
+// pluginResult is the outcome of a single run of a plugin.
+type pluginResult struct {
+	name     string
+	families []*dto.MetricFamily
+	duration time.Duration
+	err      error
+}
+
+// gatherer returns the gatherer running the plugins, they are killed
+// once ctx is done or they time out.
+func (p *plugins) gatherer(ctx context.Context) prometheus.Gatherer {
+	return prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+		return p.gather(ctx)
+	})
+}
+
+func (p *plugins) gather(ctx context.Context) ([]*dto.MetricFamily, error) {
+	paths, err := p.executables()
+	if err != nil {
+		log.Printf("list plugins failed, %s\n", err)
+		return nil, nil
+	}
+
+	results := make([]pluginResult, len(paths))
+	var wg sync.WaitGroup
+	for i, path := range paths {
+		wg.Add(1)
+		go func(i int, path string) {
+			defer wg.Done()
+			results[i] = p.run(ctx, path)
+		}(i, path)
+	}
+	wg.Wait()
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(&pluginCollector{p: p, results: results})
+
+	gatherers := prometheus.Gatherers{registry}
+	for _, result := range results {
+		if result.err != nil {
+			log.Printf("run plugin %s failed, %s\n", result.name, result.err)
+			continue
+		}
+
+		families := result.families
+		gatherers = append(gatherers, prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+			return families, nil
+		}))
+	}
+
+	return gatherers.Gather()
+}
+
+// executables returns the paths of the executable files in the
+// directory, hidden ones are ignored.
+func (p *plugins) executables() ([]string, error) {
+	entries, err := os.ReadDir(p.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var paths []string
+	for _, entry := range entries {
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+
+		// the file could be gone since read
+		info, err := entry.Info()
+		if err != nil || info.Mode()&0111 == 0 {
+			continue
+		}
+
+		paths = append(paths, filepath.Join(p.dir, entry.Name()))
+	}
+
+	return paths, nil
+}
+
+func (p *plugins) run(ctx context.Context, path string) pluginResult {
+	result := pluginResult{name: filepath.Base(path)}
+
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, path)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	// the plugin runs in a process group of its own, which is killed as a
+	// whole, so shell scripts don't leave their children behind
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+
+	// don't wait for the output of the processes escaped from the group,
+	// which would hold the pipes open
+	cmd.WaitDelay = pluginWaitDelay
+
+	start := time.Now()
+	err := cmd.Run()
+	result.duration = time.Since(start)
+	if ctx.Err() == context.DeadlineExceeded {
+		result.err = fmt.Errorf("timed out after %s", p.timeout)
+		return result
+	}
+	if err != nil {
+		result.err = err
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			result.err = fmt.Errorf("%s, %s", err, msg)
+		}
+		return result
+	}
+
+	parsed, err := (&expfmt.TextParser{}).TextToMetricFamilies(&stdout)
+	if err != nil {
+		result.err = err
+		return result
+	}
+
+	for _, family := range parsed {
+		result.families = append(result.families, family)
+	}
+
+	return result
+}
+
+// pluginCollector reports the outcome of the plugins.
+type pluginCollector struct {
+	p       *plugins
+	results []pluginResult
+}
+
+func (c *pluginCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.p.success
+	ch <- c.p.duration
+}
+
+func (c *pluginCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, result := range c.results {
+		success := 1.0
+		if result.err != nil {
+			success = 0
+		}
+
+		ch <- prometheus.MustNewConstMetric(c.p.success, prometheus.GaugeValue, success, result.name)
+		ch <- prometheus.MustNewConstMetric(c.p.duration, prometheus.GaugeValue, result.duration.Seconds(), result.name)
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/f1shl3gs/libvirt_exporter/exporter"
+)
+
+var (
+	probeSuccess = prometheus.NewDesc(
+		"probe_success",
+		"Whether the probe of the target succeeded.",
+		nil,
+		nil)
+	probeDuration = prometheus.NewDesc(
+		"probe_duration_seconds",
+		"How long the probe of the target took.",
+		nil,
+		nil)
+)
+
+// probeCollector collects a target, then reports how the probe went.
+type probeCollector struct {
+	e *exporter.Exporter
+}
+
+func (p *probeCollector) Describe(ch chan<- *prometheus.Desc) {
+	p.e.Describe(ch)
+	ch <- probeSuccess
+	ch <- probeDuration
+}
+
+func (p *probeCollector) Collect(ch chan<- prometheus.Metric) {
+	p.e.Collect(ch)
+
+	success := 0.0
+	last := p.e.LastScrape()
+	if last.Err == nil {
+		success = 1.0
+	}
+
+	ch <- prometheus.MustNewConstMetric(
+		probeSuccess,
+		prometheus.GaugeValue,
+		success)
+
+	ch <- prometheus.MustNewConstMetric(
+		probeDuration,
+		prometheus.GaugeValue,
+		last.Duration.Seconds())
+}
+
+// probeTimeout honors the scrape timeout of Prometheus, leaving some
+// time to send the response.
+func probeTimeout(r *http.Request, fallback, offset time.Duration) time.Duration {
+	seconds, err := strconv.ParseFloat(r.Header.Get("X-Prometheus-Scrape-Timeout-Seconds"), 64)
+	if err != nil || seconds <= 0 {
+		return fallback
+	}
+
+	timeout := time.Duration(seconds*float64(time.Second)) - offset
+	if timeout <= 0 {
+		return fallback
+	}
+
+	return timeout
+}
+
+// probeHandler serves /probe?target=qemu+tls://hv01/system, so one
+// exporter could collect many hypervisors like the blackbox exporter.
+// opts returns the options every target is collected with.
+func probeHandler(opts func() []exporter.Option, timeout, offset time.Duration, relabeler *exporter.Relabeler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		target := r.URL.Query().Get("target")
+		if target == "" {
+			http.Error(w, "target parameter is missing", http.StatusBadRequest)
+			return
+		}
+
+		e := exporter.NewExporter(target, append(opts(), exporter.WithTimeout(probeTimeout(r, timeout, offset)))...)
+
+		registry := prometheus.NewRegistry()
+		registry.MustRegister(&probeCollector{e: e})
+
+		promhttp.HandlerFor(relabeler.Gatherer(registry), promhttp.HandlerOpts{}).ServeHTTP(w, r)
+	})
+}
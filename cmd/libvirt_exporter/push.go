@@ -0,0 +1,40 @@
+package main
+
+import (
+	"log"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// metricsWriter pushes the gathered metrics somewhere
+type metricsWriter interface {
+	write(families []*dto.MetricFamily, now time.Time) error
+}
+
+// pushLoop gathers the metrics right away and then every interval, and
+// pushes them with every writer, for hypervisors which could not be
+// scraped inbound.
+func pushLoop(interval time.Duration, gatherer prometheus.Gatherer, writers ...metricsWriter) {
+	pushOnce(time.Now(), gatherer, writers)
+	for now := range time.Tick(interval) {
+		pushOnce(now, gatherer, writers)
+	}
+}
+
+func pushOnce(now time.Time, gatherer prometheus.Gatherer, writers []metricsWriter) {
+	families, err := gatherer.Gather()
+	if err != nil {
+		log.Printf("gather metrics failed, %s\n", err)
+		if len(families) == 0 {
+			return
+		}
+	}
+
+	for _, w := range writers {
+		if err = w.write(families, now); err != nil {
+			log.Printf("push metrics failed, %s\n", err)
+		}
+	}
+}
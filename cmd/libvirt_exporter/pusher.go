@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/prometheus/common/version"
+)
+
+// pusher posts the encoded metrics to an endpoint, retrying the failed
+// posts with exponential backoff.
+type pusher struct {
+	url          string
+	username     string
+	passwordFile string
+	tokenFile    string
+	retries      int
+	timeout      time.Duration
+	client       *http.Client
+}
+
+func (p *pusher) post(body []byte, header http.Header) error {
+	return p.retry(func() (bool, error) {
+		return p.send(body, header)
+	})
+}
+
+// retry calls send until it succeeds, fails for good or the retries run
+// out, send reports whether its error is worth retrying.
+func (p *pusher) retry(send func() (bool, error)) error {
+	var err error
+	backoff := 500 * time.Millisecond
+	for attempt := 0; attempt <= p.retries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+
+		var retry bool
+		if retry, err = send(); err == nil || !retry {
+			return err
+		}
+	}
+
+	return err
+}
+
+// send posts the body once, it reports whether the error is worth
+// retrying, i.e. network errors, 429 and 5xx.
+func (p *pusher) send(body []byte, header http.Header) (bool, error) {
+	req, err := http.NewRequest(http.MethodPost, p.url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	req.Header.Set("User-Agent", "libvirt_exporter/"+version.Version)
+
+	if err = p.authorize(req); err != nil {
+		return false, err
+	}
+
+	client := p.client
+	if client == nil {
+		client = &http.Client{Timeout: p.timeout}
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return true, err
+	}
+
+	defer resp.Body.Close()
+	if resp.StatusCode/100 == 2 {
+		io.Copy(io.Discard, resp.Body)
+		return false, nil
+	}
+
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	err = fmt.Errorf("%s returned %s: %s", p.url, resp.Status, strings.TrimSpace(string(msg)))
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode/100 == 5, err
+}
+
+// authorize reads the credentials on every request, so they could be
+// rotated without restarting
+func (p *pusher) authorize(req *http.Request) error {
+	authorization, err := p.authorization()
+	if err != nil {
+		return err
+	}
+
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+
+	return nil
+}
+
+// authorization returns the value of the Authorization header, it's
+// empty without credentials.
+func (p *pusher) authorization() (string, error) {
+	if p.tokenFile != "" {
+		token, err := os.ReadFile(p.tokenFile)
+		if err != nil {
+			return "", fmt.Errorf("read bearer token file failed, %s", err)
+		}
+
+		return "Bearer " + strings.TrimSpace(string(token)), nil
+	}
+
+	if p.username != "" {
+		var password []byte
+		if p.passwordFile != "" {
+			var err error
+			if password, err = os.ReadFile(p.passwordFile); err != nil {
+				return "", fmt.Errorf("read password file failed, %s", err)
+			}
+		}
+
+		credentials := p.username + ":" + strings.TrimSpace(string(password))
+		return "Basic " + base64.StdEncoding.EncodeToString([]byte(credentials)), nil
+	}
+
+	return "", nil
+}
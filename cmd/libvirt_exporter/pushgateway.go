@@ -0,0 +1,47 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// pushgatewayWriter pushes the metrics to a Pushgateway, replacing the
+// metrics of the group pushed last time.
+type pushgatewayWriter struct {
+	pusher
+
+	job      string
+	grouping map[string]string
+}
+
+func (w *pushgatewayWriter) write(families []*dto.MetricFamily, now time.Time) error {
+	p := push.New(w.url, w.job).
+		Gatherer(prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+			return families, nil
+		})).
+		Client(w)
+
+	for name, value := range w.grouping {
+		p = p.Grouping(name, value)
+	}
+
+	return p.Push()
+}
+
+// Do authorizes the requests of the Pushgateway client
+func (w *pushgatewayWriter) Do(req *http.Request) (*http.Response, error) {
+	if err := w.authorize(req); err != nil {
+		return nil, err
+	}
+
+	client := w.client
+	if client == nil {
+		client = &http.Client{Timeout: w.timeout}
+	}
+
+	return client.Do(req)
+}
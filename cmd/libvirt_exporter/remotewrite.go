@@ -0,0 +1,99 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/f1shl3gs/libvirt_exporter/internal/prompb"
+	"github.com/golang/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/proto"
+)
+
+// remoteWriter sends samples to a Prometheus remote write endpoint.
+type remoteWriter struct {
+	pusher
+}
+
+func (w *remoteWriter) write(families []*dto.MetricFamily, now time.Time) error {
+	data, err := proto.Marshal(writeRequest(flatten(families), now.UnixNano()/int64(time.Millisecond)))
+	if err != nil {
+		return err
+	}
+
+	return w.post(snappy.Encode(nil, data), http.Header{
+		"Content-Encoding":                  []string{"snappy"},
+		"Content-Type":                      []string{"application/x-protobuf"},
+		"X-Prometheus-Remote-Write-Version": []string{"0.1.0"},
+	})
+}
+
+// writeRequest converts the samples to a WriteRequest, every sample is a
+// TimeSeries of its own. Samples without a timestamp get now.
+func writeRequest(samples []sample, now int64) *prompb.WriteRequest {
+	req := &prompb.WriteRequest{Timeseries: make([]*prompb.TimeSeries, 0, len(samples))}
+	for _, s := range samples {
+		// labels are sorted by name, __name__ goes first
+		labels := make([]*prompb.Label, 0, len(s.labels)+1)
+		labels = append(labels, &prompb.Label{Name: "__name__", Value: s.name})
+		for _, l := range s.labels {
+			labels = append(labels, &prompb.Label{Name: l.name, Value: l.value})
+		}
+
+		timestamp := s.timestamp
+		if timestamp == 0 {
+			timestamp = now
+		}
+
+		req.Timeseries = append(req.Timeseries, &prompb.TimeSeries{
+			Labels:  labels,
+			Samples: []*prompb.Sample{{Value: s.value, Timestamp: timestamp}},
+		})
+	}
+
+	return req
+}
+
+// mirrorGatherer sends the metrics of every scrape to the remote write
+// endpoint as well, in the background so the scrape is not delayed.
+type mirrorGatherer struct {
+	prometheus.Gatherer
+	queue chan<- mirrored
+}
+
+type mirrored struct {
+	families []*dto.MetricFamily
+	time     time.Time
+}
+
+func (m *mirrorGatherer) Gather() ([]*dto.MetricFamily, error) {
+	families, err := m.Gatherer.Gather()
+	if len(families) > 0 {
+		select {
+		case m.queue <- mirrored{families: families, time: time.Now()}:
+		default:
+			log.Printf("remote write queue is full, samples of the scrape dropped\n")
+		}
+	}
+
+	return families, err
+}
+
+// mirror returns a wrapper of gatherers mirroring the scrapes to w, the
+// writes are done one by one.
+func mirror(w *remoteWriter) func(prometheus.Gatherer) prometheus.Gatherer {
+	queue := make(chan mirrored, 16)
+	go func() {
+		for m := range queue {
+			if err := w.write(m.families, m.time); err != nil {
+				log.Printf("remote write to %s failed, %s\n", w.url, err)
+			}
+		}
+	}()
+
+	return func(g prometheus.Gatherer) prometheus.Gatherer {
+		return &mirrorGatherer{Gatherer: g, queue: queue}
+	}
+}
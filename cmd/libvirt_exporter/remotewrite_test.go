@@ -0,0 +1,98 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/f1shl3gs/libvirt_exporter/internal/prompb"
+	"github.com/golang/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/proto"
+)
+
+func testFamilies(t *testing.T) []*dto.MetricFamily {
+	registry := prometheus.NewRegistry()
+	reads := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "libvirt_domain_block_read_bytes_total",
+		Help: "Number of bytes read from a block device, in bytes.",
+	}, []string{"domain", "target_device"})
+	reads.WithLabelValues("web", "vda").Add(4096)
+	state := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "libvirt_domain_state_code",
+		Help: "Code of the domain state",
+	})
+	state.Set(1)
+	registry.MustRegister(reads, state)
+
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return families
+}
+
+func TestRemoteWriter(t *testing.T) {
+	requests := make(chan *prompb.WriteRequest, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Encoding") != "snappy" || r.Header.Get("Content-Type") != "application/x-protobuf" {
+			t.Errorf("unexpected headers %v", r.Header)
+		}
+		if username, password, _ := r.BasicAuth(); username != "hv01" || password != "secret" {
+			t.Errorf("unexpected basic auth %s:%s", username, password)
+		}
+
+		compressed, _ := io.ReadAll(r.Body)
+		data, err := snappy.Decode(nil, compressed)
+		if err != nil {
+			t.Error(err)
+		}
+
+		req := &prompb.WriteRequest{}
+		if err = proto.Unmarshal(data, req); err != nil {
+			t.Error(err)
+		}
+
+		requests <- req
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	password := t.TempDir() + "/password"
+	if err := os.WriteFile(password, []byte("secret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	w := &remoteWriter{pusher{url: server.URL, username: "hv01", passwordFile: password, timeout: time.Second}}
+
+	now := time.Unix(1600000000, 0)
+	if err := w.write(testFamilies(t), now); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := &prompb.WriteRequest{
+		Timeseries: []*prompb.TimeSeries{
+			{
+				Labels: []*prompb.Label{
+					{Name: "__name__", Value: "libvirt_domain_block_read_bytes_total"},
+					{Name: "domain", Value: "web"},
+					{Name: "target_device", Value: "vda"},
+				},
+				Samples: []*prompb.Sample{{Value: 4096, Timestamp: 1600000000000}},
+			},
+			{
+				Labels:  []*prompb.Label{{Name: "__name__", Value: "libvirt_domain_state_code"}},
+				Samples: []*prompb.Sample{{Value: 1, Timestamp: 1600000000000}},
+			},
+		},
+	}
+
+	if req := <-requests; !proto.Equal(req, expected) {
+		t.Errorf("expected %v, got %v", expected, req)
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"io"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/f1shl3gs/libvirt_exporter/exporter"
+)
+
+// rule is a recording or alerting rule of Prometheus, %[1]s in the
+// names, expressions and annotations is replaced by the namespace, see
+// expand.
+type rule struct {
+	Record      string            `yaml:"record,omitempty"`
+	Alert       string            `yaml:"alert,omitempty"`
+	Expr        string            `yaml:"expr"`
+	For         string            `yaml:"for,omitempty"`
+	Labels      map[string]string `yaml:"labels,omitempty"`
+	Annotations map[string]string `yaml:"annotations,omitempty"`
+
+	// the metrics the rule needs, it's left out if any of them is not
+	// exposed, e.g. its collector is disabled
+	needs []string
+}
+
+type ruleGroup struct {
+	Name  string `yaml:"name"`
+	Rules []rule `yaml:"rules"`
+}
+
+var recommendedRules = []ruleGroup{
+	{
+		Name: "libvirt.rules",
+		Rules: []rule{
+			{
+				Record: "%[1]s:domain_cpu_usage:ratio_rate5m",
+				Expr:   "rate(%[1]s_domain_cpu_time_seconds_total[5m]) / %[1]s_domain_virtual_cpus",
+				needs:  []string{"%[1]s_domain_cpu_time_seconds_total", "%[1]s_domain_virtual_cpus"},
+			},
+			{
+				Record: "%[1]s:domain_memory_usage:ratio",
+				Expr:   "%[1]s_domain_memory_rss_bytes / %[1]s_domain_memory_maximum_bytes",
+				needs:  []string{"%[1]s_domain_memory_rss_bytes", "%[1]s_domain_memory_maximum_bytes"},
+			},
+		},
+	},
+	{
+		Name: "libvirt.alerts",
+		Rules: []rule{
+			{
+				Alert:  "LibvirtDown",
+				Expr:   "%[1]s_up == 0",
+				For:    "5m",
+				Labels: map[string]string{"severity": "critical"},
+				Annotations: map[string]string{
+					"summary":     "libvirt of {{ $labels.instance }} is unreachable",
+					"description": "The exporter has failed to connect to libvirt for 5 minutes.",
+				},
+				needs: []string{"%[1]s_up"},
+			},
+			{
+				Alert:  "LibvirtScrapeFailing",
+				Expr:   "%[1]s_exporter_collector_success == 0",
+				For:    "15m",
+				Labels: map[string]string{"severity": "warning"},
+				Annotations: map[string]string{
+					"summary":     "collector {{ $labels.collector }} of {{ $labels.instance }} is failing",
+					"description": "The collector has failed for 15 minutes, its metrics are missing, see the logs of the exporter.",
+				},
+				needs: []string{"%[1]s_exporter_collector_success"},
+			},
+			{
+				Alert:  "LibvirtDomainCrashed",
+				Expr:   `%[1]s_domain_state{state="crashed"}`,
+				For:    "1m",
+				Labels: map[string]string{"severity": "critical"},
+				Annotations: map[string]string{
+					"summary": "domain {{ $labels.domain }} of {{ $labels.instance }} crashed",
+				},
+				needs: []string{"%[1]s_domain_state"},
+			},
+			{
+				Alert:  "LibvirtDomainDown",
+				Expr:   `%[1]s_domain_state{state=~"shutoff|paused|shutdown"} and on(domain, uuid) max_over_time(%[1]s_domain_state{state="running"}[1h])`,
+				For:    "5m",
+				Labels: map[string]string{"severity": "warning"},
+				Annotations: map[string]string{
+					"summary":     "domain {{ $labels.domain }} of {{ $labels.instance }} is {{ $labels.state }}",
+					"description": "The domain was running within the last hour.",
+				},
+				needs: []string{"%[1]s_domain_state"},
+			},
+			{
+				// the steal time of guests is not collected, vCPUs kept
+				// busy tell the CPU contention from the host side
+				Alert:  "LibvirtDomainCPUSaturated",
+				Expr:   "%[1]s:domain_cpu_usage:ratio_rate5m > 0.9",
+				For:    "30m",
+				Labels: map[string]string{"severity": "warning"},
+				Annotations: map[string]string{
+					"summary":     "domain {{ $labels.domain }} of {{ $labels.instance }} uses {{ $value | humanizePercentage }} of its vCPUs",
+					"description": "The vCPUs of the domain have been busy for 30 minutes, the domain may be starved or undersized.",
+				},
+				needs: []string{"%[1]s_domain_cpu_time_seconds_total", "%[1]s_domain_virtual_cpus"},
+			},
+			{
+				Alert:  "LibvirtStorageVolumeNearlyFull",
+				Expr:   "%[1]s_storage_volume_allocation_bytes / %[1]s_storage_volume_capacity_bytes > 0.9",
+				For:    "30m",
+				Labels: map[string]string{"severity": "warning"},
+				Annotations: map[string]string{
+					"summary": "volume {{ $labels.volume }} of pool {{ $labels.pool }} is {{ $value | humanizePercentage }} allocated",
+				},
+				needs: []string{"%[1]s_storage_volume_allocation_bytes", "%[1]s_storage_volume_capacity_bytes"},
+			},
+			{
+				Alert:  "LibvirtGuestFilesystemNearlyFull",
+				Expr:   "%[1]s_domain_guest_filesystem_used_bytes / %[1]s_domain_guest_filesystem_size_bytes > 0.9",
+				For:    "30m",
+				Labels: map[string]string{"severity": "warning"},
+				Annotations: map[string]string{
+					"summary": "filesystem {{ $labels.mountpoint }} of domain {{ $labels.domain }} is {{ $value | humanizePercentage }} full",
+				},
+				needs: []string{"%[1]s_domain_guest_filesystem_used_bytes", "%[1]s_domain_guest_filesystem_size_bytes"},
+			},
+		},
+	},
+}
+
+// rules returns the recommended rules for the namespace, the ones
+// needing metrics which e doesn't expose are left out.
+func rules(e *exporter.Exporter, namespace string) []ruleGroup {
+	exposed := make(map[string]bool)
+	for _, metric := range e.Metrics() {
+		exposed[metric.Name] = true
+	}
+
+	groups := make([]ruleGroup, 0, len(recommendedRules))
+	for _, group := range recommendedRules {
+		var rules []rule
+	next:
+		for _, r := range group.Rules {
+			for _, name := range r.needs {
+				if !exposed[expand(name, namespace)] {
+					continue next
+				}
+			}
+
+			r.Record = expand(r.Record, namespace)
+			r.Expr = expand(r.Expr, namespace)
+			annotations := make(map[string]string, len(r.Annotations))
+			for name, value := range r.Annotations {
+				annotations[name] = expand(value, namespace)
+			}
+			r.Annotations = annotations
+			rules = append(rules, r)
+		}
+
+		if len(rules) > 0 {
+			groups = append(groups, ruleGroup{Name: group.Name, Rules: rules})
+		}
+	}
+
+	return groups
+}
+
+func expand(s, namespace string) string {
+	return strings.Replace(s, "%[1]s", namespace, -1)
+}
+
+// writeRules writes the rules file of the recommended rules.
+func writeRules(w io.Writer, e *exporter.Exporter, namespace string) error {
+	data, err := yaml.Marshal(map[string][]ruleGroup{"groups": rules(e, namespace)})
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(data)
+	return err
+}
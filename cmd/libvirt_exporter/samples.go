@@ -0,0 +1,101 @@
+package main
+
+import (
+	"math"
+	"sort"
+	"strconv"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+type label struct {
+	name  string
+	value string
+}
+
+// sample is a single series of the exposition, histograms and summaries
+// are expanded into the _bucket, _sum and _count series like Prometheus
+// does when scraping.
+type sample struct {
+	name   string
+	labels []label
+	value  float64
+
+	// milliseconds, 0 means the time of gathering
+	timestamp int64
+}
+
+// flatten expands the metric families into samples, the labels of every
+// sample are sorted by name.
+func flatten(families []*dto.MetricFamily) []sample {
+	var samples []sample
+	for _, family := range families {
+		name := family.GetName()
+		for _, m := range family.GetMetric() {
+			labels := make([]label, 0, len(m.GetLabel())+1)
+			for _, pair := range m.GetLabel() {
+				labels = append(labels, label{name: pair.GetName(), value: pair.GetValue()})
+			}
+
+			add := func(suffix string, value float64, extra ...label) {
+				ls := labels
+				if len(extra) > 0 {
+					ls = append(labels[:len(labels):len(labels)], extra...)
+				}
+
+				sort.Slice(ls, func(i, j int) bool { return ls[i].name < ls[j].name })
+				samples = append(samples, sample{
+					name:      name + suffix,
+					labels:    ls,
+					value:     value,
+					timestamp: m.GetTimestampMs(),
+				})
+			}
+
+			switch family.GetType() {
+			case dto.MetricType_COUNTER:
+				add("", m.GetCounter().GetValue())
+			case dto.MetricType_GAUGE:
+				add("", m.GetGauge().GetValue())
+			case dto.MetricType_SUMMARY:
+				summary := m.GetSummary()
+				for _, q := range summary.GetQuantile() {
+					add("", q.GetValue(), label{name: "quantile", value: formatFloat(q.GetQuantile())})
+				}
+				add("_sum", summary.GetSampleSum())
+				add("_count", float64(summary.GetSampleCount()))
+			case dto.MetricType_HISTOGRAM:
+				histogram := m.GetHistogram()
+				infSeen := false
+				for _, b := range histogram.GetBucket() {
+					if math.IsInf(b.GetUpperBound(), +1) {
+						infSeen = true
+					}
+					add("_bucket", float64(b.GetCumulativeCount()), label{name: "le", value: formatFloat(b.GetUpperBound())})
+				}
+				if !infSeen {
+					add("_bucket", float64(histogram.GetSampleCount()), label{name: "le", value: "+Inf"})
+				}
+				add("_sum", histogram.GetSampleSum())
+				add("_count", float64(histogram.GetSampleCount()))
+			default:
+				add("", m.GetUntyped().GetValue())
+			}
+		}
+	}
+
+	return samples
+}
+
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, +1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	case math.IsNaN(f):
+		return "NaN"
+	default:
+		return strconv.FormatFloat(f, 'g', -1, 64)
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/f1shl3gs/libvirt_exporter/exporter"
+)
+
+// sdGroup is a target group of Prometheus HTTP service discovery
+type sdGroup struct {
+	Targets []string          `json:"targets"`
+	Labels  map[string]string `json:"labels"`
+}
+
+// sdHandler serves the hypervisors in the format of Prometheus HTTP
+// service discovery, every one of them is a target probed via this
+// exporter, i.e. the address Prometheus reached the exporter with.
+func sdHandler(lc *exporter.MultiExporter, https bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		scheme := "http"
+		if https {
+			scheme = "https"
+		}
+
+		targets := lc.Targets()
+		uris := make([]string, 0, len(targets))
+		for _, target := range targets {
+			uris = append(uris, target.URI)
+		}
+
+		hosts := exporter.HostLabels(uris)
+		groups := make([]sdGroup, 0, len(targets))
+		for i, target := range targets {
+			labels := map[string]string{
+				"__scheme__":       scheme,
+				"__metrics_path__": "/probe",
+				"__param_target":   target.URI,
+				"host":             hosts[i],
+			}
+
+			for name, value := range target.Labels {
+				labels[name] = value
+			}
+
+			groups = append(groups, sdGroup{
+				Targets: []string{r.Host},
+				Labels:  labels,
+			})
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(groups)
+	})
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// keep the datagrams within the MTU of most networks
+const statsdMaxPacket = 1432
+
+var statsdEscaper = strings.NewReplacer(".", "_", ":", "_", "|", "_", "@", "_", " ", "_", "#", "_", ",", "_")
+
+// statsdWriter sends the gauges and counters to a statsd server over UDP.
+// Gauges are sent as they are, while counters are sent as the increase
+// since the last push. The labels are appended to the name as
+// .name_value, or sent as tags in the DogStatsD format.
+type statsdWriter struct {
+	address   string
+	prefix    string
+	dogstatsd bool
+
+	mu       sync.Mutex
+	conn     net.Conn
+	counters map[string]float64
+}
+
+func (w *statsdWriter) write(families []*dto.MetricFamily, now time.Time) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.conn == nil {
+		conn, err := net.Dial("udp", w.address)
+		if err != nil {
+			return err
+		}
+
+		w.conn = conn
+		w.counters = make(map[string]float64)
+	}
+
+	seen := make(map[string]float64, len(w.counters))
+	var packet bytes.Buffer
+	for _, family := range families {
+		var counter bool
+		switch family.GetType() {
+		case dto.MetricType_COUNTER:
+			counter = true
+		case dto.MetricType_GAUGE, dto.MetricType_UNTYPED:
+		default:
+			continue
+		}
+
+		for _, m := range family.GetMetric() {
+			labels := sortedLabels(m)
+			name := w.name(family.GetName(), labels)
+
+			var tags string
+			if w.dogstatsd {
+				tags = w.tags(labels)
+			}
+
+			var lines []string
+			if counter {
+				key := family.GetName() + formatLabels(labels)
+				value := m.GetCounter().GetValue()
+				previous, ok := w.counters[key]
+				seen[key] = value
+
+				// nothing to send for new or reset counters
+				if !ok || value < previous {
+					continue
+				}
+
+				lines = append(lines, name+":"+strconv.FormatFloat(value-previous, 'f', -1, 64)+"|c"+tags)
+			} else {
+				value := m.GetGauge().GetValue()
+				if family.GetType() == dto.MetricType_UNTYPED {
+					value = m.GetUntyped().GetValue()
+				}
+
+				// a signed gauge would be taken as a change, so it's
+				// reset to 0 first
+				if value < 0 {
+					lines = append(lines, name+":0|g"+tags)
+				}
+				lines = append(lines, name+":"+strconv.FormatFloat(value, 'f', -1, 64)+"|g"+tags)
+			}
+
+			for _, line := range lines {
+				if packet.Len() > 0 && packet.Len()+len(line)+1 > statsdMaxPacket {
+					w.conn.Write(packet.Bytes())
+					packet.Reset()
+				}
+
+				if packet.Len() > 0 {
+					packet.WriteByte('\n')
+				}
+				packet.WriteString(line)
+			}
+		}
+	}
+
+	w.counters = seen
+	if packet.Len() == 0 {
+		return nil
+	}
+
+	_, err := w.conn.Write(packet.Bytes())
+	return err
+}
+
+func (w *statsdWriter) name(name string, labels []label) string {
+	if w.prefix != "" {
+		name = w.prefix + "." + name
+	}
+
+	if w.dogstatsd {
+		return name
+	}
+
+	for _, l := range labels {
+		name += "." + statsdEscaper.Replace(l.name+"_"+l.value)
+	}
+
+	return name
+}
+
+func (w *statsdWriter) tags(labels []label) string {
+	if len(labels) == 0 {
+		return ""
+	}
+
+	tags := make([]string, 0, len(labels))
+	for _, l := range labels {
+		tags = append(tags, statsdEscaper.Replace(l.name)+":"+statsdEscaper.Replace(l.value))
+	}
+
+	return "|#" + strings.Join(tags, ",")
+}
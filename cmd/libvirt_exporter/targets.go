@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/f1shl3gs/libvirt_exporter/exporter"
+)
+
+// targetGroup is the layout of the targets file, the same as the file
+// based service discovery of Prometheus, either in YAML or JSON.
+type targetGroup struct {
+	Targets []string          `yaml:"targets"`
+	Labels  map[string]string `yaml:"labels"`
+}
+
+func loadTargets(path string) ([]exporter.Target, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var groups []targetGroup
+	if err = yaml.UnmarshalStrict(data, &groups); err != nil {
+		return nil, fmt.Errorf("parse targets file failed, %s", err)
+	}
+
+	var targets []exporter.Target
+	for _, group := range groups {
+		for name := range group.Labels {
+			if name == "host" {
+				return nil, fmt.Errorf("label host is reserved")
+			}
+
+			if !exporter.ValidLabelName(name) {
+				return nil, fmt.Errorf("invalid label name %q", name)
+			}
+		}
+
+		for _, uri := range group.Targets {
+			targets = append(targets, exporter.Target{
+				URI:    uri,
+				Labels: group.Labels,
+			})
+		}
+	}
+
+	return targets, nil
+}
+
+// watchTargets reloads the targets file once it's modified, checked every
+// interval. The targets are kept if the file turns out to be invalid.
+func watchTargets(path string, interval time.Duration, lc *exporter.MultiExporter) {
+	var modTime time.Time
+	if fi, err := os.Stat(path); err == nil {
+		modTime = fi.ModTime()
+	}
+
+	for range time.Tick(interval) {
+		fi, err := os.Stat(path)
+		if err != nil {
+			log.Printf("stat targets file failed, %s\n", err)
+			continue
+		}
+
+		if fi.ModTime().Equal(modTime) {
+			continue
+		}
+		modTime = fi.ModTime()
+
+		targets, err := loadTargets(path)
+		if err != nil {
+			log.Printf("reload targets failed, %s\n", err)
+			continue
+		}
+
+		lc.SetTargets(targets)
+		log.Printf("targets reloaded, %d targets\n", len(targets))
+	}
+}
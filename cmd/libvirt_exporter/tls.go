@@ -0,0 +1,126 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// tlsReloader serves the certificate and the client CAs loaded most
+// recently, so renewed certificates are picked up by a config reload
+// without restarting the exporter.
+type tlsReloader struct {
+	mu         sync.RWMutex
+	cert       *tls.Certificate
+	roots      *x509.CertPool
+	clientAuth tls.ClientAuthType
+	minVersion uint16
+
+	// loaded is set once the first config is loaded, the listener is
+	// plaintext or HTTPS from then on
+	loaded bool
+}
+
+// enabled reports whether a certificate is loaded, HTTPS could not be
+// turned on or off by reloading.
+func (t *tlsReloader) enabled() bool {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	return t.cert != nil
+}
+
+func (t *tlsReloader) load(cfg TLSServerConfig) error {
+	t.mu.RLock()
+	plaintext := t.loaded && t.cert == nil
+	t.mu.RUnlock()
+
+	if cfg.CertFile == "" && cfg.KeyFile == "" {
+		// HTTPS is served already, it would fail every handshake
+		if t.enabled() {
+			return fmt.Errorf("tls_server_config is required, HTTPS could not be turned off by reloading")
+		}
+
+		t.mu.Lock()
+		t.loaded = true
+		t.mu.Unlock()
+
+		return nil
+	}
+
+	// the listener is plaintext already, a handshake would never happen
+	if plaintext {
+		return fmt.Errorf("tls_server_config could not turn HTTPS on by reloading, restart the exporter to serve HTTPS")
+	}
+
+	cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+	if err != nil {
+		return fmt.Errorf("load certificate failed, %s", err)
+	}
+
+	var roots *x509.CertPool
+	if cfg.ClientCAFile != "" {
+		data, err := os.ReadFile(cfg.ClientCAFile)
+		if err != nil {
+			return fmt.Errorf("read client CA file failed, %s", err)
+		}
+
+		roots = x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(data) {
+			return fmt.Errorf("no certificate found in %s", cfg.ClientCAFile)
+		}
+	}
+
+	// verify client certificates by default if the CAs are given
+	clientAuth := tls.NoClientCert
+	if roots != nil {
+		clientAuth = tls.RequireAndVerifyClientCert
+	}
+	if cfg.ClientAuthType != "" {
+		var ok bool
+		if clientAuth, ok = clientAuthTypes[cfg.ClientAuthType]; !ok {
+			return fmt.Errorf("invalid client_auth_type %q", cfg.ClientAuthType)
+		}
+	}
+
+	minVersion := uint16(tls.VersionTLS12)
+	if cfg.MinVersion != "" {
+		var ok bool
+		if minVersion, ok = tlsVersions[cfg.MinVersion]; !ok {
+			return fmt.Errorf("invalid min_version %q", cfg.MinVersion)
+		}
+	}
+
+	t.mu.Lock()
+	t.cert = &cert
+	t.roots = roots
+	t.clientAuth = clientAuth
+	t.minVersion = minVersion
+	t.loaded = true
+	t.mu.Unlock()
+
+	return nil
+}
+
+func (t *tlsReloader) config() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			t.mu.RLock()
+			defer t.mu.RUnlock()
+
+			if t.cert == nil {
+				return nil, fmt.Errorf("no certificate loaded")
+			}
+
+			return &tls.Config{
+				MinVersion:   t.minVersion,
+				Certificates: []tls.Certificate{*t.cert},
+				ClientAuth:   t.clientAuth,
+				ClientCAs:    t.roots,
+			}, nil
+		},
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/common/version"
+
+	"github.com/f1shl3gs/libvirt_exporter/exporter"
+)
+
+// spanWriter sends the spans of the collections to an OpenTelemetry
+// collector over OTLP/HTTP in the JSON encoding. The spans are queued,
+// so a slow collector never delays a scrape, they are dropped when the
+// queue is full.
+type spanWriter struct {
+	pusher
+
+	queue chan []exporter.Span
+}
+
+// the subset of the OTLP trace data model in use
+type (
+	otlpTraceRequest struct {
+		ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+	}
+
+	otlpResourceSpans struct {
+		Resource   otlpResource     `json:"resource"`
+		ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+	}
+
+	otlpScopeSpans struct {
+		Scope otlpScope  `json:"scope"`
+		Spans []otlpSpan `json:"spans"`
+	}
+
+	otlpSpan struct {
+		TraceID           string          `json:"traceId"`
+		SpanID            string          `json:"spanId"`
+		ParentSpanID      string          `json:"parentSpanId,omitempty"`
+		Name              string          `json:"name"`
+		Kind              int             `json:"kind"`
+		StartTimeUnixNano string          `json:"startTimeUnixNano"`
+		EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+		Attributes        []otlpAttribute `json:"attributes,omitempty"`
+		Status            otlpStatus      `json:"status"`
+	}
+
+	otlpStatus struct {
+		Code    int    `json:"code"`
+		Message string `json:"message,omitempty"`
+	}
+
+	otlpResource struct {
+		Attributes []otlpAttribute `json:"attributes"`
+	}
+
+	otlpScope struct {
+		Name    string `json:"name"`
+		Version string `json:"version"`
+	}
+
+	otlpAttribute struct {
+		Key   string `json:"key"`
+		Value struct {
+			StringValue string `json:"stringValue"`
+		} `json:"value"`
+	}
+)
+
+// span kinds and status codes of OTLP
+const (
+	otlpSpanKindInternal = 1
+	otlpSpanKindClient   = 3
+
+	otlpStatusOk    = 1
+	otlpStatusError = 2
+)
+
+func newSpanWriter(p pusher) *spanWriter {
+	w := &spanWriter{pusher: p, queue: make(chan []exporter.Span, 16)}
+	go func() {
+		for spans := range w.queue {
+			if err := w.write(spans); err != nil {
+				log.Printf("export spans to %s failed, %s\n", w.url, err)
+			}
+		}
+	}()
+
+	return w
+}
+
+func (w *spanWriter) ExportSpans(spans []exporter.Span) {
+	select {
+	case w.queue <- spans:
+	default:
+		log.Printf("span queue is full, spans of the scrape dropped\n")
+	}
+}
+
+func (w *spanWriter) write(spans []exporter.Span) error {
+	var zero [8]byte
+
+	converted := make([]otlpSpan, 0, len(spans))
+	for _, span := range spans {
+		end := span.End
+		if end.IsZero() {
+			end = span.Start
+		}
+
+		s := otlpSpan{
+			TraceID:           hex.EncodeToString(span.TraceID[:]),
+			SpanID:            hex.EncodeToString(span.ID[:]),
+			Name:              span.Name,
+			Kind:              otlpSpanKindInternal,
+			StartTimeUnixNano: unixNano(span.Start),
+			EndTimeUnixNano:   unixNano(end),
+			Status:            otlpStatus{Code: otlpStatusOk},
+		}
+		if span.ParentID != zero {
+			s.ParentSpanID = hex.EncodeToString(span.ParentID[:])
+		}
+		if _, ok := span.Attributes["rpc.system"]; ok {
+			s.Kind = otlpSpanKindClient
+		}
+		for _, l := range sortedAttributes(span.Attributes) {
+			attr := otlpAttribute{Key: l.name}
+			attr.Value.StringValue = l.value
+			s.Attributes = append(s.Attributes, attr)
+		}
+		if span.Err != nil {
+			s.Status = otlpStatus{Code: otlpStatusError, Message: span.Err.Error()}
+		}
+
+		converted = append(converted, s)
+	}
+
+	serviceName := otlpAttribute{Key: "service.name"}
+	serviceName.Value.StringValue = "libvirt_exporter"
+	body, err := json.Marshal(otlpTraceRequest{
+		ResourceSpans: []otlpResourceSpans{{
+			Resource: otlpResource{Attributes: []otlpAttribute{serviceName}},
+			ScopeSpans: []otlpScopeSpans{{
+				Scope: otlpScope{Name: "libvirt_exporter", Version: version.Version},
+				Spans: converted,
+			}},
+		}},
+	})
+	if err != nil {
+		return err
+	}
+
+	return w.post(body, http.Header{
+		"Content-Type": []string{"application/json"},
+	})
+}
+
+func sortedAttributes(attrs map[string]string) []label {
+	labels := make([]label, 0, len(attrs))
+	for name, value := range attrs {
+		labels = append(labels, label{name: name, value: value})
+	}
+
+	sort.Slice(labels, func(i, j int) bool { return labels[i].name < labels[j].name })
+	return labels
+}
+
+func unixNano(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/f1shl3gs/libvirt_exporter/exporter"
+)
+
+// validation checks the config files and libvirt URIs given by the
+// flags without connecting to anything, for gating config changes in
+// CI/CD pipelines.
+type validation struct {
+	configFile    string
+	webConfigFile string
+	targetsFile   string
+	uris          []string
+
+	// the flags overriding the config files, if given
+	include     string
+	exclude     string
+	title       string
+	tlsCertFile string
+	tlsKeyFile  string
+	tlsClientCA string
+	authUsers   string
+	authToken   string
+
+	problems []string
+}
+
+func (v *validation) problem(format string, args ...interface{}) {
+	v.problems = append(v.problems, fmt.Sprintf(format, args...))
+}
+
+// run prints the problems found, the exit code is 1 if there is any.
+func (v *validation) run(w io.Writer) int {
+	v.config()
+	v.webConfig()
+	v.targets()
+	for _, uri := range v.uris {
+		if err := exporter.ValidateURI(uri); err != nil {
+			v.problem("libvirt uri %s: %s", uri, err)
+		}
+	}
+
+	for _, problem := range v.problems {
+		fmt.Fprintf(w, "%s\n", problem)
+	}
+
+	if len(v.problems) != 0 {
+		return 1
+	}
+
+	fmt.Fprintf(w, "config is valid\n")
+	return 0
+}
+
+func (v *validation) config() {
+	cfg, err := loadConfig(v.configFile)
+	if err != nil {
+		v.problem("config file %s: %s", v.configFile, err)
+		return
+	}
+
+	if v.include != "" {
+		cfg.Domain.Include = v.include
+	}
+	if v.exclude != "" {
+		cfg.Domain.Exclude = v.exclude
+	}
+	if v.title != "" {
+		cfg.Domain.Title = v.title
+	}
+
+	if _, err = cfg.Domain.Compile(); err != nil {
+		v.problem("config file %s: invalid domain filter, %s", v.configFile, err)
+	}
+
+	if err = cfg.Relabel.Validate(); err != nil {
+		v.problem("config file %s: invalid relabel config, %s", v.configFile, err)
+	}
+}
+
+func (v *validation) webConfig() {
+	web, err := loadWebConfig(v.webConfigFile)
+	if err != nil {
+		v.problem("web config file %s: %s", v.webConfigFile, err)
+		return
+	}
+
+	if v.tlsCertFile != "" {
+		web.TLSServerConfig.CertFile = v.tlsCertFile
+	}
+	if v.tlsKeyFile != "" {
+		web.TLSServerConfig.KeyFile = v.tlsKeyFile
+	}
+	if v.tlsClientCA != "" {
+		web.TLSServerConfig.ClientCAFile = v.tlsClientCA
+	}
+	if v.authUsers != "" {
+		if web.BasicAuthUsers, err = loadUsersFile(v.authUsers); err != nil {
+			v.problem("basic auth users file %s: %s", v.authUsers, err)
+		}
+	}
+
+	// loads the certificates into a throwaway reloader
+	if err = (&tlsReloader{}).load(web.TLSServerConfig); err != nil {
+		v.problem("web config file %s: %s", v.webConfigFile, err)
+	}
+
+	if err = (&authenticator{}).load(web.BasicAuthUsers, v.authToken); err != nil {
+		v.problem("web config file %s: %s", v.webConfigFile, err)
+	}
+}
+
+func (v *validation) targets() {
+	if v.targetsFile == "" {
+		return
+	}
+
+	targets, err := loadTargets(v.targetsFile)
+	if err != nil {
+		v.problem("targets file %s: %s", v.targetsFile, err)
+		return
+	}
+
+	for _, target := range targets {
+		v.uris = append(v.uris, target.URI)
+	}
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/version"
+)
+
+// newBuildInfo returns the constant libvirt_exporter_build_info gauge,
+// its labels are injected at build time by the ldflags in the Makefile.
+func newBuildInfo() prometheus.Collector {
+	g := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "libvirt_exporter_build_info",
+		Help: "A metric with a constant '1' value labeled by the version, revision, branch, build date and Go version of libvirt_exporter.",
+		ConstLabels: prometheus.Labels{
+			"version":   version.Version,
+			"revision":  version.Revision,
+			"branch":    version.Branch,
+			"builddate": version.BuildDate,
+			"goversion": version.GoVersion,
+		},
+	})
+	g.Set(1)
+
+	return g
+}
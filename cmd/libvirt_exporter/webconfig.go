@@ -0,0 +1,95 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"os"
+
+	"gopkg.in/alecthomas/kingpin.v2"
+	"gopkg.in/yaml.v2"
+)
+
+// webFlags are the flags of TLS and auth, of the serve command and of
+// validate-config.
+type webFlags struct {
+	configFile  *string
+	tlsCertFile *string
+	tlsKeyFile  *string
+	tlsClientCA *string
+	authUsers   *string
+	authToken   *string
+}
+
+func addWebFlags(cmd *kingpin.CmdClause) *webFlags {
+	return &webFlags{
+		configFile:  cmd.Flag("web.config.file", "Path of the web config file in the layout of exporter-toolkit, for TLS and basic auth, it is reloaded along with the config file").String(),
+		tlsCertFile: cmd.Flag("web.tls-cert-file", "Path of the TLS certificate, HTTPS is enabled if it's set").String(),
+		tlsKeyFile:  cmd.Flag("web.tls-key-file", "Path of the TLS private key").String(),
+		tlsClientCA: cmd.Flag("web.tls-client-ca-file", "Path of the CA certificates to verify client certificates, client certificates are required if it's set").String(),
+		authUsers:   cmd.Flag("web.basic-auth-users-file", "Path of the YAML file mapping usernames to bcrypt hashes of their passwords").String(),
+		authToken:   cmd.Flag("web.bearer-token-file", "Path of the file containing the bearer token").String(),
+	}
+}
+
+// WebConfig is loaded from --web.config.file, it follows the layout of
+// the web config file of prometheus/exporter-toolkit.
+type WebConfig struct {
+	TLSServerConfig TLSServerConfig   `yaml:"tls_server_config"`
+	BasicAuthUsers  map[string]string `yaml:"basic_auth_users"`
+}
+
+type TLSServerConfig struct {
+	CertFile       string `yaml:"cert_file"`
+	KeyFile        string `yaml:"key_file"`
+	ClientAuthType string `yaml:"client_auth_type"`
+	ClientCAFile   string `yaml:"client_ca_file"`
+	MinVersion     string `yaml:"min_version"`
+}
+
+var clientAuthTypes = map[string]tls.ClientAuthType{
+	"NoClientCert":               tls.NoClientCert,
+	"RequestClientCert":          tls.RequestClientCert,
+	"RequireAnyClientCert":       tls.RequireAnyClientCert,
+	"VerifyClientCertIfGiven":    tls.VerifyClientCertIfGiven,
+	"RequireAndVerifyClientCert": tls.RequireAndVerifyClientCert,
+}
+
+var tlsVersions = map[string]uint16{
+	"TLS10": tls.VersionTLS10,
+	"TLS11": tls.VersionTLS11,
+	"TLS12": tls.VersionTLS12,
+	"TLS13": tls.VersionTLS13,
+}
+
+func loadWebConfig(path string) (*WebConfig, error) {
+	cfg := &WebConfig{}
+	if path == "" {
+		return cfg, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = yaml.UnmarshalStrict(data, cfg); err != nil {
+		return nil, fmt.Errorf("parse web config file failed, %s", err)
+	}
+
+	return cfg, nil
+}
+
+// loadUsersFile loads the YAML map from usernames to password hashes
+func loadUsersFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read basic auth users file failed, %s", err)
+	}
+
+	var users map[string]string
+	if err = yaml.UnmarshalStrict(data, &users); err != nil {
+		return nil, fmt.Errorf("parse basic auth users file failed, %s", err)
+	}
+
+	return users, nil
+}
@@ -0,0 +1,430 @@
+package exporter
+
+import (
+	"encoding/json"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/digitalocean/go-libvirt"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	errAgentBusy   = errors.New("too many guest agent commands in flight")
+	errAgentBudget = errors.New("guest agent budget of the collection spent")
+)
+
+type guestOSInfo struct {
+	ID            string `json:"id"`
+	Name          string `json:"name"`
+	PrettyName    string `json:"pretty-name"`
+	Version       string `json:"version"`
+	VersionID     string `json:"version-id"`
+	KernelRelease string `json:"kernel-release"`
+	KernelVersion string `json:"kernel-version"`
+	Machine       string `json:"machine"`
+}
+
+type guestFilesystem struct {
+	Name       string      `json:"name"`
+	Mountpoint string      `json:"mountpoint"`
+	Type       string      `json:"type"`
+	UsedBytes  *uint64     `json:"used-bytes"`
+	TotalBytes *uint64     `json:"total-bytes"`
+	Disks      []guestDisk `json:"disk"`
+}
+
+type guestDisk struct {
+	BusType       string          `json:"bus-type"`
+	Bus           uint64          `json:"bus"`
+	Target        uint64          `json:"target"`
+	Unit          uint64          `json:"unit"`
+	PCIController guestPCIAddress `json:"pci-controller"`
+	Dev           string          `json:"dev"`
+	Serial        string          `json:"serial"`
+}
+
+type guestPCIAddress struct {
+	Domain   uint64 `json:"domain"`
+	Bus      uint64 `json:"bus"`
+	Slot     uint64 `json:"slot"`
+	Function uint64 `json:"function"`
+}
+
+type guestVCPU struct {
+	LogicalID  int  `json:"logical-id"`
+	Online     bool `json:"online"`
+	CanOffline bool `json:"can-offline"`
+}
+
+type guestUser struct {
+	User      string  `json:"user"`
+	Domain    string  `json:"domain"`
+	LoginTime float64 `json:"login-time"`
+}
+
+// agentCommand executes cmd by the qemu-guest-agent of the domain, the
+// "return" field of the response is decoded into v.
+//
+// A hung agent must never stall the scrape, so the command is abandoned
+// after the timeout, or once the agent budget of the collection is spent.
+// left holds the nanoseconds of the budget left, shared by the domains of
+// the collection, nil means no limit. The abandoned call still holds its
+// in-flight slot until libvirt gives up or the connection is closed, which
+// bounds the number of calls piling up on broken agents.
+func (e *Exporter) agentCommand(cli *client, left *int64, domain libvirt.Domain, cmd string, v interface{}) error {
+	wait := e.agentTimeout
+	if left != nil {
+		budget := time.Duration(atomic.LoadInt64(left))
+		if budget <= 0 {
+			Stats.Add(statAgentBusy, 1)
+			return errAgentBudget
+		}
+		if budget < wait {
+			wait = budget
+		}
+
+		start := time.Now()
+		defer func() {
+			atomic.AddInt64(left, -int64(time.Since(start)))
+		}()
+	}
+
+	select {
+	case e.agentInFlight <- struct{}{}:
+	default:
+		Stats.Add(statAgentBusy, 1)
+		return errAgentBusy
+	}
+
+	// libvirt takes the timeout in seconds, the call is given up once the
+	// wait is over, so is the in-flight slot it holds
+	timeout := int32(wait / time.Second)
+	if timeout < 1 {
+		timeout = 1
+	}
+
+	type response struct {
+		result libvirt.OptString
+		err    error
+	}
+
+	Stats.Add(statAgentCommands, 1)
+	done := make(chan response, 1)
+	go func() {
+		defer func() { <-e.agentInFlight }()
+
+		result, err := cli.QEMUDomainAgentCommand(domain, `{"execute":"`+cmd+`"}`, timeout, 0)
+		done <- response{result, err}
+	}()
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	var result libvirt.OptString
+	select {
+	case resp := <-done:
+		if resp.err != nil {
+			return errors.Wrapf(resp.err, "failed to execute %s", cmd)
+		}
+
+		result = resp.result
+	case <-timer.C:
+		Stats.Add(statAgentTimeouts, 1)
+		return errors.Errorf("%s timed out after %s", cmd, wait)
+	}
+
+	if len(result) == 0 {
+		return errors.Errorf("empty response of %s", cmd)
+	}
+
+	var resp struct {
+		Return json.RawMessage `json:"return"`
+	}
+	if err := json.Unmarshal([]byte(result[0]), &resp); err != nil {
+		return errors.Wrapf(err, "failed to unmarshal response of %s", cmd)
+	}
+
+	if v == nil {
+		return nil
+	}
+
+	return json.Unmarshal(resp.Return, v)
+}
+
+type guestAgentCollector struct{ e *Exporter }
+
+func (c guestAgentCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.e.guestAgentUp
+	ch <- c.e.guestOSInfo
+	ch <- c.e.guestFsSize
+	ch <- c.e.guestFsUsed
+	ch <- c.e.guestClockDrift
+	ch <- c.e.guestUsers
+	ch <- c.e.guestFrozen
+	ch <- c.e.guestDiskInfo
+	ch <- c.e.guestVCPUs
+}
+
+// CollectDomain queries the agent of running domains only, the errors
+// are logged, never returned.
+func (c guestAgentCollector) CollectDomain(ch chan<- prometheus.Metric, cli *client, d *domainContext) error {
+	if d.state == uint8(libvirt.DomainRunning) {
+		d.hostname = c.e.collectGuestAgent(ch, cli, d.agentLeft, d.domain, d.uuid, d.schema)
+	}
+
+	return nil
+}
+
+// collectGuestAgent reports metrics of running domain from the guest agent,
+// a missing or broken agent is quite normal, so errors are logged only.
+// The hostname reported by the guest is returned if there is one.
+func (e *Exporter) collectGuestAgent(ch chan<- prometheus.Metric, cli *client, left *int64, domain libvirt.Domain, uuid string, schema *Domain) string {
+	up := 1.0
+	err := e.agentCommand(cli, left, domain, "guest-ping", nil)
+	switch {
+	case err == errAgentBusy || err == errAgentBudget:
+		// the agent is not asked rather than down, hung agents of other
+		// domains holding the slots or spending the budget must not
+		// report the healthy ones down
+		return ""
+	case err != nil:
+		up = 0
+	}
+
+	ch <- e.constMetric(
+		e.guestAgentUp,
+		prometheus.GaugeValue,
+		up,
+		domain.Name, uuid)
+
+	// no need to query an unreachable agent any further
+	if err != nil {
+		return ""
+	}
+
+	if err := e.collectGuestOSInfo(ch, cli, left, domain, uuid); err != nil {
+		e.logger.Printf("collect guest os info of %s failed, %s\n", domain.Name, err)
+	}
+
+	if err := e.collectGuestFilesystems(ch, cli, left, domain, uuid, schema); err != nil {
+		e.logger.Printf("collect guest filesystems of %s failed, %s\n", domain.Name, err)
+	}
+
+	if err := e.collectGuestTime(ch, cli, left, domain, uuid); err != nil {
+		e.logger.Printf("collect guest time of %s failed, %s\n", domain.Name, err)
+	}
+
+	if err := e.collectGuestUsers(ch, cli, left, domain, uuid); err != nil {
+		e.logger.Printf("collect guest users of %s failed, %s\n", domain.Name, err)
+	}
+
+	if err := e.collectGuestFreezeStatus(ch, cli, left, domain, uuid); err != nil {
+		e.logger.Printf("collect guest fsfreeze status of %s failed, %s\n", domain.Name, err)
+	}
+
+	if err := e.collectGuestVCPUs(ch, cli, left, domain, uuid); err != nil {
+		e.logger.Printf("collect guest vcpus of %s failed, %s\n", domain.Name, err)
+	}
+
+	var hostname struct {
+		HostName string `json:"host-name"`
+	}
+	if err := e.agentCommand(cli, left, domain, "guest-get-host-name", &hostname); err != nil {
+		e.logger.Printf("get guest hostname of %s failed, %s\n", domain.Name, err)
+	}
+
+	return hostname.HostName
+}
+
+func (e *Exporter) collectGuestOSInfo(ch chan<- prometheus.Metric, cli *client, left *int64, domain libvirt.Domain, uuid string) error {
+	var osInfo guestOSInfo
+	if err := e.agentCommand(cli, left, domain, "guest-get-osinfo", &osInfo); err != nil {
+		return err
+	}
+
+	ch <- e.constMetric(
+		e.guestOSInfo,
+		prometheus.GaugeValue,
+		1,
+		domain.Name, uuid,
+		osInfo.ID,
+		osInfo.Name,
+		osInfo.Version,
+		osInfo.KernelRelease,
+		osInfo.Machine)
+
+	return nil
+}
+
+func (e *Exporter) collectGuestFilesystems(ch chan<- prometheus.Metric, cli *client, left *int64, domain libvirt.Domain, uuid string, schema *Domain) error {
+	var filesystems []guestFilesystem
+	if err := e.agentCommand(cli, left, domain, "guest-get-fsinfo", &filesystems); err != nil {
+		return err
+	}
+
+	for _, fs := range filesystems {
+		for _, gd := range fs.Disks {
+			disk := matchGuestDisk(schema.Devices.Disks, gd)
+			if disk == nil {
+				continue
+			}
+
+			ch <- e.constMetric(
+				e.guestDiskInfo,
+				prometheus.GaugeValue,
+				1,
+				domain.Name, uuid,
+				fs.Mountpoint,
+				gd.Dev,
+				disk.Target.Device,
+				diskSource(disk))
+		}
+
+		// usage is reported since qemu-guest-agent 5.0
+		if fs.TotalBytes == nil || fs.UsedBytes == nil {
+			continue
+		}
+
+		ch <- e.constMetric(
+			e.guestFsSize,
+			prometheus.GaugeValue,
+			float64(*fs.TotalBytes),
+			domain.Name, uuid,
+			fs.Mountpoint, fs.Type, fs.Name)
+		ch <- e.constMetric(
+			e.guestFsUsed,
+			prometheus.GaugeValue,
+			float64(*fs.UsedBytes),
+			domain.Name, uuid,
+			fs.Mountpoint, fs.Type, fs.Name)
+	}
+
+	return nil
+}
+
+func (e *Exporter) collectGuestTime(ch chan<- prometheus.Metric, cli *client, left *int64, domain libvirt.Domain, uuid string) error {
+	var nanos int64
+
+	start := time.Now()
+	if err := e.agentCommand(cli, left, domain, "guest-get-time", &nanos); err != nil {
+		return err
+	}
+
+	// compare with the middle of the round trip
+	host := start.Add(time.Since(start) / 2)
+	drift := time.Unix(0, nanos).Sub(host)
+
+	ch <- e.constMetric(
+		e.guestClockDrift,
+		prometheus.GaugeValue,
+		drift.Seconds(),
+		domain.Name, uuid)
+
+	return nil
+}
+
+func (e *Exporter) collectGuestUsers(ch chan<- prometheus.Metric, cli *client, left *int64, domain libvirt.Domain, uuid string) error {
+	var users []guestUser
+	if err := e.agentCommand(cli, left, domain, "guest-get-users", &users); err != nil {
+		return err
+	}
+
+	ch <- e.constMetric(
+		e.guestUsers,
+		prometheus.GaugeValue,
+		float64(len(users)),
+		domain.Name, uuid)
+
+	return nil
+}
+
+func (e *Exporter) collectGuestFreezeStatus(ch chan<- prometheus.Metric, cli *client, left *int64, domain libvirt.Domain, uuid string) error {
+	var status string
+	if err := e.agentCommand(cli, left, domain, "guest-fsfreeze-status", &status); err != nil {
+		return err
+	}
+
+	frozen := 0.0
+	if status == "frozen" {
+		frozen = 1
+	}
+
+	ch <- e.constMetric(
+		e.guestFrozen,
+		prometheus.GaugeValue,
+		frozen,
+		domain.Name, uuid)
+
+	return nil
+}
+
+// matchGuestDisk finds the disk of the domain which backs the disk seen by
+// the guest. The serial is the most reliable, then virtio disks are matched
+// by their PCI address, and the others by their drive address.
+func matchGuestDisk(disks []Disk, gd guestDisk) *Disk {
+	for i := range disks {
+		disk := &disks[i]
+
+		if gd.Serial != "" && disk.Serial == gd.Serial {
+			return disk
+		}
+
+		addr := disk.Address
+		switch {
+		case gd.BusType == "virtio" && addr.Type == "pci":
+			if parseAddr(addr.Domain) == gd.PCIController.Domain &&
+				parseAddr(addr.Bus) == gd.PCIController.Bus &&
+				parseAddr(addr.Slot) == gd.PCIController.Slot &&
+				parseAddr(addr.Function) == gd.PCIController.Function {
+				return disk
+			}
+		case gd.BusType == disk.Target.Bus && addr.Type == "drive":
+			if parseAddr(addr.Bus) == gd.Bus &&
+				parseAddr(addr.Target) == gd.Target &&
+				parseAddr(addr.Unit) == gd.Unit {
+				return disk
+			}
+		}
+	}
+
+	return nil
+}
+
+// parseAddr parses the address attribute of a device, which could be
+// decimal or hex, like "0x04".
+func parseAddr(s string) uint64 {
+	n, _ := strconv.ParseUint(s, 0, 64)
+	return n
+}
+
+func (e *Exporter) collectGuestVCPUs(ch chan<- prometheus.Metric, cli *client, left *int64, domain libvirt.Domain, uuid string) error {
+	var vcpus []guestVCPU
+	if err := e.agentCommand(cli, left, domain, "guest-get-vcpus", &vcpus); err != nil {
+		return err
+	}
+
+	var online, offline int
+	for _, vcpu := range vcpus {
+		if vcpu.Online {
+			online++
+		} else {
+			offline++
+		}
+	}
+
+	ch <- e.constMetric(
+		e.guestVCPUs,
+		prometheus.GaugeValue,
+		float64(online),
+		domain.Name, uuid, "online")
+	ch <- e.constMetric(
+		e.guestVCPUs,
+		prometheus.GaugeValue,
+		float64(offline),
+		domain.Name, uuid, "offline")
+
+	return nil
+}
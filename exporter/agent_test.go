@@ -0,0 +1,85 @@
+package exporter_test
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/f1shl3gs/libvirt_exporter/exporter"
+	"github.com/f1shl3gs/libvirt_exporter/exporter/libvirttest"
+)
+
+// newHungAgents returns a libvirt running web and three more domains,
+// whose guest agents never answer until the returned channel is closed.
+func newHungAgents() (*libvirttest.Fake, chan struct{}) {
+	f := newFake()
+	for i := 1; i <= 3; i++ {
+		d := f.Domains[0]
+		d.Name = fmt.Sprintf("web%d", i)
+		d.UUID[15] = byte(i)
+		f.Domains = append(f.Domains, d)
+	}
+
+	block := make(chan struct{})
+	f.Blocks = map[string]chan struct{}{"QEMUDomainAgentCommand": block}
+
+	return f, block
+}
+
+func TestGuestAgentLimits(t *testing.T) {
+	// only the first agent is asked, and reported down once it times out
+	expected := `
+# HELP libvirt_domain_guest_agent_up Whether the guest agent of the domain responds to ping.
+# TYPE libvirt_domain_guest_agent_up gauge
+libvirt_domain_guest_agent_up{domain="web",uuid="6a5d2c3e-8d3b-4b8e-9f4a-2b1c0d9e8f7a"} 0
+`
+
+	for _, tc := range []struct {
+		name string
+		opts []exporter.Option
+	}{
+		{
+			// the hung agent holds the only slot
+			name: "in-flight",
+			opts: []exporter.Option{
+				exporter.WithGuestAgent(10*time.Millisecond, 1),
+				exporter.WithGuestAgentBudget(0),
+			},
+		},
+		{
+			// the hung agent spends the budget
+			name: "budget",
+			opts: []exporter.Option{
+				exporter.WithGuestAgent(time.Minute, 8),
+				exporter.WithGuestAgentBudget(30 * time.Millisecond),
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			f, block := newHungAgents()
+			defer close(block)
+
+			var logs bytes.Buffer
+			opts := append(tc.opts, exporter.WithLogger(log.New(&logs, "", 0)))
+			reg := newExporter(t, f, opts...)
+
+			start := time.Now()
+			err := testutil.GatherAndCompare(reg, strings.NewReader(expected),
+				"libvirt_domain_guest_agent_up")
+			if err != nil {
+				t.Error(err)
+			}
+			if took := time.Since(start); took > time.Second {
+				t.Errorf("gather took %s with hung agents", took)
+			}
+			if n := f.Calls("QEMUDomainAgentCommand"); n != 1 {
+				t.Errorf("QEMUDomainAgentCommand called %d times, want 1", n)
+			}
+		})
+	}
+}
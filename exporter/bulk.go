@@ -0,0 +1,95 @@
+package exporter
+
+import (
+	"fmt"
+
+	"github.com/digitalocean/go-libvirt"
+	"github.com/pkg/errors"
+)
+
+// typedParams maps the fields of the typed parameters to their values.
+type typedParams map[string]libvirt.TypedParamValue
+
+func (p typedParams) float(format string, args ...interface{}) (float64, bool) {
+	v, ok := p[fmt.Sprintf(format, args...)]
+	if !ok {
+		return 0, false
+	}
+
+	return typedParamFloat(v)
+}
+
+func (p typedParams) string(format string, args ...interface{}) string {
+	s, _ := p[fmt.Sprintf(format, args...)].I.(string)
+	return s
+}
+
+func (p typedParams) count(format string, args ...interface{}) int {
+	n, _ := p.float(format, args...)
+	return int(n)
+}
+
+// bulkStats are the bulk stats of the domains of a collection by uuid,
+// read by a single ConnectGetAllDomainStats for all the collectors
+// needing them instead of one call per domain and collector.
+type bulkStats struct {
+	params map[string]typedParams
+	err    error
+}
+
+// bulkGroups returns the groups of the bulk stats the enabled collectors
+// need, zero if none does.
+func bulkGroups(sc scope) libvirt.DomainStatsTypes {
+	var groups libvirt.DomainStatsTypes
+	if sc.enabled("perf") {
+		groups |= libvirt.DomainStatsPerf
+	}
+	// the cache monitors are in the cpu-total group, the memory bandwidth
+	// monitors in the memory group of libvirt 7.0
+	if sc.enabled("resctrl") {
+		groups |= libvirt.DomainStatsCPUTotal | libvirt.DomainStatsMemory
+	}
+	if sc.enabled("dirty-rate") {
+		groups |= libvirt.DomainStatsDirtyrate
+	}
+
+	return groups
+}
+
+// collectBulkStats reads the groups of the bulk stats of the domains, a
+// failure is kept for the collectors to report.
+func collectBulkStats(cli *client, domains []libvirt.Domain, groups libvirt.DomainStatsTypes) *bulkStats {
+	bulk := &bulkStats{params: make(map[string]typedParams, len(domains))}
+	if len(domains) == 0 {
+		return bulk
+	}
+
+	records, err := cli.ConnectGetAllDomainStats(domains, uint32(groups), 0)
+	if err != nil {
+		bulk.err = errors.Wrap(err, "failed to get bulk domain stats")
+		return bulk
+	}
+
+	for _, record := range records {
+		params := make(typedParams, len(record.Params))
+		for _, param := range record.Params {
+			params[param.Field] = param.Value
+		}
+		bulk.params[uuidConvert(record.Dom.UUID)] = params
+	}
+
+	return bulk
+}
+
+// bulkStats returns the bulk stats of the domain, empty if libvirt had
+// none for it, e.g. it was undefined since listed.
+func (d *domainContext) bulkStats() (typedParams, error) {
+	if d.bulk == nil {
+		return nil, errors.New("no bulk stats collected")
+	}
+	if d.bulk.err != nil {
+		return nil, d.bulk.err
+	}
+
+	return d.bulk.params[d.uuid], nil
+}
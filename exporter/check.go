@@ -0,0 +1,164 @@
+package exporter
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"time"
+
+	"github.com/digitalocean/go-libvirt"
+)
+
+// CheckStep is a step of Check.
+type CheckStep struct {
+	// dial, connect, list-domains or read-domain
+	Name     string
+	Duration time.Duration
+	Err      error
+
+	// what the step found out, e.g. the number of domains
+	Detail string
+}
+
+// Check dials the hypervisor, connects, lists the domains and reads the
+// XML of the first one step by step, to tell why collections fail, e.g.
+// the permissions of the socket or the authentication. It stops at the
+// first failed step.
+func (e *Exporter) Check(ctx context.Context) []CheckStep {
+	var steps []CheckStep
+	step := func(name string, fn func() (string, error)) bool {
+		start := time.Now()
+		detail, err := fn()
+		steps = append(steps, CheckStep{Name: name, Duration: time.Since(start), Err: err, Detail: detail})
+		return err == nil
+	}
+
+	var (
+		l       Libvirt
+		release func()
+	)
+	if !step("dial", func() (detail string, err error) {
+		l, release, err = e.open(ctx)
+		return e.uri, err
+	}) {
+		return steps
+	}
+	defer release()
+
+	cli := &client{l: l, e: e}
+	if !step("connect", func() (string, error) {
+		return "", cli.Connect()
+	}) {
+		return steps
+	}
+	defer cli.l.Disconnect()
+
+	var domains []libvirt.Domain
+	if !step("list-domains", func() (detail string, err error) {
+		domains, _, err = cli.ConnectListAllDomains(1, libvirt.ConnectListDomainsActive|libvirt.ConnectListDomainsInactive)
+		return fmt.Sprintf("%d domains", len(domains)), err
+	}) {
+		return steps
+	}
+
+	if len(domains) == 0 {
+		return steps
+	}
+
+	step("read-domain", func() (string, error) {
+		_, err := cli.DomainGetXMLDesc(domains[0], 0)
+		return domains[0].Name, err
+	})
+
+	return steps
+}
+
+// DomainStatus is a domain seen by the hypervisor, see ListDomains.
+type DomainStatus struct {
+	Name  string
+	UUID  string
+	State string
+
+	// why the domain is not collected, empty if it is
+	Skipped string
+}
+
+// ListDomains lists all domains of the hypervisor over the same
+// connection as collections, and tells why the ones not collected are
+// skipped, e.g. by the domain filter.
+func (e *Exporter) ListDomains(ctx context.Context) ([]DomainStatus, error) {
+	l, release, err := e.open(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	cli := &client{l: l, e: e}
+	if err = cli.Connect(); err != nil {
+		return nil, err
+	}
+	defer cli.l.Disconnect()
+
+	domains, _, err := cli.ConnectListAllDomains(1, libvirt.ConnectListDomainsActive|libvirt.ConnectListDomainsInactive)
+	if err != nil {
+		return nil, err
+	}
+
+	sc := e.scope()
+	result := make([]DomainStatus, 0, len(domains))
+	collected := 0
+	for _, domain := range domains {
+		status := DomainStatus{Name: domain.Name, UUID: uuidConvert(domain.UUID)}
+
+		state, _, _, _, _, err := cli.DomainGetInfo(domain)
+		if err != nil {
+			return nil, err
+		}
+		status.State = domainStates[state]
+
+		active := state != uint8(libvirt.DomainShutoff) && state != uint8(libvirt.DomainNostate)
+		switch {
+		case !active && !e.inactive:
+			status.Skipped = "inactive"
+		case !sc.filter.Match(status.Name, status.UUID):
+			status.Skipped = "excluded by name or UUID"
+		case e.maxDomains > 0 && collected >= e.maxDomains:
+			status.Skipped = "over the maximum number of domains"
+		default:
+			status.Skipped, err = e.matchXML(cli, domain, sc.filter)
+			if err != nil {
+				return nil, err
+			}
+
+			// like the collection, only the domains passing every
+			// filter count against the limit
+			if status.Skipped == "" {
+				collected++
+			}
+		}
+
+		result = append(result, status)
+	}
+
+	return result, nil
+}
+
+// matchXML tells why the domain is excluded by its title or metadata,
+// empty if it's not.
+func (e *Exporter) matchXML(cli *client, domain libvirt.Domain, filter DomainFilter) (string, error) {
+	xmlDesc, err := cli.DomainGetXMLDesc(domain, 0)
+	if err != nil {
+		return "", err
+	}
+
+	var schema Domain
+	if err = xml.Unmarshal([]byte(xmlDesc), &schema); err != nil {
+		return "", err
+	}
+
+	if !filter.MatchXML(&schema) {
+		return "excluded by title or metadata", nil
+	}
+
+	return "", nil
+}
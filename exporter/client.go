@@ -0,0 +1,557 @@
+package exporter
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/digitalocean/go-libvirt"
+	"github.com/pkg/errors"
+)
+
+// client is the libvirt connection of a collection, every RPC in use is
+// counted and timed by the api metrics of the exporter, so slow or
+// failing calls could be pinpointed.
+type client struct {
+	l Libvirt
+	e *Exporter
+
+	// the RPCs are traced as children of span
+	trace *trace
+	span  int
+}
+
+func (c *client) observe(call string, start time.Time, err *error) {
+	status := "success"
+	if *err != nil {
+		status = "error"
+	}
+
+	end := time.Now()
+	c.e.progressed()
+	c.e.apiCalls.WithLabelValues(call, status).Inc()
+	c.e.apiDuration.WithLabelValues(call).Observe(end.Sub(start).Seconds())
+	if c.trace != nil {
+		attrs := map[string]string{"rpc.system": "libvirt", "rpc.method": call}
+		c.trace.record(c.span, call, attrs, start, end, *err)
+	}
+}
+
+// rpcTimeoutError is returned by the RPCs not answered within the RPC
+// timeout of the exporter.
+type rpcTimeoutError struct {
+	call    string
+	timeout time.Duration
+}
+
+func (e *rpcTimeoutError) Error() string {
+	return fmt.Sprintf("%s timed out after %s", e.call, e.timeout)
+}
+
+func (e *rpcTimeoutError) Timeout() bool {
+	return true
+}
+
+// maxPendingRPCs is the number of RPCs given up but still pending, the
+// goroutines waiting for their answers, beyond which the RPCs fail at once.
+const maxPendingRPCs = 16
+
+// errTooManyPendingRPCs is returned by the RPCs while maxPendingRPCs RPCs
+// are given up but not answered yet.
+var errTooManyPendingRPCs = errors.New("too many RPCs timed out and still pending")
+
+// the states of an RPC run by call
+const (
+	rpcRunning int32 = iota
+	rpcAnswered
+	rpcAbandoned
+)
+
+// call runs the RPC fn, giving up once the RPC timeout is exceeded, so a
+// stuck qemu monitor of one domain won't hang the whole collection. go-libvirt
+// takes no context, fn goes on in the background until it's answered or
+// the connection is closed at the end of the collection, the results
+// it writes then must not be read. A connection of WithConnection is never
+// closed, so the RPCs given up are capped by maxPendingRPCs instead of
+// piling up on a stuck libvirtd.
+func (c *client) call(name string, fn func() error) error {
+	timeout := c.e.rpcTimeout
+	if timeout <= 0 {
+		return fn()
+	}
+
+	if atomic.LoadInt32(&c.e.pendingRPCs) >= maxPendingRPCs {
+		return errors.Wrap(errTooManyPendingRPCs, name)
+	}
+
+	state := rpcRunning
+	done := make(chan error, 1)
+	go func() {
+		done <- fn()
+		if !atomic.CompareAndSwapInt32(&state, rpcRunning, rpcAnswered) {
+			atomic.AddInt32(&c.e.pendingRPCs, -1)
+		}
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case err := <-done:
+		return err
+	case <-timer.C:
+		// counted before it's abandoned, so the goroutine never uncounts it first
+		atomic.AddInt32(&c.e.pendingRPCs, 1)
+		if !atomic.CompareAndSwapInt32(&state, rpcRunning, rpcAbandoned) {
+			// answered right at the timeout
+			atomic.AddInt32(&c.e.pendingRPCs, -1)
+			return <-done
+		}
+
+		return &rpcTimeoutError{call: name, timeout: timeout}
+	}
+}
+
+func (c *client) Connect() (err error) {
+	defer c.observe("Connect", time.Now(), &err)
+	return c.call("Connect", func() error {
+		return c.l.Connect()
+	})
+}
+
+func (c *client) ConnectListAllDomains(needResults int32, flags libvirt.ConnectListAllDomainsFlags) (rDomains []libvirt.Domain, rRet uint32, err error) {
+	defer c.observe("ConnectListAllDomains", time.Now(), &err)
+	var (
+		domains []libvirt.Domain
+		ret     uint32
+	)
+	err = c.call("ConnectListAllDomains", func() (err error) {
+		domains, ret, err = c.l.ConnectListAllDomains(needResults, flags)
+		return err
+	})
+	if err != nil {
+		return
+	}
+
+	return domains, ret, nil
+}
+
+func (c *client) DomainGetXMLDesc(dom libvirt.Domain, flags libvirt.DomainXMLFlags) (rXML string, err error) {
+	defer c.observe("DomainGetXMLDesc", time.Now(), &err)
+	var xml string
+	err = c.call("DomainGetXMLDesc", func() (err error) {
+		xml, err = c.l.DomainGetXMLDesc(dom, flags)
+		return err
+	})
+	if err != nil {
+		return
+	}
+
+	return xml, nil
+}
+
+func (c *client) DomainGetInfo(dom libvirt.Domain) (rState uint8, rMaxMem uint64, rMemory uint64, rNrVirtCPU uint16, rCPUTime uint64, err error) {
+	defer c.observe("DomainGetInfo", time.Now(), &err)
+	var (
+		state     uint8
+		maxMem    uint64
+		memory    uint64
+		nrVirtCPU uint16
+		cpuTime   uint64
+	)
+	err = c.call("DomainGetInfo", func() (err error) {
+		state, maxMem, memory, nrVirtCPU, cpuTime, err = c.l.DomainGetInfo(dom)
+		return err
+	})
+	if err != nil {
+		return
+	}
+
+	return state, maxMem, memory, nrVirtCPU, cpuTime, nil
+}
+
+func (c *client) DomainIsActive(dom libvirt.Domain) (rActive int32, err error) {
+	defer c.observe("DomainIsActive", time.Now(), &err)
+	var active int32
+	err = c.call("DomainIsActive", func() (err error) {
+		active, err = c.l.DomainIsActive(dom)
+		return err
+	})
+	if err != nil {
+		return
+	}
+
+	return active, nil
+}
+
+func (c *client) DomainMemoryStats(dom libvirt.Domain, maxStats uint32, flags uint32) (rStats []libvirt.DomainMemoryStat, err error) {
+	defer c.observe("DomainMemoryStats", time.Now(), &err)
+	var stats []libvirt.DomainMemoryStat
+	err = c.call("DomainMemoryStats", func() (err error) {
+		stats, err = c.l.DomainMemoryStats(dom, maxStats, flags)
+		return err
+	})
+	if err != nil {
+		return
+	}
+
+	return stats, nil
+}
+
+func (c *client) DomainBlockStats(dom libvirt.Domain, path string) (rRdReq int64, rRdBytes int64, rWrReq int64, rWrBytes int64, rErrs int64, err error) {
+	defer c.observe("DomainBlockStats", time.Now(), &err)
+	var (
+		rdReq   int64
+		rdBytes int64
+		wrReq   int64
+		wrBytes int64
+		errs    int64
+	)
+	err = c.call("DomainBlockStats", func() (err error) {
+		rdReq, rdBytes, wrReq, wrBytes, errs, err = c.l.DomainBlockStats(dom, path)
+		return err
+	})
+	if err != nil {
+		return
+	}
+
+	return rdReq, rdBytes, wrReq, wrBytes, errs, nil
+}
+
+func (c *client) DomainInterfaceStats(dom libvirt.Domain, device string) (rRxBytes int64, rRxPackets int64, rRxErrs int64, rRxDrop int64, rTxBytes int64, rTxPackets int64, rTxErrs int64, rTxDrop int64, err error) {
+	defer c.observe("DomainInterfaceStats", time.Now(), &err)
+	var (
+		rxBytes   int64
+		rxPackets int64
+		rxErrs    int64
+		rxDrop    int64
+		txBytes   int64
+		txPackets int64
+		txErrs    int64
+		txDrop    int64
+	)
+	err = c.call("DomainInterfaceStats", func() (err error) {
+		rxBytes, rxPackets, rxErrs, rxDrop, txBytes, txPackets, txErrs, txDrop, err = c.l.DomainInterfaceStats(dom, device)
+		return err
+	})
+	if err != nil {
+		return
+	}
+
+	return rxBytes, rxPackets, rxErrs, rxDrop, txBytes, txPackets, txErrs, txDrop, nil
+}
+
+func (c *client) QEMUDomainAgentCommand(dom libvirt.Domain, cmd string, timeout int32, flags uint32) (rResult libvirt.OptString, err error) {
+	defer c.observe("QEMUDomainAgentCommand", time.Now(), &err)
+	var result libvirt.OptString
+	err = c.call("QEMUDomainAgentCommand", func() (err error) {
+		result, err = c.l.QEMUDomainAgentCommand(dom, cmd, timeout, flags)
+		return err
+	})
+	if err != nil {
+		return
+	}
+
+	return result, nil
+}
+
+func (c *client) DomainStartDirtyRateCalc(dom libvirt.Domain, seconds int32, flags uint32) (err error) {
+	defer c.observe("DomainStartDirtyRateCalc", time.Now(), &err)
+	return c.call("DomainStartDirtyRateCalc", func() error {
+		return c.l.DomainStartDirtyRateCalc(dom, seconds, flags)
+	})
+}
+
+func (c *client) ConnectGetAllDomainStats(doms []libvirt.Domain, stats uint32, flags uint32) (rRetStats []libvirt.DomainStatsRecord, err error) {
+	defer c.observe("ConnectGetAllDomainStats", time.Now(), &err)
+	var retStats []libvirt.DomainStatsRecord
+	err = c.call("ConnectGetAllDomainStats", func() (err error) {
+		retStats, err = c.l.ConnectGetAllDomainStats(doms, stats, flags)
+		return err
+	})
+	if err != nil {
+		return
+	}
+
+	return retStats, nil
+}
+
+func (c *client) ConnectListAllStoragePools(needResults int32, flags libvirt.ConnectListAllStoragePoolsFlags) (rPools []libvirt.StoragePool, rRet uint32, err error) {
+	defer c.observe("ConnectListAllStoragePools", time.Now(), &err)
+	var (
+		pools []libvirt.StoragePool
+		ret   uint32
+	)
+	err = c.call("ConnectListAllStoragePools", func() (err error) {
+		pools, ret, err = c.l.ConnectListAllStoragePools(needResults, flags)
+		return err
+	})
+	if err != nil {
+		return
+	}
+
+	return pools, ret, nil
+}
+
+func (c *client) StoragePoolIsActive(pool libvirt.StoragePool) (rActive int32, err error) {
+	defer c.observe("StoragePoolIsActive", time.Now(), &err)
+	var active int32
+	err = c.call("StoragePoolIsActive", func() (err error) {
+		active, err = c.l.StoragePoolIsActive(pool)
+		return err
+	})
+	if err != nil {
+		return
+	}
+
+	return active, nil
+}
+
+func (c *client) StoragePoolGetXMLDesc(pool libvirt.StoragePool, flags libvirt.StorageXMLFlags) (rXML string, err error) {
+	defer c.observe("StoragePoolGetXMLDesc", time.Now(), &err)
+	var xml string
+	err = c.call("StoragePoolGetXMLDesc", func() (err error) {
+		xml, err = c.l.StoragePoolGetXMLDesc(pool, flags)
+		return err
+	})
+	if err != nil {
+		return
+	}
+
+	return xml, nil
+}
+
+func (c *client) StoragePoolListAllVolumes(pool libvirt.StoragePool, needResults int32, flags uint32) (rVols []libvirt.StorageVol, rRet uint32, err error) {
+	defer c.observe("StoragePoolListAllVolumes", time.Now(), &err)
+	var (
+		vols []libvirt.StorageVol
+		ret  uint32
+	)
+	err = c.call("StoragePoolListAllVolumes", func() (err error) {
+		vols, ret, err = c.l.StoragePoolListAllVolumes(pool, needResults, flags)
+		return err
+	})
+	if err != nil {
+		return
+	}
+
+	return vols, ret, nil
+}
+
+func (c *client) StorageVolGetInfo(vol libvirt.StorageVol) (rType int8, rCapacity uint64, rAllocation uint64, err error) {
+	defer c.observe("StorageVolGetInfo", time.Now(), &err)
+	var (
+		typ        int8
+		capacity   uint64
+		allocation uint64
+	)
+	err = c.call("StorageVolGetInfo", func() (err error) {
+		typ, capacity, allocation, err = c.l.StorageVolGetInfo(vol)
+		return err
+	})
+	if err != nil {
+		return
+	}
+
+	return typ, capacity, allocation, nil
+}
+
+func (c *client) StorageVolGetPath(vol libvirt.StorageVol) (rName string, err error) {
+	defer c.observe("StorageVolGetPath", time.Now(), &err)
+	var name string
+	err = c.call("StorageVolGetPath", func() (err error) {
+		name, err = c.l.StorageVolGetPath(vol)
+		return err
+	})
+	if err != nil {
+		return
+	}
+
+	return name, nil
+}
+
+func (c *client) ConnectListAllNetworks(needResults int32, flags libvirt.ConnectListAllNetworksFlags) (rNets []libvirt.Network, rRet uint32, err error) {
+	defer c.observe("ConnectListAllNetworks", time.Now(), &err)
+	var (
+		nets []libvirt.Network
+		ret  uint32
+	)
+	err = c.call("ConnectListAllNetworks", func() (err error) {
+		nets, ret, err = c.l.ConnectListAllNetworks(needResults, flags)
+		return err
+	})
+	if err != nil {
+		return
+	}
+
+	return nets, ret, nil
+}
+
+func (c *client) NetworkIsActive(net libvirt.Network) (rActive int32, err error) {
+	defer c.observe("NetworkIsActive", time.Now(), &err)
+	var active int32
+	err = c.call("NetworkIsActive", func() (err error) {
+		active, err = c.l.NetworkIsActive(net)
+		return err
+	})
+	if err != nil {
+		return
+	}
+
+	return active, nil
+}
+
+func (c *client) NetworkIsPersistent(net libvirt.Network) (rPersistent int32, err error) {
+	defer c.observe("NetworkIsPersistent", time.Now(), &err)
+	var persistent int32
+	err = c.call("NetworkIsPersistent", func() (err error) {
+		persistent, err = c.l.NetworkIsPersistent(net)
+		return err
+	})
+	if err != nil {
+		return
+	}
+
+	return persistent, nil
+}
+
+func (c *client) NetworkGetAutostart(net libvirt.Network) (rAutostart int32, err error) {
+	defer c.observe("NetworkGetAutostart", time.Now(), &err)
+	var autostart int32
+	err = c.call("NetworkGetAutostart", func() (err error) {
+		autostart, err = c.l.NetworkGetAutostart(net)
+		return err
+	})
+	if err != nil {
+		return
+	}
+
+	return autostart, nil
+}
+
+func (c *client) NetworkGetXMLDesc(net libvirt.Network, flags uint32) (rXML string, err error) {
+	defer c.observe("NetworkGetXMLDesc", time.Now(), &err)
+	var xml string
+	err = c.call("NetworkGetXMLDesc", func() (err error) {
+		xml, err = c.l.NetworkGetXMLDesc(net, flags)
+		return err
+	})
+	if err != nil {
+		return
+	}
+
+	return xml, nil
+}
+
+func (c *client) NetworkGetDhcpLeases(net libvirt.Network, mac libvirt.OptString, needResults int32, flags uint32) (rLeases []libvirt.NetworkDhcpLease, rRet uint32, err error) {
+	defer c.observe("NetworkGetDhcpLeases", time.Now(), &err)
+	var (
+		leases []libvirt.NetworkDhcpLease
+		ret    uint32
+	)
+	err = c.call("NetworkGetDhcpLeases", func() (err error) {
+		leases, ret, err = c.l.NetworkGetDhcpLeases(net, mac, needResults, flags)
+		return err
+	})
+	if err != nil {
+		return
+	}
+
+	return leases, ret, nil
+}
+
+func (c *client) ConnectListAllInterfaces(needResults int32, flags libvirt.ConnectListAllInterfacesFlags) (rIfaces []libvirt.Interface, rRet uint32, err error) {
+	defer c.observe("ConnectListAllInterfaces", time.Now(), &err)
+	var (
+		ifaces []libvirt.Interface
+		ret    uint32
+	)
+	err = c.call("ConnectListAllInterfaces", func() (err error) {
+		ifaces, ret, err = c.l.ConnectListAllInterfaces(needResults, flags)
+		return err
+	})
+	if err != nil {
+		return
+	}
+
+	return ifaces, ret, nil
+}
+
+func (c *client) InterfaceIsActive(iface libvirt.Interface) (rActive int32, err error) {
+	defer c.observe("InterfaceIsActive", time.Now(), &err)
+	var active int32
+	err = c.call("InterfaceIsActive", func() (err error) {
+		active, err = c.l.InterfaceIsActive(iface)
+		return err
+	})
+	if err != nil {
+		return
+	}
+
+	return active, nil
+}
+
+func (c *client) ConnectListAllNodeDevices(needResults int32, flags uint32) (rDevices []libvirt.NodeDevice, rRet uint32, err error) {
+	defer c.observe("ConnectListAllNodeDevices", time.Now(), &err)
+	var (
+		devices []libvirt.NodeDevice
+		ret     uint32
+	)
+	err = c.call("ConnectListAllNodeDevices", func() (err error) {
+		devices, ret, err = c.l.ConnectListAllNodeDevices(needResults, flags)
+		return err
+	})
+	if err != nil {
+		return
+	}
+
+	return devices, ret, nil
+}
+
+func (c *client) NodeDeviceGetXMLDesc(name string, flags uint32) (rXML string, err error) {
+	defer c.observe("NodeDeviceGetXMLDesc", time.Now(), &err)
+	var xml string
+	err = c.call("NodeDeviceGetXMLDesc", func() (err error) {
+		xml, err = c.l.NodeDeviceGetXMLDesc(name, flags)
+		return err
+	})
+	if err != nil {
+		return
+	}
+
+	return xml, nil
+}
+
+func (c *client) ConnectListAllSecrets(needResults int32, flags libvirt.ConnectListAllSecretsFlags) (rSecrets []libvirt.Secret, rRet uint32, err error) {
+	defer c.observe("ConnectListAllSecrets", time.Now(), &err)
+	var (
+		secrets []libvirt.Secret
+		ret     uint32
+	)
+	err = c.call("ConnectListAllSecrets", func() (err error) {
+		secrets, ret, err = c.l.ConnectListAllSecrets(needResults, flags)
+		return err
+	})
+	if err != nil {
+		return
+	}
+
+	return secrets, ret, nil
+}
+
+func (c *client) ConnectListAllNwfilters(needResults int32, flags uint32) (rFilters []libvirt.Nwfilter, rRet uint32, err error) {
+	defer c.observe("ConnectListAllNwfilters", time.Now(), &err)
+	var (
+		filters []libvirt.Nwfilter
+		ret     uint32
+	)
+	err = c.call("ConnectListAllNwfilters", func() (err error) {
+		filters, ret, err = c.l.ConnectListAllNwfilters(needResults, flags)
+		return err
+	})
+	if err != nil {
+		return
+	}
+
+	return filters, ret, nil
+}
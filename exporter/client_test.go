@@ -0,0 +1,133 @@
+package exporter_test
+
+import (
+	"bytes"
+	"log"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/f1shl3gs/libvirt_exporter/exporter"
+)
+
+func TestRPCTimeout(t *testing.T) {
+	f := newFake()
+	block := make(chan struct{})
+	var unblock sync.Once
+	defer unblock.Do(func() { close(block) })
+	f.Blocks = map[string]chan struct{}{"DomainGetInfo": block}
+
+	var logs bytes.Buffer
+	e := exporter.NewExporter("test:///default",
+		exporter.WithLibvirt(func() (exporter.Libvirt, error) {
+			return f, nil
+		}),
+		exporter.WithRPCTimeout(10*time.Millisecond),
+		exporter.WithLogger(log.New(&logs, "", 0)))
+
+	reg := prometheus.NewPedanticRegistry()
+	if err := reg.Register(e); err != nil {
+		t.Fatalf("register exporter failed, %s", err)
+	}
+
+	// every collection gives up the stuck DomainGetInfo, and leaves it
+	// pending, until 16 of them are pending
+	for i := 0; i < 16; i++ {
+		start := time.Now()
+		if _, err := reg.Gather(); err != nil {
+			t.Fatalf("gather failed, %s", err)
+		}
+		if took := time.Since(start); took > time.Second {
+			t.Fatalf("gather took %s with the RPC timeout of 10ms", took)
+		}
+
+		err := e.LastScrape().Failed["domain"]
+		if err == nil || !strings.Contains(err.Error(), "DomainGetInfo timed out after 10ms") {
+			t.Fatalf("collection %d failed with %v, want DomainGetInfo timed out", i, err)
+		}
+	}
+
+	// the RPCs fail at once instead of leaving another one pending
+	if _, err := reg.Gather(); err != nil {
+		t.Fatalf("gather failed, %s", err)
+	}
+
+	err := e.LastScrape().Err
+	if err == nil || !strings.Contains(err.Error(), "too many RPCs timed out and still pending") {
+		t.Errorf("collection failed with %v, want too many RPCs pending", err)
+	}
+	if n := f.Calls("DomainGetInfo"); n != 16 {
+		t.Errorf("DomainGetInfo called %d times, want 16", n)
+	}
+	if n := f.Calls("Connect"); n != 16 {
+		t.Errorf("Connect called %d times, want 16", n)
+	}
+
+	// the pending RPCs release their slots once they return, so the
+	// collections succeed again
+	unblock.Do(func() { close(block) })
+	deadline := time.Now().Add(time.Second)
+	for {
+		if _, err := reg.Gather(); err != nil {
+			t.Fatalf("gather failed, %s", err)
+		}
+
+		last := e.LastScrape()
+		if last.Err == nil && last.Failed["domain"] == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("collection still failed with %v %v after the RPCs returned", last.Err, last.Failed)
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestStalled(t *testing.T) {
+	f := newFake()
+	block := make(chan struct{})
+	var unblock sync.Once
+	defer unblock.Do(func() { close(block) })
+	f.Blocks = map[string]chan struct{}{"DomainGetInfo": block}
+
+	e := exporter.NewExporter("test:///default",
+		exporter.WithLibvirt(func() (exporter.Libvirt, error) {
+			return f, nil
+		}),
+		exporter.WithRPCTimeout(0))
+
+	if e.Stalled(0) {
+		t.Fatal("stalled before collecting")
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ch := make(chan prometheus.Metric)
+		go func() {
+			for range ch {
+			}
+		}()
+		e.Collect(ch)
+		close(ch)
+	}()
+
+	// the collection hangs in DomainGetInfo, with no RPC answered since
+	deadline := time.Now().Add(time.Second)
+	for !e.Stalled(20 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("collection stuck in DomainGetInfo not stalled")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	unblock.Do(func() { close(block) })
+	<-done
+	if e.Stalled(0) {
+		t.Error("stalled after the collection finished")
+	}
+}
@@ -0,0 +1,118 @@
+package exporter
+
+import (
+	"github.com/digitalocean/go-libvirt"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// cluster describes the series aggregated over all hypervisors collected
+// by MultiExporter, so capacity views don't need heavy PromQL.
+type cluster struct {
+	hosts           *prometheus.Desc
+	hostsUp         *prometheus.Desc
+	runningDomains  *prometheus.Desc
+	allocatedVCPUs  *prometheus.Desc
+	allocatedMemory *prometheus.Desc
+}
+
+func newCluster(naming NamingStrategy, namespace string, constLabels prometheus.Labels) *cluster {
+	return &cluster{
+		hosts: prometheus.NewDesc(
+			naming.FQName(namespace, "cluster", "hosts"),
+			"Number of hypervisors collected.",
+			nil,
+			constLabels),
+		hostsUp: prometheus.NewDesc(
+			naming.FQName(namespace, "cluster", "hosts_up"),
+			"Number of hypervisors collected successfully.",
+			nil,
+			constLabels),
+		runningDomains: prometheus.NewDesc(
+			naming.FQName(namespace, "cluster", "running_domains"),
+			"Number of running domains of all hypervisors.",
+			nil,
+			constLabels),
+		allocatedVCPUs: prometheus.NewDesc(
+			naming.FQName(namespace, "cluster", "allocated_vcpus"),
+			"Number of virtual CPUs of the running domains of all hypervisors.",
+			nil,
+			constLabels),
+		allocatedMemory: prometheus.NewDesc(
+			naming.FQName(namespace, "cluster", "allocated_memory_bytes"),
+			"Memory of the running domains of all hypervisors, in bytes.",
+			nil,
+			constLabels),
+	}
+}
+
+func (c *cluster) describe(ch chan<- *prometheus.Desc) {
+	ch <- c.hosts
+	ch <- c.hostsUp
+	ch <- c.runningDomains
+	ch <- c.allocatedVCPUs
+	ch <- c.allocatedMemory
+}
+
+// clusterStats sums the collections of the hypervisors
+type clusterStats struct {
+	hosts   float64
+	hostsUp float64
+	running float64
+	vcpus   float64
+	memory  float64
+}
+
+// domainTally sums the running domains of the collection of a hypervisor
+// as it reads them, rather than from its metrics, whose labels extra
+// labels or relabeling could change.
+type domainTally struct {
+	up      bool
+	running float64
+	vcpus   float64
+	memory  float64
+}
+
+// add counts the domain if it's running.
+func (t *domainTally) add(d *domainContext) {
+	if d.state != uint8(libvirt.DomainRunning) {
+		return
+	}
+
+	t.running++
+	t.vcpus += float64(d.vcpu)
+	t.memory += float64(d.mem) * 1024
+}
+
+// observe adds the collection of the hypervisor.
+func (cs *clusterStats) observe(t *domainTally) {
+	if t.up {
+		cs.hostsUp++
+	}
+
+	cs.running += t.running
+	cs.vcpus += t.vcpus
+	cs.memory += t.memory
+}
+
+func (c *cluster) collect(ch chan<- prometheus.Metric, cs *clusterStats) {
+	ch <- prometheus.MustNewConstMetric(
+		c.hosts,
+		prometheus.GaugeValue,
+		cs.hosts)
+	ch <- prometheus.MustNewConstMetric(
+		c.hostsUp,
+		prometheus.GaugeValue,
+		cs.hostsUp)
+	ch <- prometheus.MustNewConstMetric(
+		c.runningDomains,
+		prometheus.GaugeValue,
+		cs.running)
+	ch <- prometheus.MustNewConstMetric(
+		c.allocatedVCPUs,
+		prometheus.GaugeValue,
+		cs.vcpus)
+	ch <- prometheus.MustNewConstMetric(
+		c.allocatedMemory,
+		prometheus.GaugeValue,
+		cs.memory)
+}
@@ -0,0 +1,110 @@
+package exporter
+
+import (
+	"github.com/digitalocean/go-libvirt"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// collector is a metric group which could be turned on or off by its
+// name in Collectors. Besides describing its metrics, it implements
+// nodeCollector, domainCollector or both.
+type collector interface {
+	Describe(ch chan<- *prometheus.Desc)
+}
+
+// nodeCollector collects the metrics of the hypervisor once per scrape,
+// e.g. storage pools.
+type nodeCollector interface {
+	Collect(ch chan<- prometheus.Metric, cli *client) error
+}
+
+// domainCollector collects the metrics of every collected domain.
+type domainCollector interface {
+	CollectDomain(ch chan<- prometheus.Metric, cli *client, d *domainContext) error
+}
+
+// domainContext is the domain being collected, it's shared by the
+// domain collectors.
+type domainContext struct {
+	domain libvirt.Domain
+	name   string
+	uuid   string
+	schema *Domain
+
+	// from DomainGetInfo, memory in KiB and CPU time in nanoseconds
+	state   uint8
+	maxMem  uint64
+	mem     uint64
+	vcpu    uint16
+	cputime uint64
+
+	// reported by the guest agent, empty if unknown
+	hostname string
+
+	// nanoseconds the guest agent commands of the collection could still
+	// take, nil means no limit
+	agentLeft *int64
+
+	// DomainIsActive, asked once the first collector needs it
+	active *bool
+
+	// bulk stats of the collection, nil unless a collector needs them
+	bulk *bulkStats
+}
+
+// isActive reports whether the domain is running, DomainIsActive is
+// called once per domain instead of once per device.
+func (d *domainContext) isActive(cli *client) (bool, error) {
+	if d.active != nil {
+		return *d.active, nil
+	}
+
+	active, err := cli.DomainIsActive(d.domain)
+	if err != nil {
+		return false, err
+	}
+
+	d.active = new(bool)
+	*d.active = active == 1
+	return *d.active, nil
+}
+
+var (
+	factories = make(map[string]func(e *Exporter) collector)
+
+	// names of the collectors in the order of registration, which is
+	// the order of collection
+	collectorNames []string
+)
+
+// registerCollector adds a metric group to Collectors, factory creates
+// the collector of every Exporter.
+func registerCollector(name string, enabled bool, factory func(e *Exporter) collector) {
+	if _, ok := factories[name]; ok {
+		panic("collector " + name + " registered twice")
+	}
+
+	Collectors[name] = enabled
+	factories[name] = factory
+	collectorNames = append(collectorNames, name)
+}
+
+func init() {
+	registerCollector("domain", true, func(e *Exporter) collector { return domainInfoCollector{e} })
+	registerCollector("memory", true, func(e *Exporter) collector { return memoryCollector{e} })
+	registerCollector("block", true, func(e *Exporter) collector { return blockCollector{e} })
+	registerCollector("interface", true, func(e *Exporter) collector { return interfaceCollector{e} })
+	registerCollector("guest-agent", false, func(e *Exporter) collector { return guestAgentCollector{e} })
+	registerCollector("perf", false, func(e *Exporter) collector { return perfCollector{e} })
+	registerCollector("resctrl", false, func(e *Exporter) collector { return resctrlCollector{e} })
+	registerCollector("dirty-rate", false, func(e *Exporter) collector { return dirtyRateCollector{e} })
+	registerCollector("launch-security", true, func(e *Exporter) collector { return launchSecurityCollector{e} })
+	registerCollector("tpm", true, func(e *Exporter) collector { return tpmCollector{e} })
+	registerCollector("hostdev", true, func(e *Exporter) collector { return hostdevCollector{e} })
+	registerCollector("storage", true, func(e *Exporter) collector { return storageCollector{e} })
+	registerCollector("network", true, func(e *Exporter) collector { return networkCollector{e} })
+	registerCollector("host-interface", true, func(e *Exporter) collector { return hostInterfaceCollector{e} })
+	registerCollector("nodedev", true, func(e *Exporter) collector { return nodeDeviceCollector{e} })
+	registerCollector("secret", true, func(e *Exporter) collector { return secretCollector{e} })
+	registerCollector("nwfilter", true, func(e *Exporter) collector { return nwfilterCollector{e} })
+}
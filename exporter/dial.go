@@ -0,0 +1,183 @@
+package exporter
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultSocket  = "/var/run/libvirt/libvirt-sock"
+	defaultTCPPort = "16509"
+	defaultTLSPort = "16514"
+
+	// where libvirt looks for the client certificates by default
+	defaultPKIPath = "/etc/pki/libvirt"
+	defaultCACert  = "/etc/pki/CA/cacert.pem"
+)
+
+// sockets of libvirtd and the modular virtqemud, read-write ones first,
+// distributions put them under /var/run or /run
+var sockets = []string{
+	"/var/run/libvirt/libvirt-sock",
+	"/run/libvirt/libvirt-sock",
+	"/var/run/libvirt/virtqemud-sock",
+	"/run/libvirt/virtqemud-sock",
+	"/var/run/libvirt/libvirt-sock-ro",
+	"/run/libvirt/libvirt-sock-ro",
+	"/var/run/libvirt/virtqemud-sock-ro",
+	"/run/libvirt/virtqemud-sock-ro",
+}
+
+// DetectSocket returns the first of the standard libvirt sockets which
+// exists, false if none does.
+func DetectSocket() (string, bool) {
+	for _, path := range sockets {
+		info, err := os.Stat(path)
+		if err == nil && info.Mode()&os.ModeSocket != 0 {
+			return path, true
+		}
+	}
+
+	return "", false
+}
+
+// dial connects to the libvirt daemon of the uri, which is either the
+// path of the unix socket, or a libvirt URI with the unix, tcp or tls
+// transport, e.g. qemu:///system, qemu+tcp://hv01/system and
+// qemu+tls://hv01/system. The pkipath, no_verify and socket parameters
+// are honored like libvirt does.
+func dial(uri string, timeout time.Duration) (net.Conn, error) {
+	if !strings.Contains(uri, "://") {
+		return net.DialTimeout("unix", uri, timeout)
+	}
+
+	u, transport, err := parseURI(uri)
+	if err != nil {
+		return nil, err
+	}
+
+	params := u.Query()
+	switch transport {
+	case "unix":
+		socket := params.Get("socket")
+		if socket == "" {
+			socket = defaultSocket
+			if detected, ok := DetectSocket(); ok {
+				socket = detected
+			}
+		}
+
+		return net.DialTimeout("unix", socket, timeout)
+
+	case "tcp":
+		return net.DialTimeout("tcp", hostPort(u, defaultTCPPort), timeout)
+
+	case "tls":
+		cfg, err := clientTLSConfig(u.Hostname(), params.Get("pkipath"), params.Get("no_verify") == "1")
+		if err != nil {
+			return nil, err
+		}
+
+		dialer := &net.Dialer{Timeout: timeout}
+		return tls.DialWithDialer(dialer, "tcp", hostPort(u, defaultTLSPort), cfg)
+
+	default:
+		return nil, errors.Errorf("unsupported transport %q of %s", transport, uri)
+	}
+}
+
+// parseURI returns the parsed libvirt URI and its transport.
+func parseURI(uri string) (*url.URL, string, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, "", errors.Wrap(err, "invalid libvirt uri")
+	}
+
+	transport := "unix"
+	if i := strings.Index(u.Scheme, "+"); i >= 0 {
+		transport = u.Scheme[i+1:]
+	} else if u.Host != "" {
+		transport = "tls"
+	}
+
+	return u, transport, nil
+}
+
+// ValidateURI checks the uri could be dialed without dialing it, i.e.
+// its transport is supported and the certificates of tls are readable.
+func ValidateURI(uri string) error {
+	if !strings.Contains(uri, "://") {
+		return nil
+	}
+
+	u, transport, err := parseURI(uri)
+	if err != nil {
+		return err
+	}
+
+	switch transport {
+	case "unix", "tcp":
+		return nil
+	case "tls":
+		params := u.Query()
+		_, err = clientTLSConfig(u.Hostname(), params.Get("pkipath"), params.Get("no_verify") == "1")
+		return err
+	default:
+		return errors.Errorf("unsupported transport %q of %s", transport, uri)
+	}
+}
+
+func hostPort(u *url.URL, defaultPort string) string {
+	port := u.Port()
+	if port == "" {
+		port = defaultPort
+	}
+
+	return net.JoinHostPort(u.Hostname(), port)
+}
+
+// clientTLSConfig loads the client certificate and the CA from the same
+// places as libvirt, see https://libvirt.org/kbase/tlscerts.html
+func clientTLSConfig(host, pkiPath string, noVerify bool) (*tls.Config, error) {
+	caFile := defaultCACert
+	certFile := filepath.Join(defaultPKIPath, "clientcert.pem")
+	keyFile := filepath.Join(defaultPKIPath, "private", "clientkey.pem")
+	if pkiPath != "" {
+		caFile = filepath.Join(pkiPath, "cacert.pem")
+		certFile = filepath.Join(pkiPath, "clientcert.pem")
+		keyFile = filepath.Join(pkiPath, "clientkey.pem")
+	}
+
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, errors.Wrap(err, "load client certificate failed")
+	}
+
+	cfg := &tls.Config{
+		ServerName:         host,
+		Certificates:       []tls.Certificate{cert},
+		InsecureSkipVerify: noVerify,
+	}
+
+	if !noVerify {
+		data, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "read CA certificate failed")
+		}
+
+		cfg.RootCAs = x509.NewCertPool()
+		if !cfg.RootCAs.AppendCertsFromPEM(data) {
+			return nil, errors.Errorf("no certificate found in %s", caFile)
+		}
+	}
+
+	return cfg, nil
+}
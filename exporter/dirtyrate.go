@@ -0,0 +1,62 @@
+package exporter
+
+import (
+	"time"
+
+	"github.com/digitalocean/go-libvirt"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// dirtyRateCollector reports the rate domains dirty their memory, which
+// tells whether and how fast they could be live migrated. Every
+// collection reads the rate measured since the previous one from the
+// dirtyrate group of the bulk stats, and starts measuring again by
+// virDomainStartDirtyRateCalc. It needs libvirt 7.2 and qemu 5.2 or
+// later.
+type dirtyRateCollector struct{ e *Exporter }
+
+func (c dirtyRateCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.e.dirtyRate
+}
+
+func (c dirtyRateCollector) CollectDomain(ch chan<- prometheus.Metric, cli *client, d *domainContext) error {
+	return errors.Wrap(c.e.collectDirtyRate(ch, cli, d), "failed to collect dirty rate")
+}
+
+func (e *Exporter) collectDirtyRate(ch chan<- prometheus.Metric, cli *client, d *domainContext) error {
+	params, err := d.bulkStats()
+	if err != nil {
+		return err
+	}
+
+	// the rate is in MiB/s, despite the name
+	status := libvirt.DomainDirtyRateStatus(params.count("dirtyrate.calc_status"))
+	if status == libvirt.DomainDirtyrateMeasured {
+		if rate, ok := params.float("dirtyrate.megabytes_per_second"); ok {
+			ch <- e.constMetric(
+				e.dirtyRate,
+				prometheus.GaugeValue,
+				rate*1024*1024,
+				d.name, d.uuid)
+		}
+	}
+
+	// still measuring, e.g. the calc time is longer than the interval,
+	// restarting would throw the measurement away
+	if status == libvirt.DomainDirtyrateMeasuring {
+		return nil
+	}
+
+	// qemu takes the calc time in whole seconds
+	seconds := int32(e.dirtyRateCalc / time.Second)
+	if seconds < 1 {
+		seconds = 1
+	}
+
+	if err = cli.DomainStartDirtyRateCalc(d.domain, seconds, 0); err != nil {
+		return errors.Wrap(err, "failed to start dirty rate calculation")
+	}
+
+	return nil
+}
@@ -0,0 +1,99 @@
+package exporter_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/digitalocean/go-libvirt"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/f1shl3gs/libvirt_exporter/exporter"
+)
+
+func TestDirtyRate(t *testing.T) {
+	tests := []struct {
+		name     string
+		stats    []libvirt.TypedParam
+		expected string
+		started  int
+	}{
+		{
+			name: "unstarted",
+			stats: []libvirt.TypedParam{
+				typedParam("dirtyrate.calc_status", int32(libvirt.DomainDirtyrateUnstarted)),
+			},
+			started: 1,
+		},
+		{
+			name: "measuring",
+			stats: []libvirt.TypedParam{
+				typedParam("dirtyrate.calc_status", int32(libvirt.DomainDirtyrateMeasuring)),
+			},
+		},
+		{
+			name: "measured",
+			stats: []libvirt.TypedParam{
+				typedParam("dirtyrate.calc_status", int32(libvirt.DomainDirtyrateMeasured)),
+				typedParam("dirtyrate.calc_start_time", int64(1792121215)),
+				typedParam("dirtyrate.calc_period", int32(1)),
+				typedParam("dirtyrate.megabytes_per_second", int64(12)),
+			},
+			expected: `
+# HELP libvirt_domain_memory_dirty_rate_bytes_per_second Rate the domain dirtied its memory at, measured after the previous collection, in bytes per second.
+# TYPE libvirt_domain_memory_dirty_rate_bytes_per_second gauge
+libvirt_domain_memory_dirty_rate_bytes_per_second{domain="web",uuid="6a5d2c3e-8d3b-4b8e-9f4a-2b1c0d9e8f7a"} 1.2582912e+07
+`,
+			started: 1,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f := newFake()
+			f.Domains[0].Stats = test.stats
+
+			reg := newExporter(t, f, exporter.WithDirtyRate(time.Second))
+
+			err := testutil.GatherAndCompare(reg, strings.NewReader(test.expected),
+				"libvirt_domain_memory_dirty_rate_bytes_per_second")
+			if err != nil {
+				t.Error(err)
+			}
+			if n := f.Calls("DomainStartDirtyRateCalc"); n != test.started {
+				t.Errorf("DomainStartDirtyRateCalc called %d times, want %d", n, test.started)
+			}
+		})
+	}
+}
+
+func TestDirtyRateCalls(t *testing.T) {
+	// web is still measuring, web1 and web2 are measured
+	f := newFake()
+	f.Domains[0].Stats = []libvirt.TypedParam{
+		typedParam("dirtyrate.calc_status", int32(libvirt.DomainDirtyrateMeasuring)),
+	}
+	for i := 1; i <= 2; i++ {
+		d := f.Domains[0]
+		d.Name = fmt.Sprintf("web%d", i)
+		d.UUID[15] = byte(i)
+		d.Stats = []libvirt.TypedParam{
+			typedParam("dirtyrate.calc_status", int32(libvirt.DomainDirtyrateMeasured)),
+			typedParam("dirtyrate.megabytes_per_second", int64(i)),
+		}
+		f.Domains = append(f.Domains, d)
+	}
+
+	reg := newExporter(t, f, exporter.WithDirtyRate(time.Second))
+	if _, err := reg.Gather(); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := f.Calls("ConnectGetAllDomainStats"); n != 1 {
+		t.Errorf("ConnectGetAllDomainStats called %d times, want 1", n)
+	}
+	if n := f.Calls("DomainStartDirtyRateCalc"); n != 2 {
+		t.Errorf("DomainStartDirtyRateCalc called %d times, want 2", n)
+	}
+}
@@ -1,10 +1,13 @@
 package exporter
 
 import (
+	"context"
 	"encoding/hex"
 	"encoding/xml"
-	"log"
-	"net"
+	"regexp"
+	"sort"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/digitalocean/go-libvirt"
@@ -24,19 +27,121 @@ var (
 		"pmsuspended",
 		"last",
 	}
+
+	// Collectors lists the metric groups could be turned on or off,
+	// and whether they are enabled by default, see registerCollector.
+	Collectors = make(map[string]bool)
 )
 
 type Exporter struct {
 	uri       string
 	namespace string
 
+	// deadline of a collection, 0 means no deadline
+	timeout time.Duration
+
+	// every RPC is given up after it, 0 means no timeout
+	rpcTimeout time.Duration
+
+	// RPCs given up but not answered yet, they are not released before the
+	// connection is closed, and never for a connection of WithConnection
+	pendingRPCs int32
+
+	// collections in flight, and the time in unix nanoseconds any of them
+	// last made progress, i.e. started, finished or got an RPC answered
+	inFlight int32
+	progress int64
+
+	// collections taking longer are logged, 0 means never
+	slowThreshold time.Duration
+
+	// labels attached to every metric, e.g. host
+	constLabels prometheus.Labels
+
+	// filter could be replaced while collecting, e.g. config reload
+	mu     sync.RWMutex
+	filter DomainFilter
+
+	collectors map[string]bool
+
+	// defined but not running domains
+	inactive bool
+
+	// report zeros for the devices of inactive domains instead of nothing
+	inactiveDevices bool
+
+	// maximum domains collected per scrape, 0 means unlimited
+	maxDomains int
+
+	// storage volumes are opt-in, hosts with big pools would
+	// produce a lot of series
+	volumes      bool
+	volumesLimit int
+
+	leaseInfo  bool
+	deviceInfo bool
+
+	agentTimeout  time.Duration
+	agentInFlight chan struct{}
+
+	// time the guest agent commands of a collection could take, 0 means
+	// no limit, see WithGuestAgentBudget
+	agentBudget time.Duration
+
+	// time the dirty rate is measured for, see WithDirtyRate
+	dirtyRateCalc time.Duration
+
+	// instrumentation of the libvirt RPCs
+	apiCalls    *prometheus.CounterVec
+	apiDuration *prometheus.HistogramVec
+	failures    *prometheus.CounterVec
+	errTotal    prometheus.Counter
+	sanitized   prometheus.Counter
+	spans       SpanExporter
+
+	// logs the failures, see WithLogger
+	logger Logger
+
+	// replaces dialing uri if set, see WithLibvirt
+	connect func() (Libvirt, error)
+
+	// builds the names of the metrics, see WithNaming
+	naming NamingStrategy
+
+	// extra labels of domains, see WithLabelFunc
+	labelFunc func(domain DomainMeta) prometheus.Labels
+
+	// a collector of every registered metric group, enabled or not
+	instances map[string]collector
+
+	// names of the collectors the hypervisor has no driver for, they
+	// are logged once instead of failing every scrape
+	unsupported sync.Map
+
+	// what the XML of the node devices tells, by device name, see
+	// nodeDevice
+	nodeDevs sync.Map
+
+	// metadata of the descs, see Metrics
+	metrics map[*prometheus.Desc]MetricInfo
+
+	// result of the last collection, guarded by mu
+	lastScrape  ScrapeResult
+	lastSuccess time.Time
+
 	// misc
 	up            *prometheus.Desc
 	domains       *prometheus.Desc
+	matched       *prometheus.Desc
+	skipped       *prometheus.Desc
 	scrapeError   *prometheus.Desc
 	scrapeLatency *prometheus.Desc
+	success       *prometheus.Desc
+	domainTook    *prometheus.Desc
+	lastSuccessTs *prometheus.Desc
 
 	// instance
+	info    *prometheus.Desc
 	state   *prometheus.Desc
 	maxMem  *prometheus.Desc
 	mem     *prometheus.Desc
@@ -61,105 +166,649 @@ type Exporter struct {
 	ifaceTransmitPackets *prometheus.Desc
 	ifaceTransmitErrors  *prometheus.Desc
 	ifaceTransmitDrops   *prometheus.Desc
+
+	// storage
+	pools            *prometheus.Desc
+	volumeCapacity   *prometheus.Desc
+	volumeAllocation *prometheus.Desc
+
+	// network
+	networkActive     *prometheus.Desc
+	networkPersistent *prometheus.Desc
+	networkAutostart  *prometheus.Desc
+	networkInfo       *prometheus.Desc
+	dhcpLeases        *prometheus.Desc
+	dhcpLeaseInfo     *prometheus.Desc
+
+	// host interfaces
+	hostIfaceActive *prometheus.Desc
+
+	// node devices
+	nodeDevices *prometheus.Desc
+	nodeDevInfo *prometheus.Desc
+
+	// secrets
+	secrets *prometheus.Desc
+
+	// nwfilters
+	nwfilters    *prometheus.Desc
+	nwfilterInfo *prometheus.Desc
+
+	// guest agent
+	guestAgentUp    *prometheus.Desc
+	guestOSInfo     *prometheus.Desc
+	guestFsSize     *prometheus.Desc
+	guestFsUsed     *prometheus.Desc
+	guestClockDrift *prometheus.Desc
+	guestUsers      *prometheus.Desc
+	guestFrozen     *prometheus.Desc
+	guestDiskInfo   *prometheus.Desc
+	guestVCPUs      *prometheus.Desc
+
+	// perf events, in the order of perfEvents
+	perf []*prometheus.Desc
+
+	// resctrl monitors
+	cacheOccupancy    *prometheus.Desc
+	memBandwidth      *prometheus.Desc
+	memBandwidthLocal *prometheus.Desc
+
+	// dirty rate
+	dirtyRate *prometheus.Desc
+
+	// launch security
+	launchSecurity *prometheus.Desc
+	sevPolicy      *prometheus.Desc
+
+	// tpm
+	tpms    *prometheus.Desc
+	tpmInfo *prometheus.Desc
+
+	// hostdev
+	hostdevs    *prometheus.Desc
+	hostdevInfo *prometheus.Desc
 }
 
 func (e *Exporter) Describe(ch chan<- *prometheus.Desc) {
+	e.describe(ch, e.scope())
+}
+
+func (e *Exporter) describe(ch chan<- *prometheus.Desc, sc scope) {
+	// the labels of domains are unknown up front
+	if e.labelFunc != nil {
+		return
+	}
+
 	// misc
 	ch <- e.up
 	ch <- e.domains
+	ch <- e.matched
+	ch <- e.skipped
 	ch <- e.scrapeError
 	ch <- e.scrapeLatency
+	ch <- e.success
+	ch <- e.domainTook
+	ch <- e.lastSuccessTs
+	e.apiCalls.Describe(ch)
+	e.apiDuration.Describe(ch)
+	e.failures.Describe(ch)
+	e.errTotal.Describe(ch)
+	e.sanitized.Describe(ch)
 
-	// instance
-	ch <- e.state
-	ch <- e.maxMem
-	ch <- e.mem
-	ch <- e.vcpu
-	ch <- e.cputime
+	for _, name := range collectorNames {
+		if sc.enabled(name) {
+			e.instances[name].Describe(ch)
+		}
+	}
+}
 
-	// block
-	ch <- e.blockReadReqs
-	ch <- e.blockReadBytes
-	ch <- e.blockWriteReqs
-	ch <- e.blockWriteBytes
+func (e *Exporter) Collect(metrics chan<- prometheus.Metric) {
+	e.collectScope(metrics, e.scope())
+}
 
-	// iface
-	ch <- e.ifaceReceiveBytes
-	ch <- e.ifaceReceivePackets
-	ch <- e.ifaceReceiveErrors
-	ch <- e.ifaceReceiveDrops
-	ch <- e.ifaceTransmitBytes
-	ch <- e.ifaceTransmitPackets
-	ch <- e.ifaceTransmitErrors
-	ch <- e.ifaceTransmitDrops
+// CollectWithContext is Collect, but the collection is canceled along
+// with ctx, e.g. once the client scraping goes away. The pending libvirt
+// call fails at once, and the hypervisor is reported failed.
+func (e *Exporter) CollectWithContext(ctx context.Context, metrics chan<- prometheus.Metric) {
+	sc := e.scope()
+	sc.ctx = ctx
+	e.collectScope(metrics, sc)
 }
 
-func (e *Exporter) Collect(metrics chan<- prometheus.Metric) {
+// WithContext returns a collector which collects e with ctx, see
+// CollectWithContext.
+func (e *Exporter) WithContext(ctx context.Context) prometheus.Collector {
+	return &limitedExporter{e: e, ctx: ctx}
+}
+
+func (e *Exporter) collectScope(metrics chan<- prometheus.Metric, sc scope) {
 	var (
 		scrapeError = 0.0
 		start       = time.Now()
 	)
 
-	if err := e.collect(metrics); err != nil {
+	sc.failed = collectorErrors{}
+	sc.domains = &[]DomainResult{}
+	sc.phases = &[]PhaseResult{}
+	if e.spans != nil {
+		sc.trace = newTrace()
+		sc.trace.start(noSpan, "collect", map[string]string{"libvirt.uri": e.uri})
+	}
+
+	Stats.Add(statScrapes, 1)
+	Stats.Add(statScrapesInFlight, 1)
+	atomic.AddInt32(&e.inFlight, 1)
+	e.progressed()
+	err := e.collect(metrics, sc)
+	e.progressed()
+	atomic.AddInt32(&e.inFlight, -1)
+	Stats.Add(statScrapesInFlight, -1)
+	if err != nil {
+		e.logger.Printf("collect metrics failed, %s\n", err)
+	}
+
+	for _, name := range sc.names() {
+		success := 1.0
+		if err != nil {
+			success = 0
+		} else if cerr := sc.failed[name]; cerr != nil {
+			success = 0
+			e.logger.Printf("collector %s failed, %s\n", name, cerr)
+		}
+
+		metrics <- e.constMetric(
+			e.success,
+			prometheus.GaugeValue,
+			success,
+			name)
+	}
+
+	if err != nil {
+		e.failures.WithLabelValues(failureReason(err)).Inc()
+	} else if names := sc.failed.names(); len(names) != 0 {
+		// the first failed collector tells the reason
+		e.failures.WithLabelValues(failureReason(sc.failed[names[0]])).Inc()
+		err = errors.Errorf("%d collectors failed", len(names))
+	}
+
+	if err != nil {
+		Stats.Add(statScrapeErrors, 1)
+		e.errTotal.Inc()
 		scrapeError = 1.0
-		log.Printf("collect metrics failed, %s\n", err)
+	}
+
+	sc.trace.end(root, err)
+	if e.spans != nil {
+		sc.trace.export(e.spans)
 	}
 
 	latency := time.Since(start)
-	metrics <- prometheus.MustNewConstMetric(
+	e.mu.Lock()
+	e.lastScrape = ScrapeResult{
+		Time:       start,
+		Duration:   latency,
+		Err:        err,
+		Collectors: sc.names(),
+		Failed:     sc.failed,
+		Domains:    *sc.domains,
+		Phases:     *sc.phases,
+	}
+	if e.slowThreshold > 0 && latency > e.slowThreshold {
+		e.logSlowScrape(e.lastScrape)
+	}
+	if err == nil {
+		e.lastSuccess = start
+	}
+	lastSuccess := e.lastSuccess
+	e.mu.Unlock()
+
+	if !lastSuccess.IsZero() {
+		metrics <- e.constMetric(
+			e.lastSuccessTs,
+			prometheus.GaugeValue,
+			float64(lastSuccess.UnixNano())/1e9)
+	}
+
+	metrics <- e.constMetric(
 		e.scrapeLatency,
 		prometheus.GaugeValue,
 		latency.Seconds())
 
-	metrics <- prometheus.MustNewConstMetric(
+	metrics <- e.constMetric(
 		e.scrapeError,
 		prometheus.GaugeValue,
 		scrapeError,
 	)
+
+	e.apiCalls.Collect(metrics)
+	e.apiDuration.Collect(metrics)
+	e.failures.Collect(metrics)
+	e.errTotal.Collect(metrics)
+	e.sanitized.Collect(metrics)
 }
 
-func (e *Exporter) collect(metrics chan<- prometheus.Metric) error {
-	conn, err := net.DialTimeout("unix", e.uri, 5*time.Second)
+func (e *Exporter) collect(metrics chan<- prometheus.Metric, sc scope) error {
+	start := time.Now()
+	l, release, err := e.open(sc.ctx)
 	if err != nil {
-		return err
+		Stats.Add(statConnectionErrors, 1)
+		e.collectDown(metrics)
+		return &failure{reason: reasonDial, err: err}
 	}
 
-	defer conn.Close()
+	Stats.Add(statConnectionsOpened, 1)
+
+	defer release()
 
-	cli := libvirt.New(conn)
+	cli := &client{l: l, e: e, trace: sc.trace, span: root}
 	if err = cli.Connect(); err != nil {
-		return errors.Wrap(err, "failed to connect")
+		e.collectDown(metrics)
+		return errors.Wrap(&failure{reason: reasonAuth, err: err}, "failed to connect")
 	}
 
-	defer cli.Disconnect()
+	defer cli.l.Disconnect()
+	sc.phase("connect", start)
 
-	// todo: always 1.0!?
-	metrics <- prometheus.MustNewConstMetric(
+	metrics <- e.constMetric(
 		e.up,
 		prometheus.GaugeValue,
 		1.0)
+	if sc.tally != nil {
+		sc.tally.up = true
+	}
 
-	domains, err := cli.Domains()
+	flags := libvirt.ConnectListDomainsActive
+	if e.inactive {
+		flags |= libvirt.ConnectListDomainsInactive
+	}
+
+	start = time.Now()
+	domains, _, err := cli.ConnectListAllDomains(1, flags)
 	if err != nil {
 		return errors.Wrap(err, "failed to load domain")
 	}
+	sc.phase("list-domains", start)
 
 	//domains number
 	domainNumber := len(domains)
-	metrics <- prometheus.MustNewConstMetric(
+	metrics <- e.constMetric(
 		e.domains,
 		prometheus.GaugeValue,
 		float64(domainNumber))
 
+	if e.agentBudget > 0 {
+		left := int64(e.agentBudget)
+		sc.agentLeft = &left
+	}
+
+	// one call for the bulk stats of all the domains instead of one per
+	// domain
+	if groups := bulkGroups(sc); groups != 0 {
+		var listed []libvirt.Domain
+		for _, domain := range domains {
+			if sc.filter.Match(domain.Name, uuidConvert(domain.UUID)) {
+				listed = append(listed, domain)
+			}
+		}
+
+		start = time.Now()
+		sc.bulk = collectBulkStats(cli, listed, groups)
+		sc.phase("bulk-stats", start)
+	}
+
+	start = time.Now()
+	var collected, skipped int
 	for _, domain := range domains {
-		err = e.collectDomain(metrics, cli, domain)
+		if err = sc.ctx.Err(); err != nil {
+			return errors.Wrap(err, "collection canceled")
+		}
+
+		if !sc.filter.Match(domain.Name, uuidConvert(domain.UUID)) {
+			continue
+		}
+
+		// only the domains passing every filter count against the limit,
+		// the XML of the ones over it isn't read, so they are counted as
+		// skipped by their name alone
+		if e.maxDomains > 0 && collected >= e.maxDomains {
+			skipped++
+			continue
+		}
+
+		// the RPCs of the domain are traced under its own span
+		dcli := *cli
+		dcli.span = sc.trace.start(root, "domain", map[string]string{"domain": domain.Name})
+		var matched bool
+		matched, err = e.collectDomain(metrics, &dcli, domain, sc)
+		sc.trace.end(dcli.span, err)
+		if matched {
+			collected++
+		}
 		if err != nil {
-			return errors.Wrap(err, "failed to collect domain")
+			sc.failed.add("domain", errors.Wrapf(err, "failed to collect domain %s", domain.Name))
 		}
 	}
+
+	sc.phase("domains", start)
+
+	// the domains listed but excluded by the filters are in domains only
+	metrics <- e.constMetric(
+		e.matched,
+		prometheus.GaugeValue,
+		float64(collected+skipped))
+	metrics <- e.constMetric(
+		e.skipped,
+		prometheus.GaugeValue,
+		float64(skipped))
+
+	for _, name := range collectorNames {
+		c, ok := e.instances[name].(nodeCollector)
+		if !ok || !sc.enabled(name) {
+			continue
+		}
+
+		if err = sc.ctx.Err(); err != nil {
+			return errors.Wrap(err, "collection canceled")
+		}
+
+		start = time.Now()
+		err = c.Collect(metrics, cli)
+		switch {
+		case err == nil:
+		case notSupported(err):
+			if _, logged := e.unsupported.LoadOrStore(name, true); !logged {
+				e.logger.Printf("collector %s not applicable, %s\n", name, err)
+			}
+		default:
+			sc.failed.add(name, err)
+		}
+		sc.phase(name, start)
+	}
+
 	return nil
 }
 
+// DomainFilter returns the domain filter in use.
+func (e *Exporter) DomainFilter() DomainFilter {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	return e.filter
+}
+
+// collectDown reports the hypervisor unreachable, up is always reported
+// so alerts don't have to tell a missing series from a down hypervisor.
+func (e *Exporter) collectDown(metrics chan<- prometheus.Metric) {
+	metrics <- e.constMetric(
+		e.up,
+		prometheus.GaugeValue,
+		0.0)
+}
+
+// collectUnfinished reports the hypervisor whose collection is given up,
+// e.g. exceeding the budget of MultiExporter, with the same series as a
+// hypervisor which can't be connected. The collection is canceled along
+// with its context, and counts its failure itself once it returns.
+func (e *Exporter) collectUnfinished(metrics chan<- prometheus.Metric, sc scope, start time.Time) {
+	e.collectDown(metrics)
+
+	for _, name := range sc.names() {
+		metrics <- e.constMetric(
+			e.success,
+			prometheus.GaugeValue,
+			0.0,
+			name)
+	}
+
+	e.mu.RLock()
+	lastSuccess := e.lastSuccess
+	e.mu.RUnlock()
+
+	if !lastSuccess.IsZero() {
+		metrics <- e.constMetric(
+			e.lastSuccessTs,
+			prometheus.GaugeValue,
+			float64(lastSuccess.UnixNano())/1e9)
+	}
+
+	metrics <- e.constMetric(
+		e.scrapeLatency,
+		prometheus.GaugeValue,
+		time.Since(start).Seconds())
+
+	metrics <- e.constMetric(
+		e.scrapeError,
+		prometheus.GaugeValue,
+		1.0)
+
+	e.apiCalls.Collect(metrics)
+	e.apiDuration.Collect(metrics)
+	e.failures.Collect(metrics)
+	e.errTotal.Collect(metrics)
+	e.sanitized.Collect(metrics)
+}
+
+// SetDomainFilter replaces the domain filter, it takes effect from the
+// next collection.
+func (e *Exporter) SetDomainFilter(filter DomainFilter) {
+	e.mu.Lock()
+	e.filter = filter
+	e.mu.Unlock()
+}
+
+// ScrapeResult is the result of a collection.
+type ScrapeResult struct {
+	Time     time.Time
+	Duration time.Duration
+	Err      error
+
+	// the collectors run, and the errors of the failed ones
+	Collectors []string
+	Failed     map[string]error
+
+	// the collected domains and the phases of the collection, in the
+	// order of collection
+	Domains []DomainResult
+	Phases  []PhaseResult
+}
+
+// PhaseResult is the time a phase of a collection took, e.g. connect,
+// domains or one of the host wide collectors.
+type PhaseResult struct {
+	Name     string
+	Duration time.Duration
+}
+
+// DomainResult is the result of collecting a domain, Err is the error
+// stopping its collection, the errors of single collectors are reported
+// by ScrapeResult.Failed.
+type DomainResult struct {
+	Name     string
+	UUID     string
+	Duration time.Duration
+	Err      error
+}
+
+// LastScrape returns the result of the last collection, Time is zero
+// if nothing has been collected yet.
+func (e *Exporter) LastScrape() ScrapeResult {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	return e.lastScrape
+}
+
+// progressed records that a collection made progress.
+func (e *Exporter) progressed() {
+	atomic.StoreInt64(&e.progress, time.Now().UnixNano())
+}
+
+// Stalled reports whether a collection is in flight that made no progress
+// for d, i.e. no RPC of it was answered, failed or not. It asks nothing
+// from libvirt, so a watchdog could check it as often as it likes.
+func (e *Exporter) Stalled(d time.Duration) bool {
+	if atomic.LoadInt32(&e.inFlight) == 0 {
+		return false
+	}
+
+	return time.Since(time.Unix(0, atomic.LoadInt64(&e.progress))) > d
+}
+
+// URI returns the libvirt URI metrics are collected from.
+func (e *Exporter) URI() string {
+	return e.uri
+}
+
+// EnabledCollectors returns the sorted names of the enabled collectors.
+func (e *Exporter) EnabledCollectors() []string {
+	return e.scope().names()
+}
+
+// scope is what a single collection covers.
+type scope struct {
+	ctx        context.Context
+	collectors map[string]bool
+	filter     DomainFilter
+
+	// nanoseconds the guest agent commands of the collection could still
+	// take, shared by its domains, nil means no limit
+	agentLeft *int64
+
+	// bulk stats of the domains of the collection, nil unless a collector
+	// needs them
+	bulk *bulkStats
+
+	// running domains of the collection, nil unless they are aggregated
+	tally *domainTally
+
+	// errors of the collectors during the current collection
+	failed collectorErrors
+
+	// spans of the current collection, nil unless tracing is enabled
+	trace *trace
+
+	// results of the domains and phases collected so far
+	domains *[]DomainResult
+	phases  *[]PhaseResult
+}
+
+// enabled reports whether the collector of the metric group is enabled.
+func (sc scope) enabled(collector string) bool {
+	return sc.collectors[collector]
+}
+
+// names returns the sorted names of the enabled collectors.
+func (sc scope) names() []string {
+	names := make([]string, 0, len(sc.collectors))
+	for name, enabled := range sc.collectors {
+		if enabled {
+			names = append(names, name)
+		}
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+// phase records the time a phase of the collection took.
+func (sc scope) phase(name string, start time.Time) {
+	*sc.phases = append(*sc.phases, PhaseResult{Name: name, Duration: time.Since(start)})
+}
+
+// collectorErrors keeps the first error of every failed collector,
+// a failing collector does not stop the others.
+type collectorErrors map[string]error
+
+func (c collectorErrors) add(collector string, err error) {
+	if _, ok := c[collector]; !ok {
+		c[collector] = err
+	}
+}
+
+// names returns the sorted names of the failed collectors.
+func (c collectorErrors) names() []string {
+	names := make([]string, 0, len(c))
+	for name := range c {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+func (e *Exporter) scope() scope {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	return scope{
+		ctx:        context.Background(),
+		collectors: e.collectors,
+		filter:     e.filter,
+	}
+}
+
+// limitedExporter collects e with the given collectors, all the enabled
+// ones if nil, and the given context, if any.
+type limitedExporter struct {
+	e          *Exporter
+	ctx        context.Context
+	collectors map[string]bool
+
+	// the running domains are summed up in it, if not nil
+	tally *domainTally
+}
+
+func (l *limitedExporter) sc() scope {
+	sc := l.e.scope()
+	if l.ctx != nil {
+		sc.ctx = l.ctx
+	}
+	if l.collectors != nil {
+		sc.collectors = l.collectors
+	}
+	sc.tally = l.tally
+	return sc
+}
+
+func (l *limitedExporter) Describe(ch chan<- *prometheus.Desc) {
+	l.e.describe(ch, l.sc())
+}
+
+func (l *limitedExporter) Collect(ch chan<- prometheus.Metric) {
+	l.e.collectScope(ch, l.sc())
+}
+
+// Only returns a collector which collects the named collectors only,
+// the disabled ones are ignored. It's used for scrapes asking for
+// some metric groups, e.g. /metrics?collect[]=block
+func (e *Exporter) Only(names ...string) (prometheus.Collector, error) {
+	return e.OnlyWithContext(context.Background(), names...)
+}
+
+// OnlyWithContext is Only, but the collection is canceled along with ctx.
+func (e *Exporter) OnlyWithContext(ctx context.Context, names ...string) (prometheus.Collector, error) {
+	collectors, err := e.only(names)
+	if err != nil {
+		return nil, err
+	}
+
+	return &limitedExporter{e: e, ctx: ctx, collectors: collectors}, nil
+}
+
+// only returns the named collectors, enabled or not.
+func (e *Exporter) only(names []string) (map[string]bool, error) {
+	collectors := make(map[string]bool, len(names))
+	for _, name := range names {
+		if _, ok := Collectors[name]; !ok {
+			return nil, errors.Errorf("unknown collector %q", name)
+		}
+
+		collectors[name] = e.collectors[name]
+	}
+
+	return collectors, nil
+}
+
 func encodeHex(dst []byte, uuid libvirt.UUID) {
 	hex.Encode(dst, uuid[:4])
 	dst[8] = '-'
@@ -178,26 +827,242 @@ func uuidConvert(uuid libvirt.UUID) string {
 	return string(buf[:])
 }
 
-func (e *Exporter) collectDomain(ch chan<- prometheus.Metric, cli *libvirt.Libvirt, domain libvirt.Domain) error {
-	xmlDesc, err := cli.DomainGetXMLDesc(domain, 0)
+// collectDomain collects the domain, it reports whether the domain passed
+// the filters of the XML, failed domains are counted as matched.
+func (e *Exporter) collectDomain(ch chan<- prometheus.Metric, cli *client, domain libvirt.Domain, sc scope) (matched bool, err error) {
+	start := time.Now()
+	d := &domainContext{
+		domain: domain,
+		name:   domain.Name,
+		uuid:   uuidConvert(domain.UUID),
+
+		agentLeft: sc.agentLeft,
+		bulk:      sc.bulk,
+	}
+	d.state, d.maxMem, d.mem, d.vcpu, d.cputime, err = cli.DomainGetInfo(domain)
 	if err != nil {
-		return errors.Wrap(err, "failed to DomainGetXMLDesc")
+		return true, errors.Wrap(err, "failed to get domain info")
 	}
 
-	var libvirtSchema Domain
-	err = xml.Unmarshal([]byte(xmlDesc), &libvirtSchema)
+	// shut off domains have no qemu to ask, the XML is needed for the
+	// filter, labels and the collectors reporting the config only, hosts
+	// with hundreds of templates save most of the collection
+	active := d.state != uint8(libvirt.DomainShutoff)
+	d.active = &active
+	if active || e.inactiveDevices || e.labelFunc != nil || sc.filter.needsXML() || sc.readsConfig() {
+		xmlDesc, err := cli.DomainGetXMLDesc(domain, 0)
+		if err != nil {
+			return true, errors.Wrap(err, "failed to DomainGetXMLDesc")
+		}
+
+		var libvirtSchema Domain
+		err = xml.Unmarshal([]byte(xmlDesc), &libvirtSchema)
+		if err != nil {
+			return true, errors.Wrap(err, "failed to unmarshal domain")
+		}
+
+		if !sc.filter.MatchXML(&libvirtSchema) {
+			return false, nil
+		}
+
+		d.schema = &libvirtSchema
+	}
+
+	if sc.tally != nil {
+		sc.tally.add(d)
+	}
+
+	name := d.name
+	uuid := d.uuid
+
+	// registered before the deferred sends below, so they are labeled
+	if pairs := e.domainLabels(DomainMeta{Name: name, UUID: uuid, Schema: d.schema}); pairs != nil {
+		var flush func()
+		ch, flush = labelMetrics(ch, pairs)
+		defer flush()
+	}
+
+	// a hung qemu monitor shows up as the slowest domain
+	defer func() {
+		took := time.Since(start)
+		*sc.domains = append(*sc.domains, DomainResult{Name: name, UUID: uuid, Duration: took, Err: err})
+
+		ch <- e.constMetric(
+			e.domainTook,
+			prometheus.GaugeValue,
+			took.Seconds(),
+			name, uuid)
+	}()
+
+	for _, group := range collectorNames {
+		c, ok := e.instances[group].(domainCollector)
+		if !ok || !sc.enabled(group) {
+			continue
+		}
+
+		if !active && !e.collectsInactive(group) {
+			continue
+		}
+
+		if err := c.CollectDomain(ch, cli, d); err != nil {
+			sc.failed.add(group, err)
+		}
+	}
+
+	// the info gathers what the other collectors found out, e.g. the
+	// hostname reported by the guest agent
+	if sc.enabled("domain") {
+		ch <- e.constMetric(
+			e.info,
+			prometheus.GaugeValue,
+			1,
+			name, uuid, d.hostname)
+	}
+
+	return true, nil
+}
+
+// collectsInactive reports whether the collector of the group runs for
+// inactive domains too, the others need a running qemu.
+func (e *Exporter) collectsInactive(group string) bool {
+	switch group {
+	case "domain":
+		return true
+	case "block", "interface":
+		return e.inactiveDevices
+	}
+
+	return readsConfig(group)
+}
+
+// readsConfig reports whether the collector of the group reports what the
+// XML of domains configures only, which holds for shut off domains too.
+func readsConfig(group string) bool {
+	switch group {
+	case "launch-security", "tpm", "hostdev":
+		return true
+	}
+
+	return false
+}
+
+// readsConfig reports whether any collector reading the config of domains
+// is enabled, the XML of shut off domains is read for them.
+func (sc scope) readsConfig() bool {
+	for group, enabled := range sc.collectors {
+		if enabled && readsConfig(group) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// domainInfoCollector reports the state and resources of domains.
+type domainInfoCollector struct{ e *Exporter }
+
+func (c domainInfoCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.e.info
+	ch <- c.e.state
+	ch <- c.e.maxMem
+	ch <- c.e.mem
+	ch <- c.e.vcpu
+	ch <- c.e.cputime
+}
+
+func (c domainInfoCollector) CollectDomain(ch chan<- prometheus.Metric, cli *client, d *domainContext) error {
+	e := c.e
+	ch <- e.constMetric(
+		e.state,
+		prometheus.GaugeValue,
+		float64(d.state),
+		d.name, d.uuid, domainStates[d.state])
+
+	ch <- e.constMetric(
+		e.maxMem,
+		prometheus.GaugeValue,
+		float64(d.maxMem)*1024,
+		d.name, d.uuid)
+	ch <- e.constMetric(
+		e.mem,
+		prometheus.GaugeValue,
+		float64(d.mem)*1024,
+		d.name, d.uuid)
+	ch <- e.constMetric(
+		e.vcpu,
+		prometheus.GaugeValue,
+		float64(d.vcpu),
+		d.name, d.uuid)
+	ch <- e.constMetric(
+		e.cputime,
+		prometheus.CounterValue,
+		float64(d.cputime)/1e9,
+		d.name, d.uuid)
+
+	return nil
+}
+
+type memoryCollector struct{ e *Exporter }
+
+func (c memoryCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.e.rss
+}
+
+func (c memoryCollector) CollectDomain(ch chan<- prometheus.Metric, cli *client, d *domainContext) error {
+	return c.e.collectMemoryStats(ch, cli, d.domain, d.name, d.uuid)
+}
+
+type blockCollector struct{ e *Exporter }
+
+func (c blockCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.e.blockReadReqs
+	ch <- c.e.blockReadBytes
+	ch <- c.e.blockWriteReqs
+	ch <- c.e.blockWriteBytes
+}
+
+func (c blockCollector) CollectDomain(ch chan<- prometheus.Metric, cli *client, d *domainContext) error {
+	active, err := d.isActive(cli)
 	if err != nil {
-		return errors.Wrap(err, "failed to unmarshal domain")
+		return errors.Wrap(err, "failed to get DomainIsActive")
 	}
 
-	name := domain.Name
-	uuid := uuidConvert(domain.UUID)
+	// zeros would look like counter resets
+	if !active && !c.e.inactiveDevices {
+		return nil
+	}
 
-	state, maxMem, mem, vcpu, cputime, err := cli.DomainGetInfo(domain)
+	return c.e.collectBlockStats(ch, cli, d.domain, d.name, d.uuid, d.schema.Devices.Disks, active)
+}
+
+type interfaceCollector struct{ e *Exporter }
+
+func (c interfaceCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.e.ifaceReceiveBytes
+	ch <- c.e.ifaceReceivePackets
+	ch <- c.e.ifaceReceiveErrors
+	ch <- c.e.ifaceReceiveDrops
+	ch <- c.e.ifaceTransmitBytes
+	ch <- c.e.ifaceTransmitPackets
+	ch <- c.e.ifaceTransmitErrors
+	ch <- c.e.ifaceTransmitDrops
+}
+
+func (c interfaceCollector) CollectDomain(ch chan<- prometheus.Metric, cli *client, d *domainContext) error {
+	active, err := d.isActive(cli)
 	if err != nil {
-		return errors.Wrap(err, "failed to get domain info")
+		return errors.Wrap(err, "failed to get DomainIsActive")
+	}
+
+	// zeros would look like counter resets
+	if !active && !c.e.inactiveDevices {
+		return nil
 	}
 
+	return c.e.collectInterfaceStats(ch, cli, d.domain, d.name, d.uuid, d.schema.Devices.Interfaces, active)
+}
+
+func (e *Exporter) collectMemoryStats(ch chan<- prometheus.Metric, cli *client, domain libvirt.Domain, name, uuid string) error {
 	// same as `virsh dommemstat xxx`
 	// actual 8388608
 	// last_update 0
@@ -209,7 +1074,7 @@ func (e *Exporter) collectDomain(ch chan<- prometheus.Metric, cli *libvirt.Libvi
 
 	for i := 0; i < len(stats); i++ {
 		if stats[i].Tag == int32(libvirt.DomainMemoryStatRss) {
-			ch <- prometheus.MustNewConstMetric(
+			ch <- e.constMetric(
 				e.rss,
 				prometheus.GaugeValue,
 				float64(stats[i].Val*1024),
@@ -217,98 +1082,134 @@ func (e *Exporter) collectDomain(ch chan<- prometheus.Metric, cli *libvirt.Libvi
 		}
 	}
 
-	ch <- prometheus.MustNewConstMetric(
-		e.state,
-		prometheus.GaugeValue,
-		float64(state),
-		name, uuid, domainStates[state])
+	return nil
+}
 
-	ch <- prometheus.MustNewConstMetric(
-		e.maxMem,
-		prometheus.GaugeValue,
-		float64(maxMem)*1024,
-		name, uuid)
-	ch <- prometheus.MustNewConstMetric(
-		e.mem,
-		prometheus.GaugeValue,
-		float64(mem)*1024,
-		name, uuid)
-	ch <- prometheus.MustNewConstMetric(
-		e.vcpu,
-		prometheus.GaugeValue,
-		float64(vcpu),
-		name, uuid)
-	ch <- prometheus.MustNewConstMetric(
-		e.cputime,
-		prometheus.CounterValue,
-		float64(cputime)/1e9,
-		name, uuid)
+// diskSource returns the source label of the disk, the file, block
+// device or directory, protocol:name of network disks, e.g.
+// rbd:pool/image, or pool/volume of storage volumes.
+func diskSource(disk *Disk) string {
+	src := disk.Source
+	switch {
+	case src.File != "":
+		return src.File
+	case src.Dev != "":
+		return src.Dev
+	case src.Dir != "":
+		return src.Dir
+	case src.Protocol != "":
+		return src.Protocol + ":" + src.Name
+	case src.Volume != "":
+		return src.Pool + "/" + src.Volume
+	case src.Path != "":
+		return src.Path
+	}
+
+	return ""
+}
+
+// diskVolume returns the volume label of the disk, the name of network
+// disks, e.g. the pool/image of rbd or the iqn/lun of iscsi, or
+// pool/volume of storage volumes, empty for local disks.
+func diskVolume(disk *Disk) string {
+	src := disk.Source
+	switch {
+	case src.Protocol != "":
+		return src.Name
+	case src.Volume != "":
+		return src.Pool + "/" + src.Volume
+	}
+
+	return ""
+}
+
+func (e *Exporter) collectBlockStats(ch chan<- prometheus.Metric, cli *client, domain libvirt.Domain, name, uuid string, disks []Disk, active bool) error {
+	// the target is unique in valid domains, but a duplicate would fail
+	// the whole scrape
+	seen := make(map[string]bool, len(disks))
 
 	// Report block device statistics.
-	for _, disk := range libvirtSchema.Devices.Disks {
+	for i := range disks {
+		disk := &disks[i]
 		if disk.Device == "cdrom" || disk.Device == "fd" {
 			continue
 		}
 
-		isActive, err := cli.DomainIsActive(domain)
-		var rRdReq, rRdBytes, rWrReq, rWrBytes int64
-		if isActive == 1 {
-			rRdReq, rRdBytes, rWrReq, rWrBytes, _, err = cli.DomainBlockStats(domain, disk.Target.Device)
+		if disk.Target.Device == "" || seen[disk.Target.Device] {
+			continue
 		}
+		seen[disk.Target.Device] = true
+		source, volume := diskSource(disk), diskVolume(disk)
 
-		if err != nil {
-			return errors.Wrap(err, "failed to get DomainBlockStats")
+		var rRdReq, rRdBytes, rWrReq, rWrBytes int64
+		if active {
+			var err error
+			rRdReq, rRdBytes, rWrReq, rWrBytes, _, err = cli.DomainBlockStats(domain, disk.Target.Device)
+			if err != nil {
+				return errors.Wrap(err, "failed to get DomainBlockStats")
+			}
 		}
 
-		ch <- prometheus.MustNewConstMetric(
+		ch <- e.constMetric(
 			e.blockReadBytes,
 			prometheus.CounterValue,
 			float64(rRdBytes),
 			name, uuid,
-			disk.Source.File,
-			disk.Target.Device)
+			source,
+			disk.Target.Device,
+			disk.Source.Protocol,
+			volume)
 
-		ch <- prometheus.MustNewConstMetric(
+		ch <- e.constMetric(
 			e.blockReadReqs,
 			prometheus.CounterValue,
 			float64(rRdReq),
 			name, uuid,
-			disk.Source.File,
-			disk.Target.Device)
+			source,
+			disk.Target.Device,
+			disk.Source.Protocol,
+			volume)
 
-		ch <- prometheus.MustNewConstMetric(
+		ch <- e.constMetric(
 			e.blockWriteBytes,
 			prometheus.CounterValue,
 			float64(rWrBytes),
 			name, uuid,
-			disk.Source.File,
-			disk.Target.Device)
+			source,
+			disk.Target.Device,
+			disk.Source.Protocol,
+			volume)
 
-		ch <- prometheus.MustNewConstMetric(
+		ch <- e.constMetric(
 			e.blockWriteReqs,
 			prometheus.CounterValue,
 			float64(rWrReq),
 			name, uuid,
-			disk.Source.File,
-			disk.Target.Device)
+			source,
+			disk.Target.Device,
+			disk.Source.Protocol,
+			volume)
 	}
 
+	return nil
+}
+
+func (e *Exporter) collectInterfaceStats(ch chan<- prometheus.Metric, cli *client, domain libvirt.Domain, name, uuid string, ifaces []Interface, active bool) error {
 	// Report network interface statistics.
-	for _, iface := range libvirtSchema.Devices.Interfaces {
+	for _, iface := range ifaces {
 		if iface.Target.Device == "" {
 			continue
 		}
-		isActive, err := cli.DomainIsActive(domain)
 		var rRxBytes, rRxPackets, rRxErrs, rRxDrop, rTxBytes, rTxPackets, rTxErrs, rTxDrop int64
-		if isActive == 1 {
+		if active {
+			var err error
 			rRxBytes, rRxPackets, rRxErrs, rRxDrop, rTxBytes, rTxPackets, rTxErrs, rTxDrop, err = cli.DomainInterfaceStats(domain, iface.Target.Device)
+			if err != nil {
+				return errors.Wrap(err, "failed to get DomainInterfaceStats")
+			}
 		}
 
-		if err != nil {
-			return errors.Wrap(err, "failed to get DomainInterfaceStats")
-		}
-
-		ch <- prometheus.MustNewConstMetric(
+		ch <- e.constMetric(
 			e.ifaceReceiveBytes,
 			prometheus.CounterValue,
 			float64(rRxBytes),
@@ -316,7 +1217,7 @@ func (e *Exporter) collectDomain(ch chan<- prometheus.Metric, cli *libvirt.Libvi
 			iface.Source.Bridge,
 			iface.Target.Device)
 
-		ch <- prometheus.MustNewConstMetric(
+		ch <- e.constMetric(
 			e.ifaceReceivePackets,
 			prometheus.CounterValue,
 			float64(rRxPackets),
@@ -324,7 +1225,7 @@ func (e *Exporter) collectDomain(ch chan<- prometheus.Metric, cli *libvirt.Libvi
 			iface.Source.Bridge,
 			iface.Target.Device)
 
-		ch <- prometheus.MustNewConstMetric(
+		ch <- e.constMetric(
 			e.ifaceReceiveErrors,
 			prometheus.CounterValue,
 			float64(rRxErrs),
@@ -332,7 +1233,7 @@ func (e *Exporter) collectDomain(ch chan<- prometheus.Metric, cli *libvirt.Libvi
 			iface.Source.Bridge,
 			iface.Target.Device)
 
-		ch <- prometheus.MustNewConstMetric(
+		ch <- e.constMetric(
 			e.ifaceReceiveDrops,
 			prometheus.CounterValue,
 			float64(rRxDrop),
@@ -340,7 +1241,7 @@ func (e *Exporter) collectDomain(ch chan<- prometheus.Metric, cli *libvirt.Libvi
 			iface.Source.Bridge,
 			iface.Target.Device)
 
-		ch <- prometheus.MustNewConstMetric(
+		ch <- e.constMetric(
 			e.ifaceTransmitBytes,
 			prometheus.CounterValue,
 			float64(rTxBytes),
@@ -348,7 +1249,7 @@ func (e *Exporter) collectDomain(ch chan<- prometheus.Metric, cli *libvirt.Libvi
 			iface.Source.Bridge,
 			iface.Target.Device)
 
-		ch <- prometheus.MustNewConstMetric(
+		ch <- e.constMetric(
 			e.ifaceTransmitPackets,
 			prometheus.CounterValue,
 			float64(rTxPackets),
@@ -356,7 +1257,7 @@ func (e *Exporter) collectDomain(ch chan<- prometheus.Metric, cli *libvirt.Libvi
 			iface.Source.Bridge,
 			iface.Target.Device)
 
-		ch <- prometheus.MustNewConstMetric(
+		ch <- e.constMetric(
 			e.ifaceTransmitErrors,
 			prometheus.CounterValue,
 			float64(rTxErrs),
@@ -364,7 +1265,7 @@ func (e *Exporter) collectDomain(ch chan<- prometheus.Metric, cli *libvirt.Libvi
 			iface.Source.Bridge,
 			iface.Target.Device)
 
-		ch <- prometheus.MustNewConstMetric(
+		ch <- e.constMetric(
 			e.ifaceTransmitDrops,
 			prometheus.CounterValue,
 			float64(rTxDrop),
@@ -378,137 +1279,561 @@ func (e *Exporter) collectDomain(ch chan<- prometheus.Metric, cli *libvirt.Libvi
 
 type Option func(exporter *Exporter)
 
+// WithCollector enables or disables one of the Collectors.
+func WithCollector(name string, enabled bool) Option {
+	return func(e *Exporter) {
+		e.collectors[name] = enabled
+	}
+}
+
 func WithNamespace(ns string) Option {
 	return func(e *Exporter) {
 		e.namespace = ns
 	}
 }
 
+// WithDomainFilter limits the domains to collect.
+func WithDomainFilter(filter DomainFilter) Option {
+	return func(e *Exporter) {
+		e.filter = filter
+	}
+}
+
+// WithInactiveDomains sets whether the defined but not running domains
+// are collected, they are collected by default.
+func WithInactiveDomains(enabled bool) Option {
+	return func(e *Exporter) {
+		e.inactive = enabled
+	}
+}
+
+// WithInactiveDevices sets whether the block and interface statistics of
+// inactive domains are reported as zeros, they are omitted by default.
+func WithInactiveDevices(enabled bool) Option {
+	return func(e *Exporter) {
+		e.inactiveDevices = enabled
+	}
+}
+
+// WithMaxDomains limits the number of domains collected per scrape, the
+// rest are skipped and counted by the domains_skipped metric. Zero means
+// no limit.
+func WithMaxDomains(n int) Option {
+	return func(e *Exporter) {
+		e.maxDomains = n
+	}
+}
+
+// WithStorageVolumes enables per-volume metrics, the limit volumes of
+// every pool with the largest allocation are reported, limit <= 0 means
+// no limit.
+func WithStorageVolumes(limit int) Option {
+	return func(e *Exporter) {
+		e.volumes = true
+		e.volumesLimit = limit
+	}
+}
+
+// WithDHCPLeaseInfo enables the info metric mapping MAC to IP and hostname
+// for every DHCP lease of the virtual networks.
+func WithDHCPLeaseInfo() Option {
+	return func(e *Exporter) {
+		e.leaseInfo = true
+	}
+}
+
+// WithNodeDeviceInfo enables the info metric of PCI, USB and mediated
+// devices, which are the candidates for passthrough, it needs the XML of
+// every one of them.
+func WithNodeDeviceInfo() Option {
+	return func(e *Exporter) {
+		e.deviceInfo = true
+	}
+}
+
+// WithGuestAgent enables metrics queried from the qemu-guest-agent of
+// running domains. Every command is abandoned after timeout, and at most
+// maxInFlight commands run at the same time.
+func WithGuestAgent(timeout time.Duration, maxInFlight int) Option {
+	return func(e *Exporter) {
+		if maxInFlight < 1 {
+			maxInFlight = 1
+		}
+
+		e.collectors["guest-agent"] = true
+		e.agentTimeout = timeout
+		e.agentInFlight = make(chan struct{}, maxInFlight)
+	}
+}
+
+// WithGuestAgentBudget limits the time the guest agent commands of a
+// collection could take altogether, the agents of the domains left are
+// not queried once it's spent, so hung agents of many domains won't stall
+// the collection. It's 10s by default, 0 means no limit.
+func WithGuestAgentBudget(budget time.Duration) Option {
+	return func(e *Exporter) {
+		e.agentBudget = budget
+	}
+}
+
+// WithDirtyRate enables the dirty-rate collector, the dirty rate of
+// every domain is measured for calcTime, in whole seconds, after every
+// collection and reported by the next one.
+func WithDirtyRate(calcTime time.Duration) Option {
+	return func(e *Exporter) {
+		e.collectors["dirty-rate"] = true
+		e.dirtyRateCalc = calcTime
+	}
+}
+
+// WithTimeout limits the time a collection could take, the connection
+// to libvirt is closed once it's exceeded.
+func WithTimeout(timeout time.Duration) Option {
+	return func(e *Exporter) {
+		e.timeout = timeout
+	}
+}
+
+// WithRPCTimeout limits the time every RPC could take, the RPC fails
+// once it's exceeded but the collection goes on, e.g. with the other
+// domains if the qemu monitor of one domain is stuck. Once 16 RPCs are
+// given up and still not answered, the RPCs fail at once until some of
+// them are answered.
+func WithRPCTimeout(timeout time.Duration) Option {
+	return func(e *Exporter) {
+		e.rpcTimeout = timeout
+	}
+}
+
+// WithFilters collects the domains whose names match include and don't
+// match exclude only, it's a shorthand of WithDomainFilter, a nil regexp
+// is ignored.
+func WithFilters(include, exclude *regexp.Regexp) Option {
+	return func(e *Exporter) {
+		e.filter = DomainFilter{Include: include, Exclude: exclude}
+	}
+}
+
+// WithSlowScrapeThreshold logs a warning with the slowest domains and
+// phases of every collection taking longer than threshold.
+func WithSlowScrapeThreshold(threshold time.Duration) Option {
+	return func(e *Exporter) {
+		e.slowThreshold = threshold
+	}
+}
+
+// WithConstLabels attaches the labels to every metric, it could be
+// applied more than once.
+func WithConstLabels(labels prometheus.Labels) Option {
+	return func(e *Exporter) {
+		if e.constLabels == nil {
+			e.constLabels = make(prometheus.Labels, len(labels))
+		}
+
+		for name, value := range labels {
+			e.constLabels[name] = value
+		}
+	}
+}
+
 func NewExporter(uri string, opts ...Option) *Exporter {
 	e := &Exporter{
-		namespace: "libvirt",
-		uri:       uri,
+		namespace:     "libvirt",
+		uri:           uri,
+		collectors:    make(map[string]bool, len(Collectors)),
+		inactive:      true,
+		agentTimeout:  2 * time.Second,
+		agentInFlight: make(chan struct{}, 8),
+		agentBudget:   10 * time.Second,
+		dirtyRateCalc: time.Second,
+		logger:        stdLogger{},
+		naming:        DefaultNaming,
+	}
+
+	for name, enabled := range Collectors {
+		e.collectors[name] = enabled
 	}
 
 	for _, h := range opts {
 		h(e)
 	}
 
+	e.instances = make(map[string]collector, len(factories))
+	for name, factory := range factories {
+		e.instances[name] = factory(e)
+	}
+
 	// descs
-	e.up = prometheus.NewDesc(
-		prometheus.BuildFQName(e.namespace, "", "up"),
+	e.metrics = make(map[*prometheus.Desc]MetricInfo)
+	e.up = e.newDesc(
+		e.fqName(e.namespace, "", "up"),
 		"Whether scraping libvirt's metrics was successful.",
 		nil,
-		nil)
-	e.domains = prometheus.NewDesc(
-		prometheus.BuildFQName(e.namespace, "", "domains_total"),
+		e.constLabels)
+	e.domains = e.newDesc(
+		e.fqName(e.namespace, "", "domains"),
 		"Number of the domain",
 		nil,
-		nil)
-	e.scrapeError = prometheus.NewDesc(
-		prometheus.BuildFQName(e.namespace, "", "scrape_error"),
+		e.constLabels)
+	e.matched = e.newDesc(
+		e.fqName(e.namespace, "", "domains_matched"),
+		"Number of domains passing the domain filters, the ones over the domain limit are matched by name and UUID only.",
+		nil,
+		e.constLabels)
+	e.skipped = e.newDesc(
+		e.fqName(e.namespace, "", "domains_skipped"),
+		"Number of domains not collected because of the domain limit.",
+		nil,
+		e.constLabels)
+	e.scrapeError = e.newDesc(
+		e.fqName(e.namespace, "", "scrape_error"),
 		"Scrape status of libvirt",
 		nil,
-		nil)
-	e.scrapeLatency = prometheus.NewDesc(
-		"libvirt_scrape_latency",
-		"Scrape latency in second",
-		nil, nil)
-
-	e.state = prometheus.NewDesc(
-		prometheus.BuildFQName(e.namespace, "", "domain_state"),
+		e.constLabels)
+	e.scrapeLatency = e.newDesc(
+		e.fqName(e.namespace, "exporter", "scrape_duration_seconds"),
+		"Time the collection took in seconds.",
+		nil, e.constLabels)
+	e.success = e.newDesc(
+		e.fqName(e.namespace, "exporter_collector", "success"),
+		"Whether a collector succeeded.",
+		[]string{"collector"},
+		e.constLabels)
+	e.domainTook = e.newDesc(
+		e.fqName(e.namespace, "domain", "scrape_duration_seconds"),
+		"Time collecting the domain took.",
+		[]string{"domain", "uuid"},
+		e.constLabels)
+	e.lastSuccessTs = e.newDesc(
+		e.fqName(e.namespace, "exporter", "last_scrape_success_timestamp_seconds"),
+		"Unix time of the start of the last successful collection.",
+		nil,
+		e.constLabels)
+	e.apiCalls = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name:        e.fqName(e.namespace, "exporter_api", "calls_total"),
+		Help:        "Number of libvirt RPCs by call and status.",
+		ConstLabels: e.constLabels,
+	}, []string{"call", "status"})
+	e.apiDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:        e.fqName(e.namespace, "exporter_api", "call_duration_seconds"),
+		Help:        "Duration of libvirt RPCs by call.",
+		ConstLabels: e.constLabels,
+		Buckets:     prometheus.ExponentialBuckets(0.0005, 4, 8),
+	}, []string{"call"})
+	e.failures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name:        e.fqName(e.namespace, "exporter", "scrape_failures_total"),
+		Help:        "Number of failed collections by reason.",
+		ConstLabels: e.constLabels,
+	}, []string{"reason"})
+	for _, reason := range []string{reasonDial, reasonAuth, reasonRPC, reasonXML, reasonTimeout} {
+		e.failures.WithLabelValues(reason)
+	}
+	e.errTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name:        e.fqName(e.namespace, "exporter", "scrape_errors_total"),
+		Help:        "Number of collections which failed or had failed collectors, counted like the scrape_error gauge.",
+		ConstLabels: e.constLabels,
+	})
+	e.sanitized = prometheus.NewCounter(prometheus.CounterOpts{
+		Name:        e.fqName(e.namespace, "exporter", "sanitized_label_values_total"),
+		Help:        "Number of label values with invalid UTF-8 or control characters replaced.",
+		ConstLabels: e.constLabels,
+	})
+
+	e.info = e.newDesc(
+		e.fqName(e.namespace, "", "domain_info"),
+		"Information of the domain, hostname is reported by the guest agent.",
+		[]string{"domain", "uuid", "hostname"},
+		e.constLabels)
+	e.state = e.newDesc(
+		e.fqName(e.namespace, "", "domain_state"),
 		"Code of the domain state",
 		[]string{"domain", "uuid", "state"},
-		nil)
-	e.maxMem = prometheus.NewDesc(
-		prometheus.BuildFQName(e.namespace, "domain_info", "maximum_memory_bytes"),
+		e.constLabels)
+	e.maxMem = e.newDesc(
+		e.fqName(e.namespace, "domain_memory", "maximum_bytes"),
 		"Maximum allowed memory of the domain, in bytes.",
 		[]string{"domain", "uuid"},
-		nil)
-	e.mem = prometheus.NewDesc(
-		prometheus.BuildFQName(e.namespace, "domain_info", "memory_usage_bytes"),
+		e.constLabels)
+	e.mem = e.newDesc(
+		e.fqName(e.namespace, "domain_memory", "usage_bytes"),
 		"Memory usage of the domain, in bytes.",
 		[]string{"domain", "uuid"},
-		nil)
-	e.vcpu = prometheus.NewDesc(
-		prometheus.BuildFQName(e.namespace, "domain_info", "virtual_cpus"),
+		e.constLabels)
+	e.vcpu = e.newDesc(
+		e.fqName(e.namespace, "domain", "virtual_cpus"),
 		"Number of virtual CPUs for the domain.",
 		[]string{"domain", "uuid"},
-		nil)
-	e.cputime = prometheus.NewDesc(
-		prometheus.BuildFQName(e.namespace, "domain_info", "cpu_time_seconds_total"),
+		e.constLabels)
+	e.cputime = e.newCounterDesc(
+		e.fqName(e.namespace, "domain", "cpu_time_seconds_total"),
 		"Amount of CPU time used by the domain, in seconds.",
 		[]string{"domain", "uuid"},
-		nil)
-	e.rss = prometheus.NewDesc(
-		prometheus.BuildFQName(e.namespace, "domain_info", "memory_rss_bytes"),
+		e.constLabels)
+	e.rss = e.newDesc(
+		e.fqName(e.namespace, "domain_memory", "rss_bytes"),
 		"A mount memory of the instance",
 		[]string{"domain", "uuid"},
-		nil)
+		e.constLabels)
 
 	// block
-	e.blockReadBytes = prometheus.NewDesc(
-		prometheus.BuildFQName(e.namespace, "domain_block", "read_bytes_total"),
+	e.blockReadBytes = e.newCounterDesc(
+		e.fqName(e.namespace, "block", "read_bytes_total"),
 		"Number of bytes read from a block device, in bytes.",
-		[]string{"domain", "uuid", "source_file", "target_device"},
-		nil)
-	e.blockReadReqs = prometheus.NewDesc(
-		prometheus.BuildFQName(e.namespace, "domain_block", "read_requests_total"),
+		[]string{"domain", "uuid", "source_file", "target_device", "protocol", "volume"},
+		e.constLabels)
+	e.blockReadReqs = e.newCounterDesc(
+		e.fqName(e.namespace, "block", "read_requests_total"),
 		"Number of read requests from a block device.",
-		[]string{"domain", "uuid", "source_file", "target_device"},
-		nil)
-	e.blockWriteBytes = prometheus.NewDesc(
-		prometheus.BuildFQName(e.namespace, "domain_block", "write_bytes_total"),
+		[]string{"domain", "uuid", "source_file", "target_device", "protocol", "volume"},
+		e.constLabels)
+	e.blockWriteBytes = e.newCounterDesc(
+		e.fqName(e.namespace, "block", "write_bytes_total"),
 		"Number of bytes write from a block device, in bytes.",
-		[]string{"domain", "uuid", "source_file", "target_device"},
-		nil)
-	e.blockWriteReqs = prometheus.NewDesc(
-		prometheus.BuildFQName(e.namespace, "domain_block", "write_requests_total"),
+		[]string{"domain", "uuid", "source_file", "target_device", "protocol", "volume"},
+		e.constLabels)
+	e.blockWriteReqs = e.newCounterDesc(
+		e.fqName(e.namespace, "block", "write_requests_total"),
 		"Number of write requests from a block device.",
-		[]string{"domain", "uuid", "source_file", "target_device"},
-		nil)
+		[]string{"domain", "uuid", "source_file", "target_device", "protocol", "volume"},
+		e.constLabels)
 
 	// iface
-	e.ifaceReceiveBytes = prometheus.NewDesc(
-		prometheus.BuildFQName(e.namespace, "domain_interface", "receive_bytes_total"),
+	e.ifaceReceiveBytes = e.newCounterDesc(
+		e.fqName(e.namespace, "interface", "receive_bytes_total"),
 		"Number of bytes received on a network interface, in bytes.",
 		[]string{"domain", "uuid", "source_bridge", "target_device"},
-		nil)
-	e.ifaceReceivePackets = prometheus.NewDesc(
-		prometheus.BuildFQName(e.namespace, "domain_interface", "receive_packets_total"),
+		e.constLabels)
+	e.ifaceReceivePackets = e.newCounterDesc(
+		e.fqName(e.namespace, "interface", "receive_packets_total"),
 		"Number of packets received on a network interface.",
 		[]string{"domain", "uuid", "source_bridge", "target_device"},
-		nil)
-	e.ifaceReceiveErrors = prometheus.NewDesc(
-		prometheus.BuildFQName(e.namespace, "domain_interface", "receive_errors_total"),
+		e.constLabels)
+	e.ifaceReceiveErrors = e.newCounterDesc(
+		e.fqName(e.namespace, "interface", "receive_errors_total"),
 		"Number of packet receive errors on a network interface.",
 		[]string{"domain", "uuid", "source_bridge", "target_device"},
-		nil)
-	e.ifaceReceiveDrops = prometheus.NewDesc(
-		prometheus.BuildFQName(e.namespace, "domain_interface", "receive_drops_total"),
+		e.constLabels)
+	e.ifaceReceiveDrops = e.newCounterDesc(
+		e.fqName(e.namespace, "interface", "receive_drops_total"),
 		"Number of packet receive drops on a network interface.",
 		[]string{"domain", "uuid", "source_bridge", "target_device"},
-		nil)
-	e.ifaceTransmitBytes = prometheus.NewDesc(
-		prometheus.BuildFQName(e.namespace, "domain_interface", "transmit_bytes_total"),
+		e.constLabels)
+	e.ifaceTransmitBytes = e.newCounterDesc(
+		e.fqName(e.namespace, "interface", "transmit_bytes_total"),
 		"Number of bytes transmitted on a network interface, in bytes.",
 		[]string{"domain", "uuid", "source_bridge", "target_device"},
-		nil)
-	e.ifaceTransmitPackets = prometheus.NewDesc(
-		prometheus.BuildFQName(e.namespace, "domain_interface", "transmit_packets_total"),
+		e.constLabels)
+	e.ifaceTransmitPackets = e.newCounterDesc(
+		e.fqName(e.namespace, "interface", "transmit_packets_total"),
 		"Number of packets transmitted on a network interface.",
 		[]string{"domain", "uuid", "source_bridge", "target_device"},
-		nil)
-	e.ifaceTransmitErrors = prometheus.NewDesc(
-		prometheus.BuildFQName(e.namespace, "domain_interface", "transmit_errors_total"),
+		e.constLabels)
+	e.ifaceTransmitErrors = e.newCounterDesc(
+		e.fqName(e.namespace, "interface", "transmit_errors_total"),
 		"Number of packet transmit errors on a network interface.",
 		[]string{"domain", "uuid", "source_bridge", "target_device"},
-		nil)
-	e.ifaceTransmitDrops = prometheus.NewDesc(
-		prometheus.BuildFQName(e.namespace, "domain_interface", "transmit_drops_total"),
+		e.constLabels)
+	e.ifaceTransmitDrops = e.newCounterDesc(
+		e.fqName(e.namespace, "interface", "transmit_drops_total"),
 		"Number of packet transmit drops on a network interface.",
 		[]string{"domain", "uuid", "source_bridge", "target_device"},
-		nil)
+		e.constLabels)
+
+	// storage
+	e.pools = e.newDesc(
+		e.fqName(e.namespace, "", "storage_pools"),
+		"Number of storage pools by backend type.",
+		[]string{"type"},
+		e.constLabels)
+	e.volumeCapacity = e.newDesc(
+		e.fqName(e.namespace, "storage_volume", "capacity_bytes"),
+		"Logical size of the storage volume, in bytes.",
+		[]string{"pool", "volume", "path"},
+		e.constLabels)
+	e.volumeAllocation = e.newDesc(
+		e.fqName(e.namespace, "storage_volume", "allocation_bytes"),
+		"Current allocation of the storage volume, in bytes.",
+		[]string{"pool", "volume", "path"},
+		e.constLabels)
+
+	// network
+	e.networkActive = e.newDesc(
+		e.fqName(e.namespace, "network", "active"),
+		"Whether the virtual network is active.",
+		[]string{"network"},
+		e.constLabels)
+	e.networkPersistent = e.newDesc(
+		e.fqName(e.namespace, "network", "persistent"),
+		"Whether the virtual network is persistent.",
+		[]string{"network"},
+		e.constLabels)
+	e.networkAutostart = e.newDesc(
+		e.fqName(e.namespace, "network", "autostart"),
+		"Whether the virtual network is started when libvirtd starts.",
+		[]string{"network"},
+		e.constLabels)
+	e.networkInfo = e.newDesc(
+		e.fqName(e.namespace, "network", "info"),
+		"Information of the virtual network.",
+		[]string{"network", "bridge", "forward_mode"},
+		e.constLabels)
+	e.dhcpLeases = e.newDesc(
+		e.fqName(e.namespace, "network", "dhcp_leases"),
+		"Number of active DHCP leases of the virtual network.",
+		[]string{"network"},
+		e.constLabels)
+	e.dhcpLeaseInfo = e.newDesc(
+		e.fqName(e.namespace, "network", "dhcp_lease_info"),
+		"Information of an active DHCP lease of the virtual network.",
+		[]string{"network", "mac", "ip", "hostname"},
+		e.constLabels)
+
+	// host interfaces
+	e.hostIfaceActive = e.newDesc(
+		e.fqName(e.namespace, "host_interface", "active"),
+		"Whether the host interface is active.",
+		[]string{"interface", "mac"},
+		e.constLabels)
+
+	// node devices
+	e.nodeDevices = e.newDesc(
+		e.fqName(e.namespace, "", "node_devices"),
+		"Number of node devices by capability.",
+		[]string{"capability"},
+		e.constLabels)
+	e.nodeDevInfo = e.newDesc(
+		e.fqName(e.namespace, "node_device", "info"),
+		"Information of the node device which could be assigned to a domain.",
+		[]string{"device", "capability", "driver", "vendor", "product"},
+		e.constLabels)
+
+	// secrets
+	e.secrets = e.newDesc(
+		e.fqName(e.namespace, "", "secrets"),
+		"Number of secrets by usage type.",
+		[]string{"usage_type"},
+		e.constLabels)
+
+	// nwfilters
+	e.nwfilters = e.newDesc(
+		e.fqName(e.namespace, "", "nwfilters"),
+		"Number of defined network filters.",
+		nil,
+		e.constLabels)
+	e.nwfilterInfo = e.newDesc(
+		e.fqName(e.namespace, "nwfilter", "info"),
+		"Information of the defined network filter.",
+		[]string{"name", "uuid"},
+		e.constLabels)
+
+	// guest agent
+	e.guestAgentUp = e.newDesc(
+		e.fqName(e.namespace, "domain_guest_agent", "up"),
+		"Whether the guest agent of the domain responds to ping.",
+		[]string{"domain", "uuid"},
+		e.constLabels)
+	e.guestOSInfo = e.newDesc(
+		e.fqName(e.namespace, "domain_guest", "os_info"),
+		"Operating system of the domain reported by the guest agent.",
+		[]string{"domain", "uuid", "os_id", "os_name", "os_version", "kernel_release", "machine"},
+		e.constLabels)
+	e.guestFsSize = e.newDesc(
+		e.fqName(e.namespace, "domain_guest", "filesystem_size_bytes"),
+		"Total size of the filesystem inside the domain, in bytes.",
+		[]string{"domain", "uuid", "mountpoint", "fstype", "device"},
+		e.constLabels)
+	e.guestFsUsed = e.newDesc(
+		e.fqName(e.namespace, "domain_guest", "filesystem_used_bytes"),
+		"Used space of the filesystem inside the domain, in bytes.",
+		[]string{"domain", "uuid", "mountpoint", "fstype", "device"},
+		e.constLabels)
+	e.guestClockDrift = e.newDesc(
+		e.fqName(e.namespace, "domain_guest", "clock_drift_seconds"),
+		"Difference between the guest clock and the host clock, in seconds.",
+		[]string{"domain", "uuid"},
+		e.constLabels)
+	e.guestUsers = e.newDesc(
+		e.fqName(e.namespace, "domain_guest", "users"),
+		"Number of users logged in the domain.",
+		[]string{"domain", "uuid"},
+		e.constLabels)
+	e.guestFrozen = e.newDesc(
+		e.fqName(e.namespace, "domain_guest", "filesystems_frozen"),
+		"Whether the filesystems of the domain are frozen.",
+		[]string{"domain", "uuid"},
+		e.constLabels)
+	e.guestDiskInfo = e.newDesc(
+		e.fqName(e.namespace, "domain_guest", "disk_info"),
+		"Mapping from the mountpoint and device inside the domain to the disk of the host.",
+		[]string{"domain", "uuid", "mountpoint", "guest_device", "target_device", "source_file"},
+		e.constLabels)
+	e.guestVCPUs = e.newDesc(
+		e.fqName(e.namespace, "domain_guest", "vcpus"),
+		"Number of virtual CPUs seen by the guest, by state.",
+		[]string{"domain", "uuid", "state"},
+		e.constLabels)
+
+	e.perf = e.newPerfDescs()
+
+	// resctrl monitors
+	e.cacheOccupancy = e.newDesc(
+		e.fqName(e.namespace, "domain_cache", "occupancy_bytes"),
+		"Occupancy of the cache bank by the vcpus of the cache monitor, in bytes.",
+		[]string{"domain", "uuid", "monitor", "vcpus", "bank"},
+		e.constLabels)
+	e.memBandwidth = e.newCounterDesc(
+		e.fqName(e.namespace, "domain_memory_bandwidth", "bytes_total"),
+		"Bytes transferred by the vcpus of the memory bandwidth monitor from and to the memory of the NUMA node.",
+		[]string{"domain", "uuid", "monitor", "vcpus", "node"},
+		e.constLabels)
+	e.memBandwidthLocal = e.newCounterDesc(
+		e.fqName(e.namespace, "domain_memory_bandwidth", "local_bytes_total"),
+		"Bytes transferred by the vcpus of the memory bandwidth monitor from and to the local memory of the NUMA node.",
+		[]string{"domain", "uuid", "monitor", "vcpus", "node"},
+		e.constLabels)
+
+	// dirty rate
+	e.dirtyRate = e.newDesc(
+		e.fqName(e.namespace, "domain_memory", "dirty_rate_bytes_per_second"),
+		"Rate the domain dirtied its memory at, measured after the previous collection, in bytes per second.",
+		[]string{"domain", "uuid"},
+		e.constLabels)
+
+	// launch security
+	e.launchSecurity = e.newDesc(
+		e.fqName(e.namespace, "domain_launch_security", "info"),
+		"Confidential computing technology of the domain, sev, sev-es, sev-snp or none, and its policy.",
+		[]string{"domain", "uuid", "type", "policy"},
+		e.constLabels)
+	e.sevPolicy = e.newDesc(
+		e.fqName(e.namespace, "domain_launch_security", "sev_policy"),
+		"Whether the flag of the SEV policy of the domain is set.",
+		[]string{"domain", "uuid", "flag"},
+		e.constLabels)
+
+	// tpm
+	e.tpms = e.newDesc(
+		e.fqName(e.namespace, "domain", "tpm_devices"),
+		"Number of TPM devices attached to the domain.",
+		[]string{"domain", "uuid"},
+		e.constLabels)
+	e.tpmInfo = e.newDesc(
+		e.fqName(e.namespace, "domain_tpm", "info"),
+		"Information of the TPM device of the domain, the model is e.g. tpm-crb or tpm-tis, the backend emulator or passthrough.",
+		[]string{"domain", "uuid", "model", "backend", "version"},
+		e.constLabels)
+
+	// hostdev
+	e.hostdevs = e.newDesc(
+		e.fqName(e.namespace, "domain", "hostdevs"),
+		"Number of host devices passed through to the domain by type.",
+		[]string{"domain", "uuid", "type"},
+		e.constLabels)
+	e.hostdevInfo = e.newDesc(
+		e.fqName(e.namespace, "domain_hostdev", "info"),
+		"Host device passed through to the domain, the address is of the host, e.g. 0000:03:00.0 of pci devices.",
+		[]string{"domain", "uuid", "type", "address", "vendor_id", "product_id"},
+		e.constLabels)
 
 	return e
 }
@@ -0,0 +1,582 @@
+package exporter_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"testing"
+
+	"github.com/digitalocean/go-libvirt"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/f1shl3gs/libvirt_exporter/exporter"
+	"github.com/f1shl3gs/libvirt_exporter/exporter/libvirttest"
+)
+
+const webXML = `<domain type='kvm' id='1'>
+  <name>web</name>
+  <uuid>6a5d2c3e-8d3b-4b8e-9f4a-2b1c0d9e8f7a</uuid>
+  <devices>
+    <disk type='file' device='disk'>
+      <source file='/var/lib/libvirt/images/web.qcow2'/>
+      <target dev='vda' bus='virtio'/>
+    </disk>
+    <interface type='bridge'>
+      <mac address='52:54:00:12:34:56'/>
+      <source bridge='br0'/>
+      <target dev='vnet0'/>
+    </interface>
+  </devices>
+</domain>`
+
+const poolXML = `<pool type='dir'>
+  <name>default</name>
+  <target>
+    <path>/var/lib/libvirt/images</path>
+  </target>
+</pool>`
+
+// newFake returns a libvirt running the domain web, with a disk vda
+// and an interface vnet0.
+func newFake() *libvirttest.Fake {
+	return &libvirttest.Fake{
+		Domains: []libvirttest.Domain{{
+			Domain: libvirt.Domain{
+				Name: "web",
+				UUID: libvirt.UUID{0x6a, 0x5d, 0x2c, 0x3e, 0x8d, 0x3b, 0x4b, 0x8e, 0x9f, 0x4a, 0x2b, 0x1c, 0x0d, 0x9e, 0x8f, 0x7a},
+				ID:   1,
+			},
+			XML:       webXML,
+			State:     libvirt.DomainRunning,
+			MaxMemory: 2097152,
+			Memory:    1048576,
+			VCPUs:     2,
+			CPUTime:   1500000000,
+			BlockStats: map[string]libvirttest.BlockStats{
+				"vda": {RdReq: 10, RdBytes: 4096, WrReq: 5, WrBytes: 2048},
+			},
+			InterfaceStats: map[string]libvirttest.InterfaceStats{
+				"vnet0": {RxBytes: 100, RxPackets: 1, TxBytes: 200, TxPackets: 2},
+			},
+		}},
+	}
+}
+
+// newExporter returns the exporter of f, registered to a new registry.
+func newExporter(t *testing.T, f *libvirttest.Fake, opts ...exporter.Option) *prometheus.Registry {
+	t.Helper()
+
+	opts = append([]exporter.Option{
+		exporter.WithLibvirt(func() (exporter.Libvirt, error) {
+			return f, nil
+		}),
+	}, opts...)
+
+	reg := prometheus.NewPedanticRegistry()
+	if err := reg.Register(exporter.NewExporter("test:///default", opts...)); err != nil {
+		t.Fatalf("register exporter failed, %s", err)
+	}
+
+	return reg
+}
+
+// typedParam returns the typed parameter of the bulk stats holding v.
+func typedParam(field string, v interface{}) libvirt.TypedParam {
+	var d uint32
+	switch v.(type) {
+	case int32:
+		d = 1
+	case uint32:
+		d = 2
+	case int64:
+		d = 3
+	case uint64:
+		d = 4
+	case string:
+		d = 7
+	}
+
+	return libvirt.TypedParam{Field: field, Value: libvirt.TypedParamValue{D: d, I: v}}
+}
+
+func TestDomainMetrics(t *testing.T) {
+	reg := newExporter(t, newFake())
+
+	expected := `
+# HELP libvirt_up Whether scraping libvirt's metrics was successful.
+# TYPE libvirt_up gauge
+libvirt_up 1
+# HELP libvirt_domains Number of the domain
+# TYPE libvirt_domains gauge
+libvirt_domains 1
+# HELP libvirt_domain_state Code of the domain state
+# TYPE libvirt_domain_state gauge
+libvirt_domain_state{domain="web",state="running",uuid="6a5d2c3e-8d3b-4b8e-9f4a-2b1c0d9e8f7a"} 1
+# HELP libvirt_domain_cpu_time_seconds_total Amount of CPU time used by the domain, in seconds.
+# TYPE libvirt_domain_cpu_time_seconds_total counter
+libvirt_domain_cpu_time_seconds_total{domain="web",uuid="6a5d2c3e-8d3b-4b8e-9f4a-2b1c0d9e8f7a"} 1.5
+# HELP libvirt_domain_virtual_cpus Number of virtual CPUs for the domain.
+# TYPE libvirt_domain_virtual_cpus gauge
+libvirt_domain_virtual_cpus{domain="web",uuid="6a5d2c3e-8d3b-4b8e-9f4a-2b1c0d9e8f7a"} 2
+# HELP libvirt_domain_memory_maximum_bytes Maximum allowed memory of the domain, in bytes.
+# TYPE libvirt_domain_memory_maximum_bytes gauge
+libvirt_domain_memory_maximum_bytes{domain="web",uuid="6a5d2c3e-8d3b-4b8e-9f4a-2b1c0d9e8f7a"} 2.147483648e+09
+# HELP libvirt_domain_memory_usage_bytes Memory usage of the domain, in bytes.
+# TYPE libvirt_domain_memory_usage_bytes gauge
+libvirt_domain_memory_usage_bytes{domain="web",uuid="6a5d2c3e-8d3b-4b8e-9f4a-2b1c0d9e8f7a"} 1.073741824e+09
+# HELP libvirt_block_read_bytes_total Number of bytes read from a block device, in bytes.
+# TYPE libvirt_block_read_bytes_total counter
+libvirt_block_read_bytes_total{domain="web",protocol="",source_file="/var/lib/libvirt/images/web.qcow2",target_device="vda",uuid="6a5d2c3e-8d3b-4b8e-9f4a-2b1c0d9e8f7a",volume=""} 4096
+# HELP libvirt_block_write_requests_total Number of write requests from a block device.
+# TYPE libvirt_block_write_requests_total counter
+libvirt_block_write_requests_total{domain="web",protocol="",source_file="/var/lib/libvirt/images/web.qcow2",target_device="vda",uuid="6a5d2c3e-8d3b-4b8e-9f4a-2b1c0d9e8f7a",volume=""} 5
+# HELP libvirt_interface_receive_bytes_total Number of bytes received on a network interface, in bytes.
+# TYPE libvirt_interface_receive_bytes_total counter
+libvirt_interface_receive_bytes_total{domain="web",source_bridge="br0",target_device="vnet0",uuid="6a5d2c3e-8d3b-4b8e-9f4a-2b1c0d9e8f7a"} 100
+# HELP libvirt_interface_transmit_packets_total Number of packets transmitted on a network interface.
+# TYPE libvirt_interface_transmit_packets_total counter
+libvirt_interface_transmit_packets_total{domain="web",source_bridge="br0",target_device="vnet0",uuid="6a5d2c3e-8d3b-4b8e-9f4a-2b1c0d9e8f7a"} 2
+`
+
+	err := testutil.GatherAndCompare(reg, strings.NewReader(expected),
+		"libvirt_up",
+		"libvirt_domains",
+		"libvirt_domain_state",
+		"libvirt_domain_cpu_time_seconds_total",
+		"libvirt_domain_virtual_cpus",
+		"libvirt_domain_memory_maximum_bytes",
+		"libvirt_domain_memory_usage_bytes",
+		"libvirt_block_read_bytes_total",
+		"libvirt_block_write_requests_total",
+		"libvirt_interface_receive_bytes_total",
+		"libvirt_interface_transmit_packets_total")
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestInactiveDomain(t *testing.T) {
+	f := newFake()
+	f.Domains[0].State = libvirt.DomainShutoff
+
+	reg := newExporter(t, f, exporter.WithInactiveDomains(true))
+
+	expected := `
+# HELP libvirt_domain_state Code of the domain state
+# TYPE libvirt_domain_state gauge
+libvirt_domain_state{domain="web",state="shutoff",uuid="6a5d2c3e-8d3b-4b8e-9f4a-2b1c0d9e8f7a"} 5
+`
+
+	err := testutil.GatherAndCompare(reg, strings.NewReader(expected),
+		"libvirt_domain_state",
+		"libvirt_block_read_bytes_total",
+		"libvirt_interface_receive_bytes_total")
+	if err != nil {
+		t.Error(err)
+	}
+	if n := f.Calls("DomainBlockStats"); n != 0 {
+		t.Errorf("DomainBlockStats called %d times on a shut off domain", n)
+	}
+}
+
+func TestInactiveDomainConfig(t *testing.T) {
+	// the config of shut off domains is in their inactive XML
+	f := newFake()
+	f.Domains[0].State = libvirt.DomainShutoff
+	f.Domains[0].XML = strings.Replace(webXML, "</devices>", `</devices>
+  <launchSecurity type='sev'>
+    <cbitpos>47</cbitpos>
+    <reducedPhysBits>1</reducedPhysBits>
+    <policy>0x0003</policy>
+  </launchSecurity>`, 1)
+	f.Domains[0].XML = strings.Replace(f.Domains[0].XML, "</devices>", `  <tpm model='tpm-crb'>
+      <backend type='emulator' version='2.0'/>
+    </tpm>
+    <hostdev mode='subsystem' type='pci' managed='yes'>
+      <source>
+        <address domain='0x0000' bus='0x03' slot='0x00' function='0x0'/>
+      </source>
+    </hostdev>
+  </devices>`, 1)
+
+	reg := newExporter(t, f, exporter.WithInactiveDomains(true))
+
+	expected := `
+# HELP libvirt_domain_launch_security_info Confidential computing technology of the domain, sev, sev-es, sev-snp or none, and its policy.
+# TYPE libvirt_domain_launch_security_info gauge
+libvirt_domain_launch_security_info{domain="web",policy="0x0003",type="sev",uuid="6a5d2c3e-8d3b-4b8e-9f4a-2b1c0d9e8f7a"} 1
+# HELP libvirt_domain_tpm_devices Number of TPM devices attached to the domain.
+# TYPE libvirt_domain_tpm_devices gauge
+libvirt_domain_tpm_devices{domain="web",uuid="6a5d2c3e-8d3b-4b8e-9f4a-2b1c0d9e8f7a"} 1
+# HELP libvirt_domain_tpm_info Information of the TPM device of the domain, the model is e.g. tpm-crb or tpm-tis, the backend emulator or passthrough.
+# TYPE libvirt_domain_tpm_info gauge
+libvirt_domain_tpm_info{backend="emulator",domain="web",model="tpm-crb",uuid="6a5d2c3e-8d3b-4b8e-9f4a-2b1c0d9e8f7a",version="2.0"} 1
+# HELP libvirt_domain_hostdevs Number of host devices passed through to the domain by type.
+# TYPE libvirt_domain_hostdevs gauge
+libvirt_domain_hostdevs{domain="web",type="pci",uuid="6a5d2c3e-8d3b-4b8e-9f4a-2b1c0d9e8f7a"} 1
+libvirt_domain_hostdevs{domain="web",type="usb",uuid="6a5d2c3e-8d3b-4b8e-9f4a-2b1c0d9e8f7a"} 0
+`
+
+	err := testutil.GatherAndCompare(reg, strings.NewReader(expected),
+		"libvirt_domain_launch_security_info",
+		"libvirt_domain_tpm_devices",
+		"libvirt_domain_tpm_info",
+		"libvirt_domain_hostdevs")
+	if err != nil {
+		t.Error(err)
+	}
+	if n := f.Calls("DomainBlockStats"); n != 0 {
+		t.Errorf("DomainBlockStats called %d times on a shut off domain", n)
+	}
+}
+
+func TestStorageVolumes(t *testing.T) {
+	f := newFake()
+	f.StoragePools = []libvirttest.StoragePool{{
+		StoragePool: libvirt.StoragePool{Name: "default", UUID: libvirt.UUID{1}},
+		XML:         poolXML,
+		Active:      true,
+		Volumes: []libvirttest.StorageVolume{{
+			StorageVol: libvirt.StorageVol{Pool: "default", Name: "web.qcow2", Key: "/var/lib/libvirt/images/web.qcow2"},
+			Path:       "/var/lib/libvirt/images/web.qcow2",
+			Capacity:   10 << 30,
+			Allocation: 1 << 30,
+		}, {
+			// the key of an rbd volume is not a path
+			StorageVol: libvirt.StorageVol{Pool: "default", Name: "data", Key: "rbd/data"},
+			Path:       "rbd:rbd/data",
+			Capacity:   20 << 30,
+			Allocation: 0,
+		}},
+	}}
+
+	reg := newExporter(t, f, exporter.WithStorageVolumes(0))
+
+	expected := `
+# HELP libvirt_storage_pools Number of storage pools by backend type.
+# TYPE libvirt_storage_pools gauge
+libvirt_storage_pools{type="dir"} 1
+# HELP libvirt_storage_volume_capacity_bytes Logical size of the storage volume, in bytes.
+# TYPE libvirt_storage_volume_capacity_bytes gauge
+libvirt_storage_volume_capacity_bytes{path="/var/lib/libvirt/images/web.qcow2",pool="default",volume="web.qcow2"} 1.073741824e+10
+libvirt_storage_volume_capacity_bytes{path="rbd:rbd/data",pool="default",volume="data"} 2.147483648e+10
+`
+
+	err := testutil.GatherAndCompare(reg, strings.NewReader(expected),
+		"libvirt_storage_pools",
+		"libvirt_storage_volume_capacity_bytes")
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestStoragePoolFailure(t *testing.T) {
+	f := newFake()
+	f.StoragePools = []libvirttest.StoragePool{{
+		StoragePool: libvirt.StoragePool{Name: "default", UUID: libvirt.UUID{1}},
+		XML:         poolXML,
+		Active:      true,
+	}}
+	f.Errors = map[string]error{
+		"StoragePoolListAllVolumes": errors.New("pool is being refreshed"),
+	}
+
+	var logs bytes.Buffer
+	reg := newExporter(t, f, exporter.WithStorageVolumes(0), exporter.WithLogger(log.New(&logs, "", 0)))
+
+	// the pools are counted even if their volumes can't be listed
+	expected := `
+# HELP libvirt_storage_pools Number of storage pools by backend type.
+# TYPE libvirt_storage_pools gauge
+libvirt_storage_pools{type="dir"} 1
+`
+
+	err := testutil.GatherAndCompare(reg, strings.NewReader(expected),
+		"libvirt_storage_pools",
+		"libvirt_storage_volume_capacity_bytes")
+	if err != nil {
+		t.Error(err)
+	}
+	if !strings.Contains(logs.String(), "collect volumes of pool default failed") {
+		t.Errorf("volume failure not logged\n%s", logs.String())
+	}
+}
+
+func TestConnectFailure(t *testing.T) {
+	f := newFake()
+	f.Errors = map[string]error{
+		"Connect": errors.New("authentication failed: access denied"),
+	}
+
+	var logs bytes.Buffer
+	reg := newExporter(t, f, exporter.WithLogger(log.New(&logs, "", 0)))
+
+	expected := `
+# HELP libvirt_up Whether scraping libvirt's metrics was successful.
+# TYPE libvirt_up gauge
+libvirt_up 0
+`
+
+	err := testutil.GatherAndCompare(reg, strings.NewReader(expected),
+		"libvirt_up",
+		"libvirt_domain_state")
+	if err != nil {
+		t.Error(err)
+	}
+	if n := f.Calls("ConnectListAllDomains"); n != 0 {
+		t.Errorf("ConnectListAllDomains called %d times without a connection", n)
+	}
+}
+
+func TestCollectorNotSupported(t *testing.T) {
+	f := newFake()
+	f.Errors = map[string]error{
+		"ConnectListAllInterfaces": libvirt.Error{
+			Code:    uint32(libvirt.ErrNoSupport),
+			Message: "this function is not supported by the connection driver: virConnectListAllInterfaces",
+		},
+		"ConnectListAllNetworks": fmt.Errorf("failed to connect to the network driver: %w", errors.New("no such file or directory")),
+	}
+
+	var logs bytes.Buffer
+	reg := newExporter(t, f, exporter.WithLogger(log.New(&logs, "", 0)))
+
+	expected := `
+# HELP libvirt_up Whether scraping libvirt's metrics was successful.
+# TYPE libvirt_up gauge
+libvirt_up 1
+`
+
+	for i := 0; i < 2; i++ {
+		if _, err := reg.Gather(); err != nil {
+			t.Fatalf("gather failed, %s", err)
+		}
+	}
+
+	if n := strings.Count(logs.String(), "collector host-interface not applicable"); n != 1 {
+		t.Errorf("not applicable host-interface logged %d times, want once\n%s", n, logs.String())
+	}
+
+	err := testutil.GatherAndCompare(reg, strings.NewReader(expected), "libvirt_up")
+	if err != nil {
+		t.Error(err)
+	}
+
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("gather failed, %s", err)
+	}
+
+	success := make(map[string]float64)
+	for _, mf := range mfs {
+		if mf.GetName() != "libvirt_exporter_collector_success" {
+			continue
+		}
+		for _, m := range mf.GetMetric() {
+			for _, l := range m.GetLabel() {
+				if l.GetName() == "collector" {
+					success[l.GetValue()] = m.GetGauge().GetValue()
+				}
+			}
+		}
+	}
+
+	if success["host-interface"] != 1 {
+		t.Errorf("host-interface collector success %v, want 1 as not applicable", success["host-interface"])
+	}
+	if success["network"] != 0 {
+		t.Errorf("network collector success %v, want 0 as failed", success["network"])
+	}
+	if success["domain"] != 1 {
+		t.Errorf("domain collector success %v, want 1", success["domain"])
+	}
+}
+
+func TestGroupCollector(t *testing.T) {
+	f := newFake()
+	c := exporter.NewBlockCollector("test:///default", exporter.WithLibvirt(func() (exporter.Libvirt, error) {
+		return f, nil
+	}))
+
+	descs := make(chan *prometheus.Desc)
+	go func() {
+		c.Describe(descs)
+		close(descs)
+	}()
+	for desc := range descs {
+		if !strings.Contains(desc.String(), `fqName: "libvirt_block_`) {
+			t.Errorf("block collector describes %s", desc)
+		}
+	}
+
+	reg := prometheus.NewPedanticRegistry()
+	if err := reg.Register(c); err != nil {
+		t.Fatalf("register block collector failed, %s", err)
+	}
+
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("gather failed, %s", err)
+	}
+	if len(mfs) == 0 {
+		t.Fatal("block collector collected nothing")
+	}
+	for _, mf := range mfs {
+		if !strings.HasPrefix(mf.GetName(), "libvirt_block_") {
+			t.Errorf("block collector collects %s", mf.GetName())
+		}
+	}
+}
+
+func TestMaxDomains(t *testing.T) {
+	// web and web2 are prod, web1 is dev
+	f := newFake()
+	f.Domains[0].XML = strings.Replace(webXML, "<name>web</name>", "<name>web</name>\n  <title>prod</title>", 1)
+	for i, title := range []string{"dev", "prod"} {
+		d := f.Domains[0]
+		d.Name = fmt.Sprintf("web%d", i+1)
+		d.UUID[15] = byte(i + 1)
+		d.XML = strings.Replace(webXML, "<name>web</name>", "<name>"+d.Name+"</name>\n  <title>"+title+"</title>", 1)
+		f.Domains = append(f.Domains, d)
+	}
+
+	for _, tc := range []struct {
+		name     string
+		filter   exporter.DomainFilterConfig
+		expected string
+	}{
+		{
+			name: "no filter",
+			expected: `
+# HELP libvirt_domains Number of the domain
+# TYPE libvirt_domains gauge
+libvirt_domains 3
+# HELP libvirt_domains_matched Number of domains passing the domain filters, the ones over the domain limit are matched by name and UUID only.
+# TYPE libvirt_domains_matched gauge
+libvirt_domains_matched 3
+# HELP libvirt_domains_skipped Number of domains not collected because of the domain limit.
+# TYPE libvirt_domains_skipped gauge
+libvirt_domains_skipped 2
+# HELP libvirt_domain_state Code of the domain state
+# TYPE libvirt_domain_state gauge
+libvirt_domain_state{domain="web",state="running",uuid="6a5d2c3e-8d3b-4b8e-9f4a-2b1c0d9e8f7a"} 1
+`,
+		},
+		{
+			// the domains excluded by the name aren't counted as skipped
+			name:   "excluded by name",
+			filter: exporter.DomainFilterConfig{Exclude: "web1"},
+			expected: `
+# HELP libvirt_domains Number of the domain
+# TYPE libvirt_domains gauge
+libvirt_domains 3
+# HELP libvirt_domains_matched Number of domains passing the domain filters, the ones over the domain limit are matched by name and UUID only.
+# TYPE libvirt_domains_matched gauge
+libvirt_domains_matched 2
+# HELP libvirt_domains_skipped Number of domains not collected because of the domain limit.
+# TYPE libvirt_domains_skipped gauge
+libvirt_domains_skipped 1
+# HELP libvirt_domain_state Code of the domain state
+# TYPE libvirt_domain_state gauge
+libvirt_domain_state{domain="web",state="running",uuid="6a5d2c3e-8d3b-4b8e-9f4a-2b1c0d9e8f7a"} 1
+`,
+		},
+		{
+			// the domains excluded by the title don't use up the limit
+			name:   "excluded by title",
+			filter: exporter.DomainFilterConfig{Title: "dev"},
+			expected: `
+# HELP libvirt_domains Number of the domain
+# TYPE libvirt_domains gauge
+libvirt_domains 3
+# HELP libvirt_domains_matched Number of domains passing the domain filters, the ones over the domain limit are matched by name and UUID only.
+# TYPE libvirt_domains_matched gauge
+libvirt_domains_matched 2
+# HELP libvirt_domains_skipped Number of domains not collected because of the domain limit.
+# TYPE libvirt_domains_skipped gauge
+libvirt_domains_skipped 1
+# HELP libvirt_domain_state Code of the domain state
+# TYPE libvirt_domain_state gauge
+libvirt_domain_state{domain="web1",state="running",uuid="6a5d2c3e-8d3b-4b8e-9f4a-2b1c0d9e8f01"} 1
+`,
+		},
+		{
+			// the XML of the domains over the limit isn't read, so
+			// they are skipped whatever their title
+			name:   "skipped by title",
+			filter: exporter.DomainFilterConfig{Title: "prod"},
+			expected: `
+# HELP libvirt_domains Number of the domain
+# TYPE libvirt_domains gauge
+libvirt_domains 3
+# HELP libvirt_domains_matched Number of domains passing the domain filters, the ones over the domain limit are matched by name and UUID only.
+# TYPE libvirt_domains_matched gauge
+libvirt_domains_matched 3
+# HELP libvirt_domains_skipped Number of domains not collected because of the domain limit.
+# TYPE libvirt_domains_skipped gauge
+libvirt_domains_skipped 2
+# HELP libvirt_domain_state Code of the domain state
+# TYPE libvirt_domain_state gauge
+libvirt_domain_state{domain="web",state="running",uuid="6a5d2c3e-8d3b-4b8e-9f4a-2b1c0d9e8f7a"} 1
+`,
+		},
+	} {
+		filter, err := tc.filter.Compile()
+		if err != nil {
+			t.Fatalf("%s: compile failed, %s", tc.name, err)
+		}
+
+		reg := newExporter(t, f, exporter.WithMaxDomains(1), exporter.WithDomainFilter(filter))
+
+		err = testutil.GatherAndCompare(reg, strings.NewReader(tc.expected),
+			"libvirt_domains",
+			"libvirt_domains_matched",
+			"libvirt_domains_skipped",
+			"libvirt_domain_state")
+		if err != nil {
+			t.Errorf("%s: %s", tc.name, err)
+		}
+	}
+}
+
+func TestNetworkFailure(t *testing.T) {
+	f := newFake()
+	f.Networks = []libvirttest.Network{
+		{
+			Network:   libvirt.Network{Name: "default", UUID: libvirt.UUID{1}},
+			XML:       `<network><name>default</name><forward mode='nat'/><bridge name='virbr0'/></network>`,
+			Active:    true,
+			Autostart: true,
+		},
+		{
+			Network:   libvirt.Network{Name: "scratch", UUID: libvirt.UUID{2}},
+			Undefined: true,
+		},
+	}
+
+	var logs bytes.Buffer
+	reg := newExporter(t, f, exporter.WithLogger(log.New(&logs, "", 0)))
+
+	// the network undefined since listed doesn't hide the other
+	expected := `
+# HELP libvirt_network_active Whether the virtual network is active.
+# TYPE libvirt_network_active gauge
+libvirt_network_active{network="default"} 1
+# HELP libvirt_network_info Information of the virtual network.
+# TYPE libvirt_network_info gauge
+libvirt_network_info{bridge="virbr0",forward_mode="nat",network="default"} 1
+`
+
+	err := testutil.GatherAndCompare(reg, strings.NewReader(expected),
+		"libvirt_network_active",
+		"libvirt_network_info")
+	if err != nil {
+		t.Error(err)
+	}
+	if !strings.Contains(logs.String(), "check network scratch failed") {
+		t.Errorf("network failure not logged\n%s", logs.String())
+	}
+}
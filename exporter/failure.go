@@ -0,0 +1,80 @@
+package exporter
+
+import (
+	"encoding/xml"
+	"strings"
+
+	"github.com/digitalocean/go-libvirt"
+)
+
+// reasons of failed scrapes, the label values of scrape_failures_total
+const (
+	reasonDial    = "dial"
+	reasonAuth    = "auth"
+	reasonRPC     = "rpc"
+	reasonXML     = "xml"
+	reasonTimeout = "timeout"
+)
+
+// failure tags an error with the reason of the scrape failure, the
+// errors of the RPCs and XML parsing are told by their types.
+type failure struct {
+	reason string
+	err    error
+}
+
+func (f *failure) Error() string { return f.err.Error() }
+func (f *failure) Cause() error  { return f.err }
+
+// failureReason classifies the error of a scrape by walking the chain of
+// wrapped errors.
+func failureReason(err error) string {
+	for err != nil {
+		switch e := err.(type) {
+		case *failure:
+			return e.reason
+		case *xml.SyntaxError, xml.UnmarshalError, *xml.UnsupportedTypeError:
+			return reasonXML
+		case interface{ Timeout() bool }:
+			if e.Timeout() {
+				return reasonTimeout
+			}
+		}
+
+		cause, ok := err.(interface{ Cause() error })
+		if !ok {
+			break
+		}
+		err = cause.Cause()
+	}
+
+	// go-libvirt flattens the errors of the connection into strings
+	if err != nil && strings.Contains(err.Error(), "i/o timeout") {
+		return reasonTimeout
+	}
+
+	return reasonRPC
+}
+
+// notSupported reports whether err tells the hypervisor has no driver for
+// the call, e.g. the interface driver of hosts without netcf, so the
+// collector doesn't apply to it rather than failed.
+func notSupported(err error) bool {
+	for err != nil {
+		if e, ok := err.(libvirt.Error); ok {
+			switch libvirt.ErrorNumber(e.Code) {
+			case libvirt.ErrNoSupport, libvirt.ErrOperationUnsupported, libvirt.ErrNoConnect:
+				return true
+			}
+			return false
+		}
+
+		cause, ok := err.(interface{ Cause() error })
+		if !ok {
+			break
+		}
+		err = cause.Cause()
+	}
+
+	return false
+}
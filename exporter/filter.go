@@ -0,0 +1,176 @@
+package exporter
+
+import (
+	"bytes"
+	"encoding/xml"
+	"regexp"
+
+	"github.com/pkg/errors"
+)
+
+// DomainFilterConfig is the uncompiled form of DomainFilter, all patterns
+// are anchored at both ends, empty patterns are ignored.
+type DomainFilterConfig struct {
+	Include   string `yaml:"include"`
+	Exclude   string `yaml:"exclude"`
+	MatchUUID bool   `yaml:"match_uuid"`
+
+	// Title matches the title of the domain
+	Title string `yaml:"title"`
+
+	// Metadata maps element or attribute names in the domain metadata
+	// to the pattern of their values, namespaces are ignored, e.g.
+	// env: prod matches <app:env>prod</app:env>
+	Metadata map[string]string `yaml:"metadata"`
+}
+
+func compileAnchored(pattern string) (*regexp.Regexp, error) {
+	if pattern == "" {
+		return nil, nil
+	}
+
+	return regexp.Compile("^(?:" + pattern + ")$")
+}
+
+// Compile compiles the patterns into a DomainFilter.
+func (c DomainFilterConfig) Compile() (DomainFilter, error) {
+	var (
+		filter = DomainFilter{MatchUUID: c.MatchUUID}
+		err    error
+	)
+
+	if filter.Include, err = compileAnchored(c.Include); err != nil {
+		return filter, errors.Wrap(err, "invalid include pattern")
+	}
+
+	if filter.Exclude, err = compileAnchored(c.Exclude); err != nil {
+		return filter, errors.Wrap(err, "invalid exclude pattern")
+	}
+
+	if filter.Title, err = compileAnchored(c.Title); err != nil {
+		return filter, errors.Wrap(err, "invalid title pattern")
+	}
+
+	for key, pattern := range c.Metadata {
+		re, err := compileAnchored(pattern)
+		if err != nil {
+			return filter, errors.Wrapf(err, "invalid metadata pattern of %s", key)
+		}
+
+		if re == nil {
+			continue
+		}
+
+		if filter.Metadata == nil {
+			filter.Metadata = make(map[string]*regexp.Regexp)
+		}
+		filter.Metadata[key] = re
+	}
+
+	return filter, nil
+}
+
+// DomainFilter selects the domains to collect by their name, and optionally
+// by their UUID. A nil regexp matches everything for Include and nothing
+// for Exclude.
+//
+// Title and Metadata are matched against the XML of the domain, so
+// the XML is fetched even for domains filtered out by them.
+type DomainFilter struct {
+	Include   *regexp.Regexp
+	Exclude   *regexp.Regexp
+	MatchUUID bool
+
+	Title    *regexp.Regexp
+	Metadata map[string]*regexp.Regexp
+}
+
+func (f DomainFilter) match(re *regexp.Regexp, name, uuid string) bool {
+	if re.MatchString(name) {
+		return true
+	}
+
+	return f.MatchUUID && re.MatchString(uuid)
+}
+
+// Match reports whether the domain should be collected.
+func (f DomainFilter) Match(name, uuid string) bool {
+	if f.Include != nil && !f.match(f.Include, name, uuid) {
+		return false
+	}
+
+	if f.Exclude != nil && f.match(f.Exclude, name, uuid) {
+		return false
+	}
+
+	return true
+}
+
+// needsXML reports whether MatchXML matches anything, i.e. the domain
+// XML is needed to tell whether the domain is collected.
+func (f DomainFilter) needsXML() bool {
+	return f.Title != nil || len(f.Metadata) != 0
+}
+
+// MatchXML reports whether the domain should be collected according to
+// its title and metadata, all of them must match.
+func (f DomainFilter) MatchXML(domain *Domain) bool {
+	if f.Title != nil && !f.Title.MatchString(domain.Title) {
+		return false
+	}
+
+	for key, re := range f.Metadata {
+		matched := false
+		for _, value := range metadataValues(domain.Metadata.InnerXML, key) {
+			if re.MatchString(value) {
+				matched = true
+				break
+			}
+		}
+
+		if !matched {
+			return false
+		}
+	}
+
+	return true
+}
+
+// metadataValues returns the text of the elements and the values of the
+// attributes named key in the metadata, namespaces are ignored.
+func metadataValues(inner []byte, key string) []string {
+	var (
+		values  []string
+		text    *bytes.Buffer
+		decoder = xml.NewDecoder(bytes.NewReader(inner))
+	)
+
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return values
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			for _, attr := range t.Attr {
+				if attr.Name.Local == key {
+					values = append(values, attr.Value)
+				}
+			}
+
+			if t.Name.Local == key {
+				text = &bytes.Buffer{}
+			}
+		case xml.CharData:
+			if text != nil {
+				text.Write(t)
+			}
+		case xml.EndElement:
+			if t.Name.Local == key && text != nil {
+				values = append(values, string(bytes.TrimSpace(text.Bytes())))
+				text = nil
+			}
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package exporter_test
+
+import (
+	"testing"
+
+	"github.com/f1shl3gs/libvirt_exporter/exporter"
+)
+
+func TestDomainFilter(t *testing.T) {
+	const uuid = "6a5d2c3e-8d3b-4b8e-9f4a-2b1c0d9e8f7a"
+
+	for _, tc := range []struct {
+		name     string
+		config   exporter.DomainFilterConfig
+		domain   string
+		expected bool
+	}{
+		{
+			name:     "no patterns",
+			domain:   "web",
+			expected: true,
+		},
+		{
+			name:     "included",
+			config:   exporter.DomainFilterConfig{Include: "web|db"},
+			domain:   "web",
+			expected: true,
+		},
+		{
+			name:     "not included",
+			config:   exporter.DomainFilterConfig{Include: "db"},
+			domain:   "web",
+			expected: false,
+		},
+		{
+			// patterns are anchored at both ends
+			name:     "included partially",
+			config:   exporter.DomainFilterConfig{Include: "web"},
+			domain:   "web2",
+			expected: false,
+		},
+		{
+			name:     "excluded",
+			config:   exporter.DomainFilterConfig{Exclude: "web.*"},
+			domain:   "web",
+			expected: false,
+		},
+		{
+			name:     "not excluded",
+			config:   exporter.DomainFilterConfig{Exclude: "test-.*"},
+			domain:   "web",
+			expected: true,
+		},
+		{
+			// the exclude pattern wins over the include one
+			name:     "included and excluded",
+			config:   exporter.DomainFilterConfig{Include: "web.*", Exclude: "web"},
+			domain:   "web",
+			expected: false,
+		},
+		{
+			name:     "uuid not matched",
+			config:   exporter.DomainFilterConfig{Include: uuid},
+			domain:   "web",
+			expected: false,
+		},
+		{
+			name:     "included by uuid",
+			config:   exporter.DomainFilterConfig{Include: uuid, MatchUUID: true},
+			domain:   "web",
+			expected: true,
+		},
+		{
+			name:     "excluded by uuid",
+			config:   exporter.DomainFilterConfig{Include: "web", Exclude: "6a5d2c3e-.*", MatchUUID: true},
+			domain:   "web",
+			expected: false,
+		},
+	} {
+		filter, err := tc.config.Compile()
+		if err != nil {
+			t.Fatalf("%s: compile failed, %s", tc.name, err)
+		}
+
+		if matched := filter.Match(tc.domain, uuid); matched != tc.expected {
+			t.Errorf("%s: expected %v, got %v", tc.name, tc.expected, matched)
+		}
+	}
+}
+
+func TestDomainFilterInvalid(t *testing.T) {
+	for _, config := range []exporter.DomainFilterConfig{
+		{Include: "web("},
+		{Exclude: "[db"},
+		{Title: "*"},
+		{Metadata: map[string]string{"env": "prod("}},
+	} {
+		if _, err := config.Compile(); err == nil {
+			t.Errorf("expected %+v to fail", config)
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package exporter
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// groupCollector exposes the metrics of a single metric group, without
+// the up, scrape and exporter metrics, so several groups could be
+// registered into the same registry. Every group collects over its own
+// connection.
+type groupCollector struct {
+	e     *Exporter
+	group string
+}
+
+func newGroupCollector(group, uri string, opts ...Option) *groupCollector {
+	return &groupCollector{
+		e:     NewExporter(uri, opts...),
+		group: group,
+	}
+}
+
+func (g *groupCollector) Describe(ch chan<- *prometheus.Desc) {
+	// the labels of domains are unknown up front, see WithLabelFunc
+	if g.e.labelFunc != nil {
+		return
+	}
+
+	g.e.instances[g.group].Describe(ch)
+}
+
+func (g *groupCollector) Collect(ch chan<- prometheus.Metric) {
+	descs := make(map[*prometheus.Desc]bool)
+	described := make(chan *prometheus.Desc)
+	go func() {
+		g.e.instances[g.group].Describe(described)
+		close(described)
+	}()
+	for desc := range described {
+		descs[desc] = true
+	}
+
+	sc := g.e.scope()
+	sc.collectors = map[string]bool{g.group: true}
+
+	metrics := make(chan prometheus.Metric)
+	go func() {
+		g.e.collectScope(metrics, sc)
+		close(metrics)
+	}()
+	for metric := range metrics {
+		if descs[metric.Desc()] {
+			ch <- metric
+		}
+	}
+}
+
+// NewDomainInfoCollector collects the state, memory, vCPUs and CPU time
+// of domains.
+func NewDomainInfoCollector(uri string, opts ...Option) prometheus.Collector {
+	return newGroupCollector("domain", uri, opts...)
+}
+
+// NewMemoryCollector collects the memory statistics of domains.
+func NewMemoryCollector(uri string, opts ...Option) prometheus.Collector {
+	return newGroupCollector("memory", uri, opts...)
+}
+
+// NewBlockCollector collects the statistics of the disks of domains.
+func NewBlockCollector(uri string, opts ...Option) prometheus.Collector {
+	return newGroupCollector("block", uri, opts...)
+}
+
+// NewInterfaceCollector collects the statistics of the network
+// interfaces of domains.
+func NewInterfaceCollector(uri string, opts ...Option) prometheus.Collector {
+	return newGroupCollector("interface", uri, opts...)
+}
+
+// NewGuestAgentCollector collects the metrics reported by the guest
+// agents of running domains, see WithGuestAgent and WithGuestAgentBudget
+// for its limits.
+func NewGuestAgentCollector(uri string, opts ...Option) prometheus.Collector {
+	return newGroupCollector("guest-agent", uri, opts...)
+}
+
+// NewPerfCollector collects the perf events enabled on domains, e.g. CPU
+// cycles and cache misses.
+func NewPerfCollector(uri string, opts ...Option) prometheus.Collector {
+	return newGroupCollector("perf", uri, opts...)
+}
+
+// NewResctrlCollector collects the cache occupancy and memory bandwidth
+// of domains monitored by <cachetune> and <memorytune>.
+func NewResctrlCollector(uri string, opts ...Option) prometheus.Collector {
+	return newGroupCollector("resctrl", uri, opts...)
+}
+
+// NewDirtyRateCollector collects the dirty page rate of running domains,
+// see WithDirtyRate for how long it's measured.
+func NewDirtyRateCollector(uri string, opts ...Option) prometheus.Collector {
+	return newGroupCollector("dirty-rate", uri, opts...)
+}
+
+// NewLaunchSecurityCollector collects whether domains run encrypted by AMD
+// SEV, SEV-ES or SEV-SNP, and their SEV policy.
+func NewLaunchSecurityCollector(uri string, opts ...Option) prometheus.Collector {
+	return newGroupCollector("launch-security", uri, opts...)
+}
+
+// NewTPMCollector collects the virtual TPMs of domains.
+func NewTPMCollector(uri string, opts ...Option) prometheus.Collector {
+	return newGroupCollector("tpm", uri, opts...)
+}
+
+// NewHostdevCollector collects the host devices passed through to
+// domains.
+func NewHostdevCollector(uri string, opts ...Option) prometheus.Collector {
+	return newGroupCollector("hostdev", uri, opts...)
+}
+
+// NewStorageCollector collects the storage pools, and the volumes if
+// WithStorageVolumes is given.
+func NewStorageCollector(uri string, opts ...Option) prometheus.Collector {
+	return newGroupCollector("storage", uri, opts...)
+}
+
+// NewNetworkCollector collects the virtual networks and their DHCP
+// leases.
+func NewNetworkCollector(uri string, opts ...Option) prometheus.Collector {
+	return newGroupCollector("network", uri, opts...)
+}
+
+// NewHostInterfaceCollector collects the interfaces of the hypervisor.
+func NewHostInterfaceCollector(uri string, opts ...Option) prometheus.Collector {
+	return newGroupCollector("host-interface", uri, opts...)
+}
+
+// NewNodeDeviceCollector collects the devices of the hypervisor.
+func NewNodeDeviceCollector(uri string, opts ...Option) prometheus.Collector {
+	return newGroupCollector("nodedev", uri, opts...)
+}
+
+// NewSecretCollector collects the secrets by usage type.
+func NewSecretCollector(uri string, opts ...Option) prometheus.Collector {
+	return newGroupCollector("secret", uri, opts...)
+}
+
+// NewNwfilterCollector collects the network filters.
+func NewNwfilterCollector(uri string, opts ...Option) prometheus.Collector {
+	return newGroupCollector("nwfilter", uri, opts...)
+}
@@ -0,0 +1,276 @@
+package exporter
+
+import (
+	"encoding/xml"
+	"time"
+
+	"github.com/digitalocean/go-libvirt"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type hostInterfaceCollector struct{ e *Exporter }
+
+func (c hostInterfaceCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.e.hostIfaceActive
+}
+
+func (c hostInterfaceCollector) Collect(ch chan<- prometheus.Metric, cli *client) error {
+	return errors.Wrap(c.e.collectHostInterfaces(ch, cli), "failed to collect host interfaces")
+}
+
+type nodeDeviceCollector struct{ e *Exporter }
+
+func (c nodeDeviceCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.e.nodeDevices
+	if c.e.deviceInfo {
+		ch <- c.e.nodeDevInfo
+	}
+}
+
+func (c nodeDeviceCollector) Collect(ch chan<- prometheus.Metric, cli *client) error {
+	return errors.Wrap(c.e.collectNodeDevices(ch, cli), "failed to collect node devices")
+}
+
+type secretCollector struct{ e *Exporter }
+
+func (c secretCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.e.secrets
+}
+
+func (c secretCollector) Collect(ch chan<- prometheus.Metric, cli *client) error {
+	return errors.Wrap(c.e.collectSecrets(ch, cli), "failed to collect secrets")
+}
+
+type nwfilterCollector struct{ e *Exporter }
+
+func (c nwfilterCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.e.nwfilters
+	ch <- c.e.nwfilterInfo
+}
+
+func (c nwfilterCollector) Collect(ch chan<- prometheus.Metric, cli *client) error {
+	return errors.Wrap(c.e.collectNwfilters(ch, cli), "failed to collect nwfilters")
+}
+
+// collectHostInterfaces reports the physical, bridge and bond interfaces of
+// the hypervisor known by libvirt.
+func (e *Exporter) collectHostInterfaces(ch chan<- prometheus.Metric, cli *client) error {
+	ifaces, _, err := cli.ConnectListAllInterfaces(1, 0)
+	if err != nil {
+		return errors.Wrap(err, "failed to list host interfaces")
+	}
+
+	for _, iface := range ifaces {
+		active, err := cli.InterfaceIsActive(iface)
+		if err != nil {
+			return errors.Wrapf(err, "failed to check host interface %s", iface.Name)
+		}
+
+		ch <- e.constMetric(
+			e.hostIfaceActive,
+			prometheus.GaugeValue,
+			float64(active),
+			iface.Name, iface.Mac)
+	}
+
+	return nil
+}
+
+// nodeDeviceCaps are the capabilities the node devices are counted by.
+// The devices are listed by capability, an RPC per capability rather
+// than per device, passthrough ones could be assigned to a domain.
+var nodeDeviceCaps = []struct {
+	name        string
+	flag        libvirt.ConnectListAllNodeDeviceFlags
+	passthrough bool
+}{
+	{"pci", libvirt.ConnectListNodeDevicesCapPciDev, true},
+	{"usb_device", libvirt.ConnectListNodeDevicesCapUsbDev, true},
+	{"net", libvirt.ConnectListNodeDevicesCapNet, false},
+	{"scsi_host", libvirt.ConnectListNodeDevicesCapScsiHost, false},
+	{"storage", libvirt.ConnectListNodeDevicesCapStorage, false},
+	{"mdev", libvirt.ConnectListNodeDevicesCapMdev, true},
+	{"vdpa", libvirt.ConnectListNodeDevicesCapVdpa, false},
+}
+
+// vfioDriver is the driver of the PCI devices ready to be assigned
+const vfioDriver = "vfio-pci"
+
+// cachedNodeDevice is what the XML of a node device tells
+type cachedNodeDevice struct {
+	driver  string
+	vendor  string
+	product string
+	read    time.Time
+}
+
+// the XML of a node device is read again after so long, a device rebound
+// to another driver, e.g. vfio-pci, shows up within that time
+const nodeDeviceRefresh = 10 * time.Minute
+
+// nodeDevice reads the XML of the node device, unless it was read lately.
+// The devices rarely change, while reading the XML of every PCI device of
+// a big host on every scrape costs an RPC each.
+func (e *Exporter) nodeDevice(cli *client, name string, now time.Time) (cachedNodeDevice, error) {
+	if v, ok := e.nodeDevs.Load(name); ok {
+		if device := v.(cachedNodeDevice); now.Sub(device.read) < nodeDeviceRefresh {
+			Stats.Add(statCacheHits, 1)
+			return device, nil
+		}
+	}
+
+	Stats.Add(statCacheMisses, 1)
+
+	xmlDesc, err := cli.NodeDeviceGetXMLDesc(name, 0)
+	if err != nil {
+		return cachedNodeDevice{}, errors.Wrapf(err, "failed to get xml desc of node device %s", name)
+	}
+
+	var schema NodeDevice
+	if err = xml.Unmarshal([]byte(xmlDesc), &schema); err != nil {
+		return cachedNodeDevice{}, errors.Wrapf(err, "failed to unmarshal node device %s", name)
+	}
+
+	device := cachedNodeDevice{
+		driver:  schema.Driver.Name,
+		vendor:  schema.Capability.Vendor.Name,
+		product: schema.Capability.Product.Name,
+		read:    now,
+	}
+	e.nodeDevs.Store(name, device)
+
+	return device, nil
+}
+
+func (e *Exporter) collectNodeDevices(ch chan<- prometheus.Metric, cli *client) error {
+	now := time.Now()
+	seen := make(map[string]bool)
+	vfio := 0
+	for _, c := range nodeDeviceCaps {
+		devices, _, err := cli.ConnectListAllNodeDevices(1, uint32(c.flag))
+		if err != nil {
+			return errors.Wrapf(err, "failed to list %s node devices", c.name)
+		}
+
+		ch <- e.constMetric(
+			e.nodeDevices,
+			prometheus.GaugeValue,
+			float64(len(devices)),
+			c.name)
+
+		// only the XML tells the driver, it's read for the PCI devices to
+		// count the ones bound to vfio, and for the other passthrough ones
+		// along with the device info only
+		pci := c.flag == libvirt.ConnectListNodeDevicesCapPciDev
+		if !pci && !(e.deviceInfo && c.passthrough) {
+			continue
+		}
+
+		// a device unplugged since listed is skipped, any other device
+		// failing is logged, neither hides the others
+		for _, d := range devices {
+			device, err := e.nodeDevice(cli, d.Name, now)
+			if err != nil {
+				if lerr, ok := errors.Cause(err).(libvirt.Error); !ok || libvirt.ErrorNumber(lerr.Code) != libvirt.ErrNoNodeDevice {
+					e.logger.Printf("read node device %s failed, %s\n", d.Name, err)
+				}
+				continue
+			}
+			seen[d.Name] = true
+
+			if pci && device.driver == vfioDriver {
+				vfio++
+			}
+
+			if !e.deviceInfo {
+				continue
+			}
+
+			ch <- e.constMetric(
+				e.nodeDevInfo,
+				prometheus.GaugeValue,
+				1,
+				d.Name,
+				c.name,
+				device.driver,
+				device.vendor,
+				device.product)
+		}
+	}
+
+	ch <- e.constMetric(
+		e.nodeDevices,
+		prometheus.GaugeValue,
+		float64(vfio),
+		"vfio")
+
+	// the devices gone are forgotten
+	e.nodeDevs.Range(func(name, _ interface{}) bool {
+		if !seen[name.(string)] {
+			e.nodeDevs.Delete(name)
+		}
+		return true
+	})
+
+	return nil
+}
+
+// secretUsageTypes is indexed by virSecretUsageType
+var secretUsageTypes = []string{
+	"none",
+	"volume",
+	"ceph",
+	"iscsi",
+	"tls",
+	"vtpm",
+}
+
+func (e *Exporter) collectSecrets(ch chan<- prometheus.Metric, cli *client) error {
+	secrets, _, err := cli.ConnectListAllSecrets(1, 0)
+	if err != nil {
+		return errors.Wrap(err, "failed to list secrets")
+	}
+
+	// report every known usage type, so a missing secret shows up as 0
+	counts := make([]int, len(secretUsageTypes))
+	for _, secret := range secrets {
+		if secret.UsageType < 0 || int(secret.UsageType) >= len(counts) {
+			continue
+		}
+
+		counts[secret.UsageType]++
+	}
+
+	for i, n := range counts {
+		ch <- e.constMetric(
+			e.secrets,
+			prometheus.GaugeValue,
+			float64(n),
+			secretUsageTypes[i])
+	}
+
+	return nil
+}
+
+func (e *Exporter) collectNwfilters(ch chan<- prometheus.Metric, cli *client) error {
+	filters, _, err := cli.ConnectListAllNwfilters(1, 0)
+	if err != nil {
+		return errors.Wrap(err, "failed to list nwfilters")
+	}
+
+	ch <- e.constMetric(
+		e.nwfilters,
+		prometheus.GaugeValue,
+		float64(len(filters)))
+
+	for _, filter := range filters {
+		ch <- e.constMetric(
+			e.nwfilterInfo,
+			prometheus.GaugeValue,
+			1,
+			filter.Name, uuidConvert(filter.UUID))
+	}
+
+	return nil
+}
@@ -0,0 +1,149 @@
+package exporter_test
+
+import (
+	"bytes"
+	"expvar"
+	"log"
+	"strings"
+	"testing"
+
+	"github.com/digitalocean/go-libvirt"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/f1shl3gs/libvirt_exporter/exporter"
+	"github.com/f1shl3gs/libvirt_exporter/exporter/libvirttest"
+)
+
+const (
+	gpuXML = `<device>
+  <name>pci_0000_3b_00_0</name>
+  <driver><name>vfio-pci</name></driver>
+  <capability type='pci'>
+    <product id='0x1eb8'>TU104GL [Tesla T4]</product>
+    <vendor id='0x10de'>NVIDIA Corporation</vendor>
+  </capability>
+</device>`
+
+	nvmeXML = `<device>
+  <name>pci_0000_5e_00_0</name>
+  <driver><name>nvme</name></driver>
+  <capability type='pci'>
+    <product id='0xa808'>NVMe SSD Controller SM981/PM981/PM983</product>
+    <vendor id='0x144d'>Samsung Electronics Co Ltd</vendor>
+  </capability>
+</device>`
+)
+
+func TestNodeDevices(t *testing.T) {
+	f := newFake()
+	f.NodeDevices = []libvirttest.NodeDevice{
+		{NodeDevice: libvirt.NodeDevice{Name: "pci_0000_3b_00_0"}, XML: gpuXML, Caps: []string{"pci"}},
+		{NodeDevice: libvirt.NodeDevice{Name: "pci_0000_5e_00_0"}, XML: nvmeXML, Caps: []string{"pci"}},
+		{NodeDevice: libvirt.NodeDevice{Name: "usb_1_1"}, Caps: []string{"usb_device"}},
+		{NodeDevice: libvirt.NodeDevice{Name: "net_eth0_52_54_00_12_34_56"}, Caps: []string{"net"}},
+	}
+
+	hits, misses := stat("cache_hits"), stat("cache_misses")
+
+	// the devices bound to vfio-pci are counted without the device info
+	reg := newExporter(t, f)
+
+	expected := `
+# HELP libvirt_node_devices Number of node devices by capability.
+# TYPE libvirt_node_devices gauge
+libvirt_node_devices{capability="mdev"} 0
+libvirt_node_devices{capability="net"} 1
+libvirt_node_devices{capability="pci"} 2
+libvirt_node_devices{capability="scsi_host"} 0
+libvirt_node_devices{capability="storage"} 0
+libvirt_node_devices{capability="usb_device"} 1
+libvirt_node_devices{capability="vdpa"} 0
+libvirt_node_devices{capability="vfio"} 1
+`
+
+	err := testutil.GatherAndCompare(reg, strings.NewReader(expected),
+		"libvirt_node_devices",
+		"libvirt_node_device_info")
+	if err != nil {
+		t.Error(err)
+	}
+
+	// only the XML of the PCI devices is read, once for several scrapes
+	if _, err = reg.Gather(); err != nil {
+		t.Fatalf("gather failed, %s", err)
+	}
+	if n := f.Calls("NodeDeviceGetXMLDesc"); n != 2 {
+		t.Errorf("NodeDeviceGetXMLDesc called %d times, want 2", n)
+	}
+	if n := stat("cache_misses") - misses; n != 2 {
+		t.Errorf("%d cache misses, want 2", n)
+	}
+	if n := stat("cache_hits") - hits; n != 2 {
+		t.Errorf("%d cache hits, want 2", n)
+	}
+}
+
+// stat returns the value of the counter of exporter.Stats.
+func stat(name string) int64 {
+	v, _ := exporter.Stats.Get(name).(*expvar.Int)
+	if v == nil {
+		return 0
+	}
+
+	return v.Value()
+}
+
+func TestNodeDeviceFailure(t *testing.T) {
+	f := newFake()
+	f.NodeDevices = []libvirttest.NodeDevice{
+		{NodeDevice: libvirt.NodeDevice{Name: "pci_0000_3b_00_0"}, XML: gpuXML, Caps: []string{"pci"}},
+		{NodeDevice: libvirt.NodeDevice{Name: "pci_0000_5e_00_0"}, Caps: []string{"pci"}, Undefined: true},
+		{NodeDevice: libvirt.NodeDevice{Name: "pci_0000_af_00_0"}, XML: "<device>", Caps: []string{"pci"}},
+	}
+
+	var logs bytes.Buffer
+	reg := newExporter(t, f, exporter.WithLogger(log.New(&logs, "", 0)))
+
+	// the unplugged device is skipped quietly, the broken one is logged,
+	// neither fails the collector
+	expected := `
+# HELP libvirt_node_devices Number of node devices by capability.
+# TYPE libvirt_node_devices gauge
+libvirt_node_devices{capability="mdev"} 0
+libvirt_node_devices{capability="net"} 0
+libvirt_node_devices{capability="pci"} 3
+libvirt_node_devices{capability="scsi_host"} 0
+libvirt_node_devices{capability="storage"} 0
+libvirt_node_devices{capability="usb_device"} 0
+libvirt_node_devices{capability="vdpa"} 0
+libvirt_node_devices{capability="vfio"} 1
+`
+
+	err := testutil.GatherAndCompare(reg, strings.NewReader(expected),
+		"libvirt_node_devices")
+	if err != nil {
+		t.Error(err)
+	}
+
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("gather failed, %s", err)
+	}
+	for _, mf := range mfs {
+		if mf.GetName() != "libvirt_exporter_collector_success" {
+			continue
+		}
+		for _, m := range mf.GetMetric() {
+			if m.GetLabel()[0].GetValue() == "nodedev" && m.GetGauge().GetValue() != 1 {
+				t.Error("nodedev collector failed")
+			}
+		}
+	}
+
+	if strings.Contains(logs.String(), "pci_0000_5e_00_0") {
+		t.Errorf("unplugged device logged\n%s", logs.String())
+	}
+	if !strings.Contains(logs.String(), "read node device pci_0000_af_00_0 failed") {
+		t.Errorf("broken device not logged\n%s", logs.String())
+	}
+}
@@ -0,0 +1,80 @@
+package exporter
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// hostdevCollector reports the host devices passed through to domains,
+// which pin the domains to their hypervisor, i.e. they can't be live
+// migrated.
+type hostdevCollector struct{ e *Exporter }
+
+func (c hostdevCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.e.hostdevs
+	ch <- c.e.hostdevInfo
+}
+
+func (c hostdevCollector) CollectDomain(ch chan<- prometheus.Metric, cli *client, d *domainContext) error {
+	c.e.collectHostdevs(ch, d)
+	return nil
+}
+
+func (e *Exporter) collectHostdevs(ch chan<- prometheus.Metric, d *domainContext) {
+	// pci and usb are always reported, so domains without passthrough
+	// devices are there too
+	counts := map[string]int{"pci": 0, "usb": 0}
+	for _, dev := range d.schema.Devices.Hostdevs {
+		counts[dev.Type]++
+
+		ch <- e.constMetric(
+			e.hostdevInfo,
+			prometheus.GaugeValue,
+			1,
+			d.name, d.uuid,
+			dev.Type,
+			hostdevAddress(dev.Type, &dev.Source),
+			dev.Source.Vendor.ID,
+			dev.Source.Product.ID)
+	}
+
+	for typ, count := range counts {
+		ch <- e.constMetric(
+			e.hostdevs,
+			prometheus.GaugeValue,
+			float64(count),
+			d.name, d.uuid, typ)
+	}
+}
+
+// hostdevAddress returns the address of the host device of the type,
+// e.g. 0000:03:00.0 of pci devices or 1:5, the bus and device, of usb
+// ones, the uuid of mediated devices and the adapter of scsi ones. The
+// addresses of the source have no type attribute.
+func hostdevAddress(typ string, src *HostdevSource) string {
+	addr := src.Address
+	if addr == nil {
+		return src.Adapter.Name
+	}
+
+	switch typ {
+	case "pci":
+		return fmt.Sprintf("%04x:%02x:%02x.%x",
+			parseHex(addr.Domain), parseHex(addr.Bus), parseHex(addr.Slot), parseHex(addr.Function))
+	case "usb":
+		return addr.Bus + ":" + addr.Device
+	case "mdev":
+		return addr.UUID
+	}
+
+	return src.Adapter.Name
+}
+
+// parseHex parses the numbers of device addresses, which are usually
+// hex with the 0x prefix, 0 if invalid.
+func parseHex(s string) uint64 {
+	n, _ := strconv.ParseUint(s, 0, 64)
+	return n
+}
@@ -0,0 +1,110 @@
+package exporter
+
+import (
+	"sort"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// DomainMeta is the domain WithLabelFunc derives the labels from.
+type DomainMeta struct {
+	Name string
+	UUID string
+
+	// the domain XML, e.g. for the metadata of the application
+	// managing it
+	Schema *Domain
+}
+
+// WithLabelFunc attaches the labels returned by fn to every metric of
+// the domain, e.g. the tenant or application looked up in the database
+// of the embedding program. fn is called once per domain and scrape.
+//
+// The names of the labels may vary by domain, so the exporter describes
+// no metric then, i.e. it's an unchecked collector. Labels named like
+// the existing ones or invalid are ignored.
+func WithLabelFunc(fn func(domain DomainMeta) prometheus.Labels) Option {
+	return func(e *Exporter) {
+		e.labelFunc = fn
+	}
+}
+
+// domainLabels returns the extra labels of the domain, sorted by name,
+// nil if there is none.
+func (e *Exporter) domainLabels(meta DomainMeta) []*dto.LabelPair {
+	if e.labelFunc == nil {
+		return nil
+	}
+
+	labels := e.labelFunc(meta)
+	pairs := make([]*dto.LabelPair, 0, len(labels))
+	for name, value := range labels {
+		if !ValidLabelName(name) {
+			continue
+		}
+
+		if clean, ok := sanitize(value); !ok {
+			value = clean
+			e.sanitized.Inc()
+		}
+
+		name, value := name, value
+		pairs = append(pairs, &dto.LabelPair{Name: &name, Value: &value})
+	}
+
+	if len(pairs) == 0 {
+		return nil
+	}
+
+	return pairs
+}
+
+// labelMetrics returns the channel which forwards the metrics sent to
+// it to ch, with the labels attached, and the func to call once all
+// metrics are sent.
+func labelMetrics(ch chan<- prometheus.Metric, pairs []*dto.LabelPair) (chan<- prometheus.Metric, func()) {
+	labeled := make(chan prometheus.Metric)
+	done := make(chan struct{})
+	go func() {
+		for metric := range labeled {
+			ch <- &labeledMetric{Metric: metric, pairs: pairs}
+		}
+		close(done)
+	}()
+
+	return labeled, func() {
+		close(labeled)
+		<-done
+	}
+}
+
+// labeledMetric is a metric with extra labels, its Desc doesn't tell
+// them.
+type labeledMetric struct {
+	prometheus.Metric
+	pairs []*dto.LabelPair
+}
+
+func (m *labeledMetric) Write(out *dto.Metric) error {
+	if err := m.Metric.Write(out); err != nil {
+		return err
+	}
+
+	existing := make(map[string]bool, len(out.Label))
+	for _, pair := range out.Label {
+		existing[pair.GetName()] = true
+	}
+
+	for _, pair := range m.pairs {
+		if !existing[pair.GetName()] {
+			out.Label = append(out.Label, pair)
+		}
+	}
+
+	sort.Slice(out.Label, func(i, j int) bool {
+		return out.Label[i].GetName() < out.Label[j].GetName()
+	})
+
+	return nil
+}
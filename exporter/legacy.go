@@ -0,0 +1,76 @@
+package exporter
+
+import (
+	"sort"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// legacyNames maps the names of renamed metrics, without the namespace,
+// to their former names.
+var legacyNames = map[string]string{
+	"exporter_scrape_duration_seconds": "scrape_latency",
+	"domains":                          "domains_total",
+
+	"domain_memory_rss_bytes":       "domain_info_memory_rss_bytes",
+	"domain_memory_maximum_bytes":   "domain_info_maximum_memory_bytes",
+	"domain_memory_usage_bytes":     "domain_info_memory_usage_bytes",
+	"domain_virtual_cpus":           "domain_info_virtual_cpus",
+	"domain_cpu_time_seconds_total": "domain_info_cpu_time_seconds_total",
+
+	"block_read_bytes_total":     "domain_block_read_bytes_total",
+	"block_read_requests_total":  "domain_block_read_requests_total",
+	"block_write_bytes_total":    "domain_block_write_bytes_total",
+	"block_write_requests_total": "domain_block_write_requests_total",
+
+	"interface_receive_bytes_total":    "domain_interface_receive_bytes_total",
+	"interface_receive_packets_total":  "domain_interface_receive_packets_total",
+	"interface_receive_errors_total":   "domain_interface_receive_errors_total",
+	"interface_receive_drops_total":    "domain_interface_receive_drops_total",
+	"interface_transmit_bytes_total":   "domain_interface_transmit_bytes_total",
+	"interface_transmit_packets_total": "domain_interface_transmit_packets_total",
+	"interface_transmit_errors_total":  "domain_interface_transmit_errors_total",
+	"interface_transmit_drops_total":   "domain_interface_transmit_drops_total",
+}
+
+// EnableLegacyNames makes the gatherers expose the renamed metrics under
+// their former names too, so dashboards and alerts keep working during
+// the migration to the new names.
+func (r *Relabeler) EnableLegacyNames(namespace string) {
+	aliases := make(map[string]string, len(legacyNames))
+	for name, legacy := range legacyNames {
+		aliases[namespace+"_"+name] = namespace + "_" + legacy
+	}
+
+	r.mu.Lock()
+	r.aliases = aliases
+	r.mu.Unlock()
+}
+
+// addLegacy appends a copy of every renamed family under its former
+// name, nothing if aliases is nil.
+func addLegacy(mfs []*dto.MetricFamily, aliases map[string]string) []*dto.MetricFamily {
+	n := len(mfs)
+	for _, mf := range mfs[:n] {
+		legacy, ok := aliases[mf.GetName()]
+		if !ok {
+			continue
+		}
+
+		help := mf.GetHelp() + " Deprecated, use " + mf.GetName() + "."
+		mfs = append(mfs, &dto.MetricFamily{
+			Name:   &legacy,
+			Help:   &help,
+			Type:   mf.Type,
+			Metric: append([]*dto.Metric(nil), mf.Metric...),
+		})
+	}
+
+	if len(mfs) > n {
+		sort.Slice(mfs, func(i, j int) bool {
+			return mfs[i].GetName() < mfs[j].GetName()
+		})
+	}
+
+	return mfs
+}
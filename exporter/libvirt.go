@@ -0,0 +1,123 @@
+package exporter
+
+import (
+	"context"
+	"time"
+
+	"github.com/digitalocean/go-libvirt"
+)
+
+// Libvirt is the subset of the methods of *libvirt.Libvirt the exporter
+// calls, so libvirt could be replaced by a fake, e.g. libvirttest.Fake,
+// in tests or by programs embedding the exporter.
+type Libvirt interface {
+	Connect() error
+	Disconnect() error
+	ConnectListAllDomains(needResults int32, flags libvirt.ConnectListAllDomainsFlags) (rDomains []libvirt.Domain, rRet uint32, err error)
+	DomainGetXMLDesc(dom libvirt.Domain, flags libvirt.DomainXMLFlags) (rXML string, err error)
+	DomainGetInfo(dom libvirt.Domain) (rState uint8, rMaxMem uint64, rMemory uint64, rNrVirtCPU uint16, rCPUTime uint64, err error)
+	DomainIsActive(dom libvirt.Domain) (rActive int32, err error)
+	DomainMemoryStats(dom libvirt.Domain, maxStats uint32, flags uint32) (rStats []libvirt.DomainMemoryStat, err error)
+	DomainBlockStats(dom libvirt.Domain, path string) (rRdReq int64, rRdBytes int64, rWrReq int64, rWrBytes int64, rErrs int64, err error)
+	DomainInterfaceStats(dom libvirt.Domain, device string) (rRxBytes int64, rRxPackets int64, rRxErrs int64, rRxDrop int64, rTxBytes int64, rTxPackets int64, rTxErrs int64, rTxDrop int64, err error)
+	QEMUDomainAgentCommand(dom libvirt.Domain, cmd string, timeout int32, flags uint32) (rResult libvirt.OptString, err error)
+	DomainStartDirtyRateCalc(dom libvirt.Domain, seconds int32, flags uint32) (err error)
+	ConnectGetAllDomainStats(doms []libvirt.Domain, stats uint32, flags uint32) (rRetStats []libvirt.DomainStatsRecord, err error)
+	ConnectListAllStoragePools(needResults int32, flags libvirt.ConnectListAllStoragePoolsFlags) (rPools []libvirt.StoragePool, rRet uint32, err error)
+	StoragePoolIsActive(pool libvirt.StoragePool) (rActive int32, err error)
+	StoragePoolGetXMLDesc(pool libvirt.StoragePool, flags libvirt.StorageXMLFlags) (rXML string, err error)
+	StoragePoolListAllVolumes(pool libvirt.StoragePool, needResults int32, flags uint32) (rVols []libvirt.StorageVol, rRet uint32, err error)
+	StorageVolGetInfo(vol libvirt.StorageVol) (rType int8, rCapacity uint64, rAllocation uint64, err error)
+	StorageVolGetPath(vol libvirt.StorageVol) (rName string, err error)
+	ConnectListAllNetworks(needResults int32, flags libvirt.ConnectListAllNetworksFlags) (rNets []libvirt.Network, rRet uint32, err error)
+	NetworkIsActive(net libvirt.Network) (rActive int32, err error)
+	NetworkIsPersistent(net libvirt.Network) (rPersistent int32, err error)
+	NetworkGetAutostart(net libvirt.Network) (rAutostart int32, err error)
+	NetworkGetXMLDesc(net libvirt.Network, flags uint32) (rXML string, err error)
+	NetworkGetDhcpLeases(net libvirt.Network, mac libvirt.OptString, needResults int32, flags uint32) (rLeases []libvirt.NetworkDhcpLease, rRet uint32, err error)
+	ConnectListAllInterfaces(needResults int32, flags libvirt.ConnectListAllInterfacesFlags) (rIfaces []libvirt.Interface, rRet uint32, err error)
+	InterfaceIsActive(iface libvirt.Interface) (rActive int32, err error)
+	ConnectListAllNodeDevices(needResults int32, flags uint32) (rDevices []libvirt.NodeDevice, rRet uint32, err error)
+	NodeDeviceGetXMLDesc(name string, flags uint32) (rXML string, err error)
+	ConnectListAllSecrets(needResults int32, flags libvirt.ConnectListAllSecretsFlags) (rSecrets []libvirt.Secret, rRet uint32, err error)
+	ConnectListAllNwfilters(needResults int32, flags uint32) (rFilters []libvirt.Nwfilter, rRet uint32, err error)
+}
+
+var (
+	_ Libvirt = (*libvirt.Libvirt)(nil)
+	_ Libvirt = sharedLibvirt{}
+)
+
+// WithLibvirt makes the exporter collect from the clients returned by
+// connect instead of dialing its URI, Connect and Disconnect are called
+// on the client around every collection.
+func WithLibvirt(connect func() (Libvirt, error)) Option {
+	return func(e *Exporter) {
+		e.connect = connect
+	}
+}
+
+// WithConnection makes the exporter collect over l, a connection owned
+// by the caller, e.g. an orchestrator, instead of dialing its URI. l must
+// be connected already, the exporter never connects, disconnects nor
+// closes it, so WithTimeout and the context of collections don't apply.
+func WithConnection(l *libvirt.Libvirt) Option {
+	return WithLibvirt(func() (Libvirt, error) {
+		return sharedLibvirt{l}, nil
+	})
+}
+
+// sharedLibvirt is a connection owned by someone else.
+type sharedLibvirt struct {
+	*libvirt.Libvirt
+}
+
+func (sharedLibvirt) Connect() error {
+	return nil
+}
+
+func (sharedLibvirt) Disconnect() error {
+	return nil
+}
+
+// open returns a not yet connected client of libvirt, and the func to
+// release it once disconnected.
+func (e *Exporter) open(ctx context.Context) (Libvirt, func(), error) {
+	if e.connect != nil {
+		l, err := e.connect()
+		return l, func() {}, err
+	}
+
+	conn, err := dial(e.uri, 5*time.Second)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// a hung libvirtd or remote host won't block the scrape forever
+	var deadline time.Time
+	if e.timeout > 0 {
+		deadline = time.Now().Add(e.timeout)
+	}
+	if d, ok := ctx.Deadline(); ok && (deadline.IsZero() || d.Before(deadline)) {
+		deadline = d
+	}
+	if !deadline.IsZero() {
+		conn.SetDeadline(deadline)
+	}
+
+	// go-libvirt takes no context, the pending call is failed by the
+	// deadline once ctx is done
+	stop := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.SetDeadline(time.Now())
+		case <-stop:
+		}
+	}()
+
+	return libvirt.New(conn), func() {
+		close(stop)
+		conn.Close()
+	}, nil
+}
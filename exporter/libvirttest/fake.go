@@ -0,0 +1,673 @@
+// Package libvirttest provides an in-memory libvirt implementing
+// exporter.Libvirt, for testing the collectors and programs embedding
+// the exporter without a libvirtd.
+package libvirttest
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/digitalocean/go-libvirt"
+
+	"github.com/f1shl3gs/libvirt_exporter/exporter"
+)
+
+// Domain is a domain of the Fake, the memory is in KiB and the CPU
+// time in nanoseconds, like libvirt reports them.
+type Domain struct {
+	libvirt.Domain
+
+	XML       string
+	State     libvirt.DomainState
+	MaxMemory uint64
+	Memory    uint64
+	VCPUs     uint16
+	CPUTime   uint64
+
+	MemoryStats []libvirt.DomainMemoryStat
+
+	// stats of the disks by path and interfaces by device name
+	BlockStats     map[string]BlockStats
+	InterfaceStats map[string]InterfaceStats
+
+	// replies of the guest agent by command
+	AgentReplies map[string]string
+
+	// typed parameters of the bulk stats, e.g. perf.cpu_cycles or
+	// dirtyrate.megabytes_per_second, they are returned whatever groups
+	// are asked
+	Stats []libvirt.TypedParam
+}
+
+// Active reports whether the domain is running, i.e. not shut off.
+func (d *Domain) Active() bool {
+	return d.State != libvirt.DomainShutoff && d.State != libvirt.DomainNostate
+}
+
+type BlockStats struct {
+	RdReq, RdBytes, WrReq, WrBytes, Errs int64
+}
+
+type InterfaceStats struct {
+	RxBytes, RxPackets, RxErrs, RxDrop int64
+	TxBytes, TxPackets, TxErrs, TxDrop int64
+}
+
+type StoragePool struct {
+	libvirt.StoragePool
+
+	XML     string
+	Active  bool
+	Volumes []StorageVolume
+}
+
+type StorageVolume struct {
+	libvirt.StorageVol
+
+	Path       string
+	Type       int8
+	Capacity   uint64
+	Allocation uint64
+}
+
+type Network struct {
+	libvirt.Network
+
+	XML        string
+	Active     bool
+	Persistent bool
+	Autostart  bool
+	Leases     []libvirt.NetworkDhcpLease
+
+	// Undefined networks are listed, but not found by the calls after,
+	// like networks undefined between the listing and the reads
+	Undefined bool
+}
+
+type Interface struct {
+	libvirt.Interface
+
+	Active bool
+}
+
+type NodeDevice struct {
+	libvirt.NodeDevice
+
+	XML  string
+	Caps []string
+
+	// Undefined devices are listed, but not found by the calls after,
+	// like devices unplugged between the listing and the reads
+	Undefined bool
+}
+
+// Fake is an in-memory libvirt. Every method returns what is set in the
+// fields, or the error in Errors by the name of the method, e.g.
+// "DomainBlockStats". It's safe for concurrent use, as long as the
+// fields are not changed while collecting.
+type Fake struct {
+	Domains      []Domain
+	StoragePools []StoragePool
+	Networks     []Network
+	Interfaces   []Interface
+	NodeDevices  []NodeDevice
+	Secrets      []libvirt.Secret
+	Nwfilters    []libvirt.Nwfilter
+
+	Errors map[string]error
+
+	// Blocks holds the calls of the methods by name until the channel is
+	// closed, like the RPCs of a stuck qemu monitor or libvirtd
+	Blocks map[string]chan struct{}
+
+	mu        sync.Mutex
+	calls     map[string]int
+	connected bool
+}
+
+var _ exporter.Libvirt = (*Fake)(nil)
+
+// Calls returns the number of calls of the method.
+func (f *Fake) Calls(method string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.calls[method]
+}
+
+// Connected reports whether Connect was called without Disconnect.
+func (f *Fake) Connected() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.connected
+}
+
+// call counts the call of the method, waits for its block to be closed
+// if any, and returns its error.
+func (f *Fake) call(method string) error {
+	f.mu.Lock()
+	if f.calls == nil {
+		f.calls = make(map[string]int)
+	}
+	f.calls[method]++
+	block, err := f.Blocks[method], f.Errors[method]
+	f.mu.Unlock()
+
+	if block != nil {
+		<-block
+	}
+
+	return err
+}
+
+func (f *Fake) domain(dom libvirt.Domain) (*Domain, error) {
+	for i := range f.Domains {
+		if f.Domains[i].UUID == dom.UUID {
+			return &f.Domains[i], nil
+		}
+	}
+
+	return nil, fmt.Errorf("domain not found: no domain with matching uuid '%x'", dom.UUID)
+}
+
+func (f *Fake) pool(pool libvirt.StoragePool) (*StoragePool, error) {
+	for i := range f.StoragePools {
+		if f.StoragePools[i].UUID == pool.UUID {
+			return &f.StoragePools[i], nil
+		}
+	}
+
+	return nil, fmt.Errorf("storage pool not found: no storage pool with matching uuid '%x'", pool.UUID)
+}
+
+func (f *Fake) network(net libvirt.Network) (*Network, error) {
+	for i := range f.Networks {
+		if f.Networks[i].UUID == net.UUID && !f.Networks[i].Undefined {
+			return &f.Networks[i], nil
+		}
+	}
+
+	return nil, libvirt.Error{
+		Code:    uint32(libvirt.ErrNoNetwork),
+		Message: fmt.Sprintf("network not found: no network with matching uuid '%x'", net.UUID),
+	}
+}
+
+func (f *Fake) nodeDevice(name string) (*NodeDevice, error) {
+	for i := range f.NodeDevices {
+		if f.NodeDevices[i].Name == name && !f.NodeDevices[i].Undefined {
+			return &f.NodeDevices[i], nil
+		}
+	}
+
+	return nil, libvirt.Error{
+		Code:    uint32(libvirt.ErrNoNodeDevice),
+		Message: fmt.Sprintf("node device not found: no node device with matching name '%s'", name),
+	}
+}
+
+func boolToInt32(b bool) int32 {
+	if b {
+		return 1
+	}
+
+	return 0
+}
+
+func (f *Fake) Connect() error {
+	if err := f.call("Connect"); err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	f.connected = true
+	f.mu.Unlock()
+
+	return nil
+}
+
+func (f *Fake) Disconnect() error {
+	if err := f.call("Disconnect"); err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	f.connected = false
+	f.mu.Unlock()
+
+	return nil
+}
+
+// ConnectListAllDomains lists the active domains, the inactive ones or
+// both like libvirt, no flags means all.
+func (f *Fake) ConnectListAllDomains(needResults int32, flags libvirt.ConnectListAllDomainsFlags) (rDomains []libvirt.Domain, rRet uint32, err error) {
+	if err = f.call("ConnectListAllDomains"); err != nil {
+		return
+	}
+
+	active := flags&libvirt.ConnectListDomainsActive != 0
+	inactive := flags&libvirt.ConnectListDomainsInactive != 0
+	for _, d := range f.Domains {
+		if active == inactive || active == d.Active() {
+			rDomains = append(rDomains, d.Domain)
+		}
+	}
+
+	return rDomains, uint32(len(rDomains)), nil
+}
+
+func (f *Fake) DomainGetXMLDesc(dom libvirt.Domain, flags libvirt.DomainXMLFlags) (rXML string, err error) {
+	if err = f.call("DomainGetXMLDesc"); err != nil {
+		return
+	}
+
+	d, err := f.domain(dom)
+	if err != nil {
+		return
+	}
+
+	return d.XML, nil
+}
+
+func (f *Fake) DomainGetInfo(dom libvirt.Domain) (rState uint8, rMaxMem uint64, rMemory uint64, rNrVirtCPU uint16, rCPUTime uint64, err error) {
+	if err = f.call("DomainGetInfo"); err != nil {
+		return
+	}
+
+	d, err := f.domain(dom)
+	if err != nil {
+		return
+	}
+
+	return uint8(d.State), d.MaxMemory, d.Memory, d.VCPUs, d.CPUTime, nil
+}
+
+func (f *Fake) DomainIsActive(dom libvirt.Domain) (rActive int32, err error) {
+	if err = f.call("DomainIsActive"); err != nil {
+		return
+	}
+
+	d, err := f.domain(dom)
+	if err != nil {
+		return
+	}
+
+	return boolToInt32(d.Active()), nil
+}
+
+func (f *Fake) DomainMemoryStats(dom libvirt.Domain, maxStats uint32, flags uint32) (rStats []libvirt.DomainMemoryStat, err error) {
+	if err = f.call("DomainMemoryStats"); err != nil {
+		return
+	}
+
+	d, err := f.domain(dom)
+	if err != nil {
+		return
+	}
+
+	rStats = d.MemoryStats
+	if uint32(len(rStats)) > maxStats {
+		rStats = rStats[:maxStats]
+	}
+
+	return rStats, nil
+}
+
+func (f *Fake) DomainBlockStats(dom libvirt.Domain, path string) (rRdReq int64, rRdBytes int64, rWrReq int64, rWrBytes int64, rErrs int64, err error) {
+	if err = f.call("DomainBlockStats"); err != nil {
+		return
+	}
+
+	d, err := f.domain(dom)
+	if err != nil {
+		return
+	}
+
+	stats, ok := d.BlockStats[path]
+	if !ok {
+		err = fmt.Errorf("invalid argument: invalid path: %s", path)
+		return
+	}
+
+	return stats.RdReq, stats.RdBytes, stats.WrReq, stats.WrBytes, stats.Errs, nil
+}
+
+func (f *Fake) DomainInterfaceStats(dom libvirt.Domain, device string) (rRxBytes int64, rRxPackets int64, rRxErrs int64, rRxDrop int64, rTxBytes int64, rTxPackets int64, rTxErrs int64, rTxDrop int64, err error) {
+	if err = f.call("DomainInterfaceStats"); err != nil {
+		return
+	}
+
+	d, err := f.domain(dom)
+	if err != nil {
+		return
+	}
+
+	stats, ok := d.InterfaceStats[device]
+	if !ok {
+		err = fmt.Errorf("invalid argument: invalid path, '%s' is not a known interface", device)
+		return
+	}
+
+	return stats.RxBytes, stats.RxPackets, stats.RxErrs, stats.RxDrop,
+		stats.TxBytes, stats.TxPackets, stats.TxErrs, stats.TxDrop, nil
+}
+
+func (f *Fake) QEMUDomainAgentCommand(dom libvirt.Domain, cmd string, timeout int32, flags uint32) (rResult libvirt.OptString, err error) {
+	if err = f.call("QEMUDomainAgentCommand"); err != nil {
+		return
+	}
+
+	d, err := f.domain(dom)
+	if err != nil {
+		return
+	}
+
+	reply, ok := d.AgentReplies[cmd]
+	if !ok {
+		err = fmt.Errorf("argument unsupported: QEMU guest agent is not configured")
+		return
+	}
+
+	return libvirt.OptString{reply}, nil
+}
+
+// DomainStartDirtyRateCalc only counts the call, the measured rate is
+// set in the Stats of the domain.
+func (f *Fake) DomainStartDirtyRateCalc(dom libvirt.Domain, seconds int32, flags uint32) (err error) {
+	if err = f.call("DomainStartDirtyRateCalc"); err != nil {
+		return
+	}
+
+	_, err = f.domain(dom)
+	return
+}
+
+func (f *Fake) ConnectGetAllDomainStats(doms []libvirt.Domain, stats uint32, flags uint32) (rRetStats []libvirt.DomainStatsRecord, err error) {
+	if err = f.call("ConnectGetAllDomainStats"); err != nil {
+		return
+	}
+
+	for _, dom := range doms {
+		d, err := f.domain(dom)
+		if err != nil {
+			return nil, err
+		}
+
+		rRetStats = append(rRetStats, libvirt.DomainStatsRecord{Dom: d.Domain, Params: d.Stats})
+	}
+
+	return rRetStats, nil
+}
+
+func (f *Fake) ConnectListAllStoragePools(needResults int32, flags libvirt.ConnectListAllStoragePoolsFlags) (rPools []libvirt.StoragePool, rRet uint32, err error) {
+	if err = f.call("ConnectListAllStoragePools"); err != nil {
+		return
+	}
+
+	for _, pool := range f.StoragePools {
+		rPools = append(rPools, pool.StoragePool)
+	}
+
+	return rPools, uint32(len(rPools)), nil
+}
+
+func (f *Fake) StoragePoolIsActive(pool libvirt.StoragePool) (rActive int32, err error) {
+	if err = f.call("StoragePoolIsActive"); err != nil {
+		return
+	}
+
+	p, err := f.pool(pool)
+	if err != nil {
+		return
+	}
+
+	return boolToInt32(p.Active), nil
+}
+
+func (f *Fake) StoragePoolGetXMLDesc(pool libvirt.StoragePool, flags libvirt.StorageXMLFlags) (rXML string, err error) {
+	if err = f.call("StoragePoolGetXMLDesc"); err != nil {
+		return
+	}
+
+	p, err := f.pool(pool)
+	if err != nil {
+		return
+	}
+
+	return p.XML, nil
+}
+
+func (f *Fake) StoragePoolListAllVolumes(pool libvirt.StoragePool, needResults int32, flags uint32) (rVols []libvirt.StorageVol, rRet uint32, err error) {
+	if err = f.call("StoragePoolListAllVolumes"); err != nil {
+		return
+	}
+
+	p, err := f.pool(pool)
+	if err != nil {
+		return
+	}
+
+	for _, vol := range p.Volumes {
+		rVols = append(rVols, vol.StorageVol)
+	}
+
+	return rVols, uint32(len(rVols)), nil
+}
+
+func (f *Fake) StorageVolGetInfo(vol libvirt.StorageVol) (rType int8, rCapacity uint64, rAllocation uint64, err error) {
+	if err = f.call("StorageVolGetInfo"); err != nil {
+		return
+	}
+
+	for _, pool := range f.StoragePools {
+		for _, v := range pool.Volumes {
+			if v.Key == vol.Key {
+				return v.Type, v.Capacity, v.Allocation, nil
+			}
+		}
+	}
+
+	err = fmt.Errorf("storage volume not found: no storage vol with matching key %s", vol.Key)
+	return
+}
+
+func (f *Fake) StorageVolGetPath(vol libvirt.StorageVol) (rName string, err error) {
+	if err = f.call("StorageVolGetPath"); err != nil {
+		return
+	}
+
+	for _, pool := range f.StoragePools {
+		for _, v := range pool.Volumes {
+			if v.Key == vol.Key {
+				return v.Path, nil
+			}
+		}
+	}
+
+	err = fmt.Errorf("storage volume not found: no storage vol with matching key %s", vol.Key)
+	return
+}
+
+func (f *Fake) ConnectListAllNetworks(needResults int32, flags libvirt.ConnectListAllNetworksFlags) (rNets []libvirt.Network, rRet uint32, err error) {
+	if err = f.call("ConnectListAllNetworks"); err != nil {
+		return
+	}
+
+	for _, net := range f.Networks {
+		rNets = append(rNets, net.Network)
+	}
+
+	return rNets, uint32(len(rNets)), nil
+}
+
+func (f *Fake) NetworkIsActive(net libvirt.Network) (rActive int32, err error) {
+	if err = f.call("NetworkIsActive"); err != nil {
+		return
+	}
+
+	n, err := f.network(net)
+	if err != nil {
+		return
+	}
+
+	return boolToInt32(n.Active), nil
+}
+
+func (f *Fake) NetworkIsPersistent(net libvirt.Network) (rPersistent int32, err error) {
+	if err = f.call("NetworkIsPersistent"); err != nil {
+		return
+	}
+
+	n, err := f.network(net)
+	if err != nil {
+		return
+	}
+
+	return boolToInt32(n.Persistent), nil
+}
+
+func (f *Fake) NetworkGetAutostart(net libvirt.Network) (rAutostart int32, err error) {
+	if err = f.call("NetworkGetAutostart"); err != nil {
+		return
+	}
+
+	n, err := f.network(net)
+	if err != nil {
+		return
+	}
+
+	return boolToInt32(n.Autostart), nil
+}
+
+func (f *Fake) NetworkGetXMLDesc(net libvirt.Network, flags uint32) (rXML string, err error) {
+	if err = f.call("NetworkGetXMLDesc"); err != nil {
+		return
+	}
+
+	n, err := f.network(net)
+	if err != nil {
+		return
+	}
+
+	return n.XML, nil
+}
+
+func (f *Fake) NetworkGetDhcpLeases(net libvirt.Network, mac libvirt.OptString, needResults int32, flags uint32) (rLeases []libvirt.NetworkDhcpLease, rRet uint32, err error) {
+	if err = f.call("NetworkGetDhcpLeases"); err != nil {
+		return
+	}
+
+	n, err := f.network(net)
+	if err != nil {
+		return
+	}
+
+	return n.Leases, uint32(len(n.Leases)), nil
+}
+
+func (f *Fake) ConnectListAllInterfaces(needResults int32, flags libvirt.ConnectListAllInterfacesFlags) (rIfaces []libvirt.Interface, rRet uint32, err error) {
+	if err = f.call("ConnectListAllInterfaces"); err != nil {
+		return
+	}
+
+	for _, iface := range f.Interfaces {
+		rIfaces = append(rIfaces, iface.Interface)
+	}
+
+	return rIfaces, uint32(len(rIfaces)), nil
+}
+
+func (f *Fake) InterfaceIsActive(iface libvirt.Interface) (rActive int32, err error) {
+	if err = f.call("InterfaceIsActive"); err != nil {
+		return
+	}
+
+	for _, i := range f.Interfaces {
+		if i.Name == iface.Name {
+			return boolToInt32(i.Active), nil
+		}
+	}
+
+	err = fmt.Errorf("interface not found: couldn't find interface named '%s'", iface.Name)
+	return
+}
+
+func (f *Fake) ConnectListAllNodeDevices(needResults int32, flags uint32) (rDevices []libvirt.NodeDevice, rRet uint32, err error) {
+	if err = f.call("ConnectListAllNodeDevices"); err != nil {
+		return
+	}
+
+	// the capability flags select the devices having any of them
+	caps := libvirt.ConnectListAllNodeDeviceFlags(flags) &^ (libvirt.ConnectListNodeDevicesInactive | libvirt.ConnectListNodeDevicesActive)
+	for _, device := range f.NodeDevices {
+		if caps != 0 && !device.hasCap(caps) {
+			continue
+		}
+
+		rDevices = append(rDevices, device.NodeDevice)
+	}
+
+	return rDevices, uint32(len(rDevices)), nil
+}
+
+// nodeDeviceCapFlags are the flags listing the devices by capability
+var nodeDeviceCapFlags = map[string]libvirt.ConnectListAllNodeDeviceFlags{
+	"system":       libvirt.ConnectListNodeDevicesCapSystem,
+	"pci":          libvirt.ConnectListNodeDevicesCapPciDev,
+	"usb_device":   libvirt.ConnectListNodeDevicesCapUsbDev,
+	"usb":          libvirt.ConnectListNodeDevicesCapUsbInterface,
+	"net":          libvirt.ConnectListNodeDevicesCapNet,
+	"scsi_host":    libvirt.ConnectListNodeDevicesCapScsiHost,
+	"scsi_target":  libvirt.ConnectListNodeDevicesCapScsiTarget,
+	"scsi":         libvirt.ConnectListNodeDevicesCapScsi,
+	"storage":      libvirt.ConnectListNodeDevicesCapStorage,
+	"fc_host":      libvirt.ConnectListNodeDevicesCapFcHost,
+	"vports":       libvirt.ConnectListNodeDevicesCapVports,
+	"scsi_generic": libvirt.ConnectListNodeDevicesCapScsiGeneric,
+	"drm":          libvirt.ConnectListNodeDevicesCapDrm,
+	"mdev_types":   libvirt.ConnectListNodeDevicesCapMdevTypes,
+	"mdev":         libvirt.ConnectListNodeDevicesCapMdev,
+	"ccw":          libvirt.ConnectListNodeDevicesCapCcwDev,
+	"css":          libvirt.ConnectListNodeDevicesCapCssDev,
+	"vdpa":         libvirt.ConnectListNodeDevicesCapVdpa,
+}
+
+func (d NodeDevice) hasCap(flags libvirt.ConnectListAllNodeDeviceFlags) bool {
+	for _, c := range d.Caps {
+		if nodeDeviceCapFlags[c]&flags != 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (f *Fake) NodeDeviceGetXMLDesc(name string, flags uint32) (rXML string, err error) {
+	if err = f.call("NodeDeviceGetXMLDesc"); err != nil {
+		return
+	}
+
+	d, err := f.nodeDevice(name)
+	if err != nil {
+		return
+	}
+
+	return d.XML, nil
+}
+
+func (f *Fake) ConnectListAllSecrets(needResults int32, flags libvirt.ConnectListAllSecretsFlags) (rSecrets []libvirt.Secret, rRet uint32, err error) {
+	if err = f.call("ConnectListAllSecrets"); err != nil {
+		return
+	}
+
+	return f.Secrets, uint32(len(f.Secrets)), nil
+}
+
+func (f *Fake) ConnectListAllNwfilters(needResults int32, flags uint32) (rFilters []libvirt.Nwfilter, rRet uint32, err error) {
+	if err = f.call("ConnectListAllNwfilters"); err != nil {
+		return
+	}
+
+	return f.Nwfilters, uint32(len(f.Nwfilters)), nil
+}
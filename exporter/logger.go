@@ -0,0 +1,27 @@
+package exporter
+
+import (
+	"fmt"
+	"log"
+)
+
+// Logger is where the exporter logs to, *log.Logger implements it.
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+// WithLogger replaces the standard logger, which the failures of
+// collections are logged to.
+func WithLogger(logger Logger) Option {
+	return func(e *Exporter) {
+		e.logger = logger
+	}
+}
+
+// stdLogger logs to the standard logger, so the flags and output set by
+// log.SetFlags and log.SetOutput apply.
+type stdLogger struct{}
+
+func (stdLogger) Printf(format string, v ...interface{}) {
+	log.Output(2, fmt.Sprintf(format, v...))
+}
@@ -0,0 +1,77 @@
+package exporter
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// MetricInfo describes a metric of the exporter, e.g. for generating
+// dashboards and rules from the actual names.
+type MetricInfo struct {
+	Name   string
+	Help   string
+	Labels []string
+	Type   prometheus.ValueType
+
+	// the collector of the metric, empty for the metrics of the
+	// exporter itself, e.g. up
+	Collector string
+}
+
+// Counter reports whether the metric is a counter.
+func (m MetricInfo) Counter() bool {
+	return m.Type == prometheus.CounterValue
+}
+
+// newDesc creates the desc of a gauge and keeps its metadata for Metrics.
+func (e *Exporter) newDesc(fqName, help string, labels []string, constLabels prometheus.Labels) *prometheus.Desc {
+	desc := prometheus.NewDesc(fqName, help, labels, constLabels)
+	e.metrics[desc] = MetricInfo{Name: fqName, Help: help, Labels: labels, Type: prometheus.GaugeValue}
+	return desc
+}
+
+// newCounterDesc is newDesc for counters.
+func (e *Exporter) newCounterDesc(fqName, help string, labels []string, constLabels prometheus.Labels) *prometheus.Desc {
+	desc := e.newDesc(fqName, help, labels, constLabels)
+	info := e.metrics[desc]
+	info.Type = prometheus.CounterValue
+	e.metrics[desc] = info
+	return desc
+}
+
+// Metrics describes the metrics of the enabled collectors and of the
+// exporter itself, in the order of collection. The histograms and
+// counters of the libvirt API calls are not included.
+func (e *Exporter) Metrics() []MetricInfo {
+	var result []MetricInfo
+	seen := make(map[*prometheus.Desc]bool)
+	add := func(describe func(ch chan<- *prometheus.Desc), collector string) {
+		descs := make(chan *prometheus.Desc)
+		go func() {
+			describe(descs)
+			close(descs)
+		}()
+
+		for desc := range descs {
+			info, ok := e.metrics[desc]
+			if !ok || seen[desc] {
+				continue
+			}
+
+			seen[desc] = true
+			info.Collector = collector
+			result = append(result, info)
+		}
+	}
+
+	sc := e.scope()
+	for _, name := range collectorNames {
+		if sc.enabled(name) {
+			add(e.instances[name].Describe, name)
+		}
+	}
+
+	// the exporter metrics, the ones of the collectors are seen already
+	add(func(ch chan<- *prometheus.Desc) { e.describe(ch, sc) }, "")
+
+	return result
+}
@@ -0,0 +1,69 @@
+package exporter_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/f1shl3gs/libvirt_exporter/exporter"
+)
+
+func TestMetricsCounter(t *testing.T) {
+	e := exporter.NewExporter("test:///default")
+
+	expected := map[string]bool{
+		"libvirt_up":                            false,
+		"libvirt_domains":                       false,
+		"libvirt_domain_virtual_cpus":           false,
+		"libvirt_domain_cpu_time_seconds_total": true,
+		"libvirt_block_read_bytes_total":        true,
+		"libvirt_interface_receive_bytes_total": true,
+	}
+
+	for _, info := range e.Metrics() {
+		counter, ok := expected[info.Name]
+		if !ok {
+			continue
+		}
+
+		delete(expected, info.Name)
+		if info.Counter() != counter {
+			t.Errorf("expected Counter() of %s to be %v", info.Name, counter)
+		}
+	}
+
+	for name := range expected {
+		t.Errorf("metric %s not described", name)
+	}
+}
+
+func TestNamingExporterMetrics(t *testing.T) {
+	// the metrics of the exporter itself are in the exporter subsystems
+	// of the namespace, so a strategy could move them all
+	naming := exporter.NamingFunc(func(namespace, subsystem, name string) string {
+		if sub := strings.TrimPrefix(subsystem, "exporter"); sub != subsystem {
+			return prometheus.BuildFQName("self", strings.TrimPrefix(sub, "_"), name)
+		}
+
+		return prometheus.BuildFQName(namespace, subsystem, name)
+	})
+	e := exporter.NewExporter("test:///default", exporter.WithNaming(naming))
+
+	expected := map[string]bool{
+		"self_scrape_duration_seconds":               true,
+		"self_collector_success":                     true,
+		"self_last_scrape_success_timestamp_seconds": true,
+	}
+
+	for _, info := range e.Metrics() {
+		if strings.HasPrefix(info.Name, "libvirt_exporter_") {
+			t.Errorf("metric %s not named by the strategy", info.Name)
+		}
+		delete(expected, info.Name)
+	}
+
+	for name := range expected {
+		t.Errorf("metric %s not described", name)
+	}
+}
@@ -0,0 +1,504 @@
+package exporter
+
+import (
+	"context"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Target is a hypervisor collected by MultiExporter, the labels are
+// attached to all of its metrics besides host.
+type Target struct {
+	URI    string
+	Labels map[string]string
+}
+
+// key identifies the target, a changed label makes a different target
+func (t Target) key() string {
+	names := make([]string, 0, len(t.Labels))
+	for name := range t.Labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var sb strings.Builder
+	sb.WriteString(t.URI)
+	for _, name := range names {
+		sb.WriteString("\xff" + name + "=" + t.Labels[name])
+	}
+
+	return sb.String()
+}
+
+// MultiExporter collects several hypervisors on each scrape, for small
+// clusters which don't want an exporter per host. The metrics of every
+// hypervisor are told apart by the host label.
+type MultiExporter struct {
+	opts []Option
+
+	mu        sync.RWMutex
+	filter    DomainFilter
+	targets   []Target
+	exporters map[string]*Exporter
+
+	// host labels of the exporters by the keys of their targets
+	hosts map[string]string
+
+	// time budget of a collection, 0 means no limit
+	budget time.Duration
+
+	// cluster level series, nil if not enabled
+	cluster *cluster
+
+	// the targets could be changed after registering, so the metrics are
+	// not described, i.e. it's an unchecked collector then
+	dynamic bool
+}
+
+// NewMultiExporter creates an Exporter per uri with the options, the host
+// label is attached only if there are more than one of them, so a single
+// uri produces the same metrics as NewExporter.
+func NewMultiExporter(uris []string, opts ...Option) *MultiExporter {
+	m := &MultiExporter{
+		opts:      opts,
+		targets:   make([]Target, 0, len(uris)),
+		exporters: make(map[string]*Exporter, len(uris)),
+	}
+
+	hosts := hostLabels(uris)
+	for i, uri := range uris {
+		target := Target{URI: uri}
+		hostOpts := opts[:len(opts):len(opts)]
+		if hosts[i] != "" {
+			hostOpts = append(hostOpts, WithConstLabels(prometheus.Labels{"host": hosts[i]}))
+		}
+
+		e := NewExporter(uri, hostOpts...)
+
+		m.targets = append(m.targets, target)
+		m.exporters[target.key()] = e
+	}
+	m.hosts = make(map[string]string, len(uris))
+	for i, target := range m.targets {
+		m.hosts[target.key()] = hosts[i]
+	}
+
+	return m
+}
+
+// Host returns the host name of the libvirt uri, or localhost for the
+// local daemon.
+func Host(uri string) string {
+	if !strings.Contains(uri, "://") {
+		return "localhost"
+	}
+
+	u, err := url.Parse(uri)
+	if err != nil || u.Hostname() == "" {
+		return "localhost"
+	}
+
+	return u.Hostname()
+}
+
+// HostLabels returns the host label of every uri, the host name by Host,
+// or the uri itself if the host name is shared with another uri, e.g.
+// qemu:///system and qemu:///session are both localhost.
+func HostLabels(uris []string) []string {
+	hosts := make([]string, len(uris))
+	counts := make(map[string]int, len(uris))
+	for i, uri := range uris {
+		hosts[i] = Host(uri)
+		counts[hosts[i]]++
+	}
+
+	for i, uri := range uris {
+		if counts[hosts[i]] > 1 {
+			hosts[i] = uri
+		}
+	}
+
+	return hosts
+}
+
+// hostLabels returns the host labels of the uris by HostLabels, but empty
+// if there is only one of them, so it's not attached then.
+func hostLabels(uris []string) []string {
+	if len(uris) == 1 {
+		return []string{""}
+	}
+
+	return HostLabels(uris)
+}
+
+// SetTargets replaces the hypervisors collected, e.g. loaded from a file
+// or discovered. The targets get the host label like by NewMultiExporter,
+// and the exporters of the unchanged targets are kept.
+func (m *MultiExporter) SetTargets(targets []Target) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	kept := make([]Target, 0, len(targets))
+	seen := make(map[string]bool, len(targets))
+	for _, target := range targets {
+		key := target.key()
+		if seen[key] {
+			continue
+		}
+
+		seen[key] = true
+		kept = append(kept, target)
+	}
+
+	uris := make([]string, 0, len(kept))
+	for _, target := range kept {
+		uris = append(uris, target.URI)
+	}
+
+	exporters := make(map[string]*Exporter, len(kept))
+	hosts := make(map[string]string, len(kept))
+	for i, host := range hostLabels(uris) {
+		target := kept[i]
+		key := target.key()
+
+		// the host label of a kept target changes if another target
+		// starts or stops sharing its host name, or it's the only one
+		e, ok := m.exporters[key]
+		if !ok || m.hosts[key] != host {
+			labels := prometheus.Labels{}
+			if host != "" {
+				labels["host"] = host
+			}
+			for name, value := range target.Labels {
+				labels[name] = value
+			}
+
+			opts := append(m.opts[:len(m.opts):len(m.opts)], WithConstLabels(labels))
+			e = NewExporter(target.URI, opts...)
+			e.SetDomainFilter(m.filter)
+		}
+
+		exporters[key] = e
+		hosts[key] = host
+	}
+
+	m.targets = kept
+	m.exporters = exporters
+	m.hosts = hosts
+	m.dynamic = true
+}
+
+// Targets returns the hypervisors collected.
+func (m *MultiExporter) Targets() []Target {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.targets
+}
+
+// Exporters returns the exporter of every hypervisor, in the order of
+// the targets.
+func (m *MultiExporter) Exporters() []*Exporter {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	exporters := make([]*Exporter, 0, len(m.targets))
+	for _, target := range m.targets {
+		exporters = append(exporters, m.exporters[target.key()])
+	}
+
+	return exporters
+}
+
+// Stalled reports whether a collection of any hypervisor made no progress
+// for d, see Exporter.Stalled.
+func (m *MultiExporter) Stalled(d time.Duration) bool {
+	for _, e := range m.Exporters() {
+		if e.Stalled(d) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (m *MultiExporter) Describe(ch chan<- *prometheus.Desc) {
+	m.mu.RLock()
+	dynamic := m.dynamic
+	m.mu.RUnlock()
+
+	if dynamic {
+		return
+	}
+
+	exporters := m.Exporters()
+	// the exporters describe nothing with the labels of domains unknown up
+	// front, neither does the cluster then, or it'd be a checked collector
+	for _, e := range exporters {
+		if e.labelFunc != nil {
+			return
+		}
+	}
+
+	hosts := make([]hostCollector, 0, len(exporters))
+	for _, e := range exporters {
+		e.Describe(ch)
+		hosts = append(hosts, hostCollector{e: e})
+	}
+
+	if cluster := m.aggregationOf(hosts); cluster != nil {
+		cluster.describe(ch)
+	}
+}
+
+func (m *MultiExporter) Collect(ch chan<- prometheus.Metric) {
+	m.CollectWithContext(context.Background(), ch)
+}
+
+// CollectWithContext is Collect, but the collection is canceled along
+// with ctx, the hypervisors not collected by then are reported down.
+func (m *MultiExporter) CollectWithContext(ctx context.Context, ch chan<- prometheus.Metric) {
+	exporters := m.Exporters()
+	hosts := make([]hostCollector, 0, len(exporters))
+	for _, e := range exporters {
+		hosts = append(hosts, hostCollector{e: e})
+	}
+
+	m.collect(ctx, ch, hosts)
+}
+
+// WithContext returns a collector which collects m with ctx, see
+// CollectWithContext. It's meant to be registered for a single scrape.
+func (m *MultiExporter) WithContext(ctx context.Context) prometheus.Collector {
+	return &contextCollector{m: m, ctx: ctx}
+}
+
+type contextCollector struct {
+	m   *MultiExporter
+	ctx context.Context
+}
+
+func (cc *contextCollector) Describe(ch chan<- *prometheus.Desc) {
+	cc.m.Describe(ch)
+}
+
+func (cc *contextCollector) Collect(ch chan<- prometheus.Metric) {
+	cc.m.CollectWithContext(cc.ctx, ch)
+}
+
+// EnableAggregation adds the series aggregated over all hypervisors, e.g.
+// the running domains and their vCPUs and memory.
+func (m *MultiExporter) EnableAggregation() {
+	// the namespace and the labels set by the options
+	tmpl := NewExporter("", m.opts...)
+
+	m.mu.Lock()
+	m.cluster = newCluster(tmpl.naming, tmpl.namespace, tmpl.constLabels)
+	m.mu.Unlock()
+}
+
+// Budget returns the time budget of a collection.
+func (m *MultiExporter) Budget() time.Duration {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.budget
+}
+
+// SetBudget limits the time a collection of all hypervisors could take,
+// the ones not collected in time are reported down, 0 means no limit.
+// The timeout of every hypervisor is set by WithTimeout.
+func (m *MultiExporter) SetBudget(budget time.Duration) {
+	m.mu.Lock()
+	m.budget = budget
+	m.mu.Unlock()
+}
+
+// DomainFilter returns the domain filter in use.
+func (m *MultiExporter) DomainFilter() DomainFilter {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.filter
+}
+
+// SetDomainFilter replaces the domain filter of every hypervisor.
+func (m *MultiExporter) SetDomainFilter(filter DomainFilter) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.filter = filter
+	for _, e := range m.exporters {
+		e.SetDomainFilter(filter)
+	}
+}
+
+// hostCollector is the collector of a hypervisor, e is used to report
+// it down if it's not collected in time.
+type hostCollector struct {
+	e *Exporter
+
+	// the collectors of Only, nil means the enabled ones
+	collectors map[string]bool
+}
+
+// collector returns the collector of the hypervisor collecting with ctx.
+func (h hostCollector) collector(ctx context.Context) *limitedExporter {
+	return &limitedExporter{e: h.e, ctx: ctx, collectors: h.collectors}
+}
+
+type hostsCollector struct {
+	m     *MultiExporter
+	ctx   context.Context
+	hosts []hostCollector
+}
+
+func (hc *hostsCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, h := range hc.hosts {
+		h.collector(hc.ctx).Describe(ch)
+	}
+
+	if cluster := hc.m.aggregationOf(hc.hosts); cluster != nil {
+		cluster.describe(ch)
+	}
+}
+
+func (hc *hostsCollector) Collect(ch chan<- prometheus.Metric) {
+	hc.m.collect(hc.ctx, ch, hc.hosts)
+}
+
+// Only returns a collector which collects the named collectors of every
+// hypervisor only, see Exporter.Only
+func (m *MultiExporter) Only(names ...string) (prometheus.Collector, error) {
+	return m.OnlyWithContext(context.Background(), names...)
+}
+
+// OnlyWithContext is Only, but the collection is canceled along with ctx.
+func (m *MultiExporter) OnlyWithContext(ctx context.Context, names ...string) (prometheus.Collector, error) {
+	exporters := m.Exporters()
+	hosts := make([]hostCollector, 0, len(exporters))
+	for _, e := range exporters {
+		collectors, err := e.only(names)
+		if err != nil {
+			return nil, err
+		}
+
+		hosts = append(hosts, hostCollector{e: e, collectors: collectors})
+	}
+
+	return &hostsCollector{m: m, ctx: ctx, hosts: hosts}, nil
+}
+
+func (m *MultiExporter) aggregation() *cluster {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.cluster
+}
+
+// aggregationOf returns the aggregation of the hosts, nil if it's not
+// enabled or the hosts don't collect the domain group, which the running
+// domains are summed from, e.g. a scrape of /metrics?collect[]=block.
+func (m *MultiExporter) aggregationOf(hosts []hostCollector) *cluster {
+	for _, h := range hosts {
+		if !h.collector(nil).sc().enabled("domain") {
+			return nil
+		}
+	}
+
+	return m.aggregation()
+}
+
+// collect collects the hypervisors concurrently, the metrics of every
+// one of them are sent once it's done. The hypervisors not done within
+// the budget, or before ctx is done, are reported down, so a slow one
+// won't fail the scrape, and their collections are canceled, so a hung
+// one won't pile up a collection per scrape.
+func (m *MultiExporter) collect(ctx context.Context, ch chan<- prometheus.Metric, hosts []hostCollector) {
+	start := time.Now()
+
+	var cancel context.CancelFunc
+	if budget := m.Budget(); budget > 0 {
+		ctx, cancel = context.WithTimeout(ctx, budget)
+	} else {
+		ctx, cancel = context.WithCancel(ctx)
+	}
+	defer cancel()
+
+	type result struct {
+		index   int
+		metrics []prometheus.Metric
+		tally   *domainTally
+	}
+
+	cluster := m.aggregationOf(hosts)
+	stats := &clusterStats{hosts: float64(len(hosts))}
+	if cluster != nil {
+		defer cluster.collect(ch, stats)
+	}
+
+	results := make(chan result, len(hosts))
+	for i, h := range hosts {
+		go func(i int, h hostCollector) {
+			buf := make(chan prometheus.Metric, 64)
+			done := make(chan []prometheus.Metric)
+			go func() {
+				var metrics []prometheus.Metric
+				for metric := range buf {
+					metrics = append(metrics, metric)
+				}
+				done <- metrics
+			}()
+
+			c := h.collector(ctx)
+			if cluster != nil {
+				c.tally = &domainTally{}
+			}
+
+			c.Collect(buf)
+			close(buf)
+			results <- result{index: i, metrics: <-done, tally: c.tally}
+		}(i, h)
+	}
+
+	finished := make([]bool, len(hosts))
+	for pending := len(hosts); pending > 0; pending-- {
+		select {
+		case r := <-results:
+			finished[r.index] = true
+			for _, metric := range r.metrics {
+				ch <- metric
+			}
+
+			if r.tally != nil {
+				stats.observe(r.tally)
+			}
+
+		case <-ctx.Done():
+			why := "canceled"
+			if ctx.Err() == context.DeadlineExceeded {
+				why = "timed out"
+			}
+
+			m.reportUnfinished(ch, hosts, finished, why, start)
+			return
+		}
+	}
+}
+
+// reportUnfinished reports the hypervisors not collected yet down.
+func (m *MultiExporter) reportUnfinished(ch chan<- prometheus.Metric, hosts []hostCollector, finished []bool, why string, start time.Time) {
+	for i, h := range hosts {
+		if finished[i] {
+			continue
+		}
+
+		h.e.logger.Printf("collect %s %s\n", h.e.URI(), why)
+		h.e.collectUnfinished(ch, h.collector(nil).sc(), start)
+	}
+}
@@ -0,0 +1,254 @@
+package exporter_test
+
+import (
+	"bytes"
+	"log"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/f1shl3gs/libvirt_exporter/exporter"
+)
+
+func TestMultiExporterBudget(t *testing.T) {
+	f := newFake()
+	block := make(chan struct{})
+	f.Blocks = map[string]chan struct{}{"ConnectListAllDomains": block}
+
+	var logs bytes.Buffer
+	m := exporter.NewMultiExporter([]string{"test:///default"},
+		exporter.WithLibvirt(func() (exporter.Libvirt, error) {
+			return f, nil
+		}),
+		exporter.WithLogger(log.New(&logs, "", 0)))
+	m.SetBudget(50 * time.Millisecond)
+
+	reg := prometheus.NewPedanticRegistry()
+	if err := reg.Register(m); err != nil {
+		t.Fatalf("register exporter failed, %s", err)
+	}
+
+	// the hung hypervisor is reported like one which can't be connected
+	expected := `
+# HELP libvirt_up Whether scraping libvirt's metrics was successful.
+# TYPE libvirt_up gauge
+libvirt_up 0
+# HELP libvirt_scrape_error Scrape status of libvirt
+# TYPE libvirt_scrape_error gauge
+libvirt_scrape_error 1
+`
+
+	start := time.Now()
+	err := testutil.GatherAndCompare(reg, strings.NewReader(expected),
+		"libvirt_up",
+		"libvirt_scrape_error",
+		"libvirt_domain_state")
+	if err != nil {
+		t.Error(err)
+	}
+	if took := time.Since(start); took > time.Second {
+		t.Errorf("gather took %s with the budget of 50ms", took)
+	}
+	if !strings.Contains(logs.String(), "collect test:///default timed out") {
+		t.Errorf("timed out hypervisor not logged\n%s", logs.String())
+	}
+
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("gather failed, %s", err)
+	}
+
+	var collectors int
+	for _, mf := range mfs {
+		if mf.GetName() != "libvirt_exporter_collector_success" {
+			continue
+		}
+		for _, metric := range mf.GetMetric() {
+			collectors++
+			if v := metric.GetGauge().GetValue(); v != 0 {
+				t.Errorf("collector success %v of %s, want 0 as timed out", v, metric.GetLabel())
+			}
+		}
+	}
+	if collectors == 0 {
+		t.Error("collector success of the timed out hypervisor not reported")
+	}
+
+	// the collection given up is canceled, rather than going on once
+	// the hypervisor answers
+	close(block)
+	e := m.Exporters()[0]
+	for deadline := time.Now().Add(5 * time.Second); e.LastScrape().Time.IsZero(); {
+		if time.Now().After(deadline) {
+			t.Fatal("collection given up never returned")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if err := e.LastScrape().Err; err == nil || !strings.Contains(err.Error(), "context deadline exceeded") {
+		t.Errorf("collection given up failed with %v, want context deadline exceeded", err)
+	}
+}
+
+func TestMultiExporterAggregation(t *testing.T) {
+	f := newFake()
+	m := exporter.NewMultiExporter([]string{"test:///default"},
+		exporter.WithLibvirt(func() (exporter.Libvirt, error) {
+			return f, nil
+		}))
+	m.EnableAggregation()
+
+	expected := `
+# HELP libvirt_cluster_running_domains Number of running domains of all hypervisors.
+# TYPE libvirt_cluster_running_domains gauge
+libvirt_cluster_running_domains 1
+`
+
+	reg := prometheus.NewPedanticRegistry()
+	if err := reg.Register(m); err != nil {
+		t.Fatalf("register exporter failed, %s", err)
+	}
+
+	err := testutil.GatherAndCompare(reg, strings.NewReader(expected), "libvirt_cluster_running_domains")
+	if err != nil {
+		t.Error(err)
+	}
+
+	// the domains aren't collected by the block group alone, so they
+	// aren't aggregated rather than reported as none
+	block, err := m.Only("block")
+	if err != nil {
+		t.Fatalf("only block failed, %s", err)
+	}
+
+	reg = prometheus.NewPedanticRegistry()
+	if err := reg.Register(block); err != nil {
+		t.Fatalf("register block collector failed, %s", err)
+	}
+
+	err = testutil.GatherAndCompare(reg, strings.NewReader(""), "libvirt_cluster_running_domains")
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestMultiExporterAggregationRelabeled(t *testing.T) {
+	f := newFake()
+	// a domain of the same name, so they are collapsed once the uuid
+	// label is dropped
+	d := f.Domains[0]
+	d.UUID[15] = 1
+	f.Domains = append(f.Domains, d)
+
+	m := exporter.NewMultiExporter([]string{"test:///default"},
+		exporter.WithLibvirt(func() (exporter.Libvirt, error) {
+			return f, nil
+		}),
+		exporter.WithLabelFunc(func(domain exporter.DomainMeta) prometheus.Labels {
+			return prometheus.Labels{"tenant": "acme"}
+		}))
+	m.EnableAggregation()
+
+	reg := prometheus.NewPedanticRegistry()
+	if err := reg.Register(m); err != nil {
+		t.Fatalf("register exporter failed, %s", err)
+	}
+
+	var relabeler exporter.Relabeler
+	err := relabeler.Update(exporter.RelabelConfig{
+		Drop:   []string{"uuid"},
+		Rename: map[string]string{"domain": "vm_name"},
+	})
+	if err != nil {
+		t.Fatalf("update relabeler failed, %s", err)
+	}
+
+	expected := `
+# HELP libvirt_cluster_allocated_memory_bytes Memory of the running domains of all hypervisors, in bytes.
+# TYPE libvirt_cluster_allocated_memory_bytes gauge
+libvirt_cluster_allocated_memory_bytes 2.147483648e+09
+# HELP libvirt_cluster_allocated_vcpus Number of virtual CPUs of the running domains of all hypervisors.
+# TYPE libvirt_cluster_allocated_vcpus gauge
+libvirt_cluster_allocated_vcpus 4
+# HELP libvirt_cluster_running_domains Number of running domains of all hypervisors.
+# TYPE libvirt_cluster_running_domains gauge
+libvirt_cluster_running_domains 2
+`
+
+	err = testutil.GatherAndCompare(relabeler.Gatherer(reg), strings.NewReader(expected),
+		"libvirt_cluster_allocated_memory_bytes",
+		"libvirt_cluster_allocated_vcpus",
+		"libvirt_cluster_running_domains")
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestMultiExporterHostLabel(t *testing.T) {
+	f := newFake()
+	withFake := exporter.WithLibvirt(func() (exporter.Libvirt, error) {
+		return f, nil
+	})
+
+	single := `
+# HELP libvirt_up Whether scraping libvirt's metrics was successful.
+# TYPE libvirt_up gauge
+libvirt_up 1
+`
+	multiple := `
+# HELP libvirt_up Whether scraping libvirt's metrics was successful.
+# TYPE libvirt_up gauge
+libvirt_up{host="hv01"} 1
+libvirt_up{host="hv02"} 1
+`
+
+	for _, tc := range []struct {
+		name     string
+		uris     []string
+		targets  []exporter.Target
+		expected string
+	}{
+		{
+			name:     "single uri",
+			uris:     []string{"qemu+tcp://hv01/system"},
+			expected: single,
+		},
+		{
+			name:     "single target",
+			uris:     []string{"qemu+tcp://hv01/system", "qemu+tcp://hv02/system"},
+			targets:  []exporter.Target{{URI: "qemu+tcp://hv01/system"}},
+			expected: single,
+		},
+		{
+			name:     "multiple uris",
+			uris:     []string{"qemu+tcp://hv01/system", "qemu+tcp://hv02/system"},
+			expected: multiple,
+		},
+		{
+			name:     "multiple targets",
+			uris:     []string{"qemu+tcp://hv01/system"},
+			targets:  []exporter.Target{{URI: "qemu+tcp://hv01/system"}, {URI: "qemu+tcp://hv02/system"}},
+			expected: multiple,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			m := exporter.NewMultiExporter(tc.uris, withFake)
+			if tc.targets != nil {
+				m.SetTargets(tc.targets)
+			}
+
+			reg := prometheus.NewPedanticRegistry()
+			if err := reg.Register(m); err != nil {
+				t.Fatalf("register exporter failed, %s", err)
+			}
+
+			err := testutil.GatherAndCompare(reg, strings.NewReader(tc.expected), "libvirt_up")
+			if err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
@@ -0,0 +1,65 @@
+package exporter
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// NamingStrategy builds the fully qualified names of the metrics from
+// the namespace, e.g. libvirt, the subsystem, e.g. block, and the
+// name, any of which could be empty.
+type NamingStrategy interface {
+	FQName(namespace, subsystem, name string) string
+}
+
+// NamingFunc is a func used as a NamingStrategy.
+type NamingFunc func(namespace, subsystem, name string) string
+
+func (f NamingFunc) FQName(namespace, subsystem, name string) string {
+	return f(namespace, subsystem, name)
+}
+
+// DefaultNaming joins the non-empty parts with underscores.
+var DefaultNaming NamingStrategy = NamingFunc(prometheus.BuildFQName)
+
+// RenameNaming builds the names with base, then renames the ones found
+// in names, e.g. to keep the names of another exporter.
+func RenameNaming(base NamingStrategy, names map[string]string) NamingStrategy {
+	return NamingFunc(func(namespace, subsystem, name string) string {
+		fqName := base.FQName(namespace, subsystem, name)
+		if renamed, ok := names[fqName]; ok {
+			return renamed
+		}
+
+		return fqName
+	})
+}
+
+// KuminaNaming names the metrics the way kumina/libvirt_exporter does,
+// i.e. the former names of the renamed metrics, and the block and
+// interface statistics in their own subsystems.
+var KuminaNaming = NamingFunc(func(namespace, subsystem, name string) string {
+	switch subsystem {
+	case "block":
+		subsystem = "domain_block_stats"
+	case "interface":
+		subsystem = "domain_interface_stats"
+	default:
+		if legacy, ok := legacyNames[prometheus.BuildFQName("", subsystem, name)]; ok {
+			return prometheus.BuildFQName(namespace, "", legacy)
+		}
+	}
+
+	return prometheus.BuildFQName(namespace, subsystem, name)
+})
+
+// WithNaming replaces DefaultNaming by naming, for compatibility with
+// the dashboards and alerts of other exporters.
+func WithNaming(naming NamingStrategy) Option {
+	return func(e *Exporter) {
+		e.naming = naming
+	}
+}
+
+func (e *Exporter) fqName(namespace, subsystem, name string) string {
+	return e.naming.FQName(namespace, subsystem, name)
+}
@@ -0,0 +1,143 @@
+package exporter
+
+import (
+	"encoding/xml"
+
+	"github.com/digitalocean/go-libvirt"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type networkCollector struct{ e *Exporter }
+
+func (c networkCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.e.networkActive
+	ch <- c.e.networkPersistent
+	ch <- c.e.networkAutostart
+	ch <- c.e.networkInfo
+	ch <- c.e.dhcpLeases
+	if c.e.leaseInfo {
+		ch <- c.e.dhcpLeaseInfo
+	}
+}
+
+func (c networkCollector) Collect(ch chan<- prometheus.Metric, cli *client) error {
+	return errors.Wrap(c.e.collectNetworks(ch, cli), "failed to collect networks")
+}
+
+func (e *Exporter) collectNetworks(ch chan<- prometheus.Metric, cli *client) error {
+	networks, _, err := cli.ConnectListAllNetworks(1, 0)
+	if err != nil {
+		return errors.Wrap(err, "failed to list networks")
+	}
+
+	// a network undefined since listed is logged and skipped, it must not
+	// hide the others
+	for _, network := range networks {
+		active, err := cli.NetworkIsActive(network)
+		if err != nil {
+			e.logger.Printf("check network %s failed, %s\n", network.Name, err)
+			continue
+		}
+
+		persistent, err := cli.NetworkIsPersistent(network)
+		if err != nil {
+			e.logger.Printf("check network %s failed, %s\n", network.Name, err)
+			continue
+		}
+
+		autostart, err := cli.NetworkGetAutostart(network)
+		if err != nil {
+			e.logger.Printf("get autostart of network %s failed, %s\n", network.Name, err)
+			continue
+		}
+
+		xmlDesc, err := cli.NetworkGetXMLDesc(network, 0)
+		if err != nil {
+			e.logger.Printf("get xml desc of network %s failed, %s\n", network.Name, err)
+			continue
+		}
+
+		var schema Network
+		if err = xml.Unmarshal([]byte(xmlDesc), &schema); err != nil {
+			e.logger.Printf("unmarshal network %s failed, %s\n", network.Name, err)
+			continue
+		}
+
+		// a network without forward element is isolated
+		mode := schema.Forward.Mode
+		if mode == "" {
+			mode = "isolated"
+		}
+
+		ch <- e.constMetric(
+			e.networkActive,
+			prometheus.GaugeValue,
+			float64(active),
+			network.Name)
+		ch <- e.constMetric(
+			e.networkPersistent,
+			prometheus.GaugeValue,
+			float64(persistent),
+			network.Name)
+		ch <- e.constMetric(
+			e.networkAutostart,
+			prometheus.GaugeValue,
+			float64(autostart),
+			network.Name)
+		ch <- e.constMetric(
+			e.networkInfo,
+			prometheus.GaugeValue,
+			1,
+			network.Name, schema.Bridge.Name, mode)
+
+		// leases are only available while the network is running
+		if active != 1 {
+			continue
+		}
+
+		if err = e.collectDHCPLeases(ch, cli, network); err != nil {
+			e.logger.Printf("collect leases of network %s failed, %s\n", network.Name, err)
+		}
+	}
+
+	return nil
+}
+
+func (e *Exporter) collectDHCPLeases(ch chan<- prometheus.Metric, cli *client, network libvirt.Network) error {
+	leases, _, err := cli.NetworkGetDhcpLeases(network, nil, 1, 0)
+	if err != nil {
+		return errors.Wrapf(err, "failed to get dhcp leases of network %s", network.Name)
+	}
+
+	ch <- e.constMetric(
+		e.dhcpLeases,
+		prometheus.GaugeValue,
+		float64(len(leases)),
+		network.Name)
+
+	if !e.leaseInfo {
+		return nil
+	}
+
+	for _, lease := range leases {
+		ch <- e.constMetric(
+			e.dhcpLeaseInfo,
+			prometheus.GaugeValue,
+			1,
+			network.Name,
+			optString(lease.Mac),
+			lease.Ipaddr,
+			optString(lease.Hostname))
+	}
+
+	return nil
+}
+
+func optString(s libvirt.OptString) string {
+	if len(s) == 0 {
+		return ""
+	}
+
+	return s[0]
+}
@@ -0,0 +1,120 @@
+package exporter
+
+import (
+	"strings"
+
+	"github.com/digitalocean/go-libvirt"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// perfEvents are the perf events of the bulk stats by the name of their
+// typed parameter, only the ones enabled on the domain are reported,
+// e.g. by <perf><event name='cpu_cycles' enabled='yes'/></perf>. The
+// clocks are in nanoseconds.
+var perfEvents = []struct {
+	event string
+	name  string
+	help  string
+	scale float64
+}{
+	{"cpu_cycles", "cpu_cycles_total", "Number of CPU cycles of the domain.", 1},
+	{"instructions", "instructions_total", "Number of instructions executed by the domain.", 1},
+	{"cache_references", "cache_references_total", "Number of cache references of the domain.", 1},
+	{"cache_misses", "cache_misses_total", "Number of cache misses of the domain.", 1},
+	{"branch_instructions", "branch_instructions_total", "Number of branch instructions executed by the domain.", 1},
+	{"branch_misses", "branch_misses_total", "Number of branch mispredictions of the domain.", 1},
+	{"bus_cycles", "bus_cycles_total", "Number of bus cycles of the domain.", 1},
+	{"stalled_cycles_frontend", "stalled_cycles_frontend_total", "Number of stalled cycles in the frontend of the CPU.", 1},
+	{"stalled_cycles_backend", "stalled_cycles_backend_total", "Number of stalled cycles in the backend of the CPU.", 1},
+	{"ref_cpu_cycles", "ref_cpu_cycles_total", "Number of CPU cycles not affected by frequency scaling.", 1},
+	{"cpu_clock", "cpu_clock_seconds_total", "CPU clock time of the domain, in seconds.", 1e-9},
+	{"task_clock", "task_clock_seconds_total", "Task clock time of the domain, in seconds.", 1e-9},
+	{"page_faults", "page_faults_total", "Number of page faults of the domain.", 1},
+	{"page_faults_min", "minor_page_faults_total", "Number of minor page faults of the domain.", 1},
+	{"page_faults_maj", "major_page_faults_total", "Number of major page faults of the domain.", 1},
+	{"context_switches", "context_switches_total", "Number of context switches of the domain.", 1},
+	{"cpu_migrations", "cpu_migrations_total", "Number of migrations of the domain between host CPUs.", 1},
+	{"alignment_faults", "alignment_faults_total", "Number of alignment faults of the domain.", 1},
+	{"emulation_faults", "emulation_faults_total", "Number of emulation faults of the domain.", 1},
+}
+
+// perfCollector reports the perf events of domains, it's disabled by
+// default as the events must be enabled on the domains, which costs
+// them some performance.
+type perfCollector struct{ e *Exporter }
+
+func (c perfCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, desc := range c.e.perf {
+		ch <- desc
+	}
+}
+
+func (c perfCollector) CollectDomain(ch chan<- prometheus.Metric, cli *client, d *domainContext) error {
+	return errors.Wrap(c.e.collectPerf(ch, cli, d), "failed to collect perf events")
+}
+
+func (e *Exporter) newPerfDescs() []*prometheus.Desc {
+	descs := make([]*prometheus.Desc, len(perfEvents))
+	for i, event := range perfEvents {
+		descs[i] = e.newCounterDesc(
+			e.fqName(e.namespace, "domain_perf", event.name),
+			event.help,
+			[]string{"domain", "uuid"},
+			e.constLabels)
+	}
+
+	return descs
+}
+
+func (e *Exporter) collectPerf(ch chan<- prometheus.Metric, cli *client, d *domainContext) error {
+	params, err := d.bulkStats()
+	if err != nil {
+		return err
+	}
+
+	values := make(map[string]float64)
+	for field, v := range params {
+		if !strings.HasPrefix(field, "perf.") {
+			continue
+		}
+
+		if value, ok := typedParamFloat(v); ok {
+			values[strings.TrimPrefix(field, "perf.")] = value
+		}
+	}
+
+	for i, event := range perfEvents {
+		value, ok := values[event.event]
+		if !ok {
+			continue
+		}
+
+		ch <- e.constMetric(
+			e.perf[i],
+			prometheus.CounterValue,
+			value*event.scale,
+			d.name, d.uuid)
+	}
+
+	return nil
+}
+
+// typedParamFloat returns the number of the typed parameter, ok is false
+// for strings.
+func typedParamFloat(v libvirt.TypedParamValue) (float64, bool) {
+	switch i := v.I.(type) {
+	case int32:
+		return float64(i), true
+	case uint32:
+		return float64(i), true
+	case int64:
+		return float64(i), true
+	case uint64:
+		return float64(i), true
+	case float64:
+		return i, true
+	}
+
+	return 0, false
+}
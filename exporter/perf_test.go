@@ -0,0 +1,50 @@
+package exporter_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/digitalocean/go-libvirt"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/f1shl3gs/libvirt_exporter/exporter"
+)
+
+func TestPerf(t *testing.T) {
+	f := newFake()
+	f.Domains[0].Stats = []libvirt.TypedParam{
+		typedParam("perf.cpu_cycles", uint64(1000)),
+		typedParam("perf.task_clock", uint64(2500000000)),
+	}
+	d := f.Domains[0]
+	d.Name = "web1"
+	d.UUID[15] = 1
+	d.Stats = []libvirt.TypedParam{
+		typedParam("perf.cpu_cycles", uint64(3000)),
+	}
+	f.Domains = append(f.Domains, d)
+
+	reg := newExporter(t, f, exporter.WithCollector("perf", true))
+
+	expected := `
+# HELP libvirt_domain_perf_cpu_cycles_total Number of CPU cycles of the domain.
+# TYPE libvirt_domain_perf_cpu_cycles_total counter
+libvirt_domain_perf_cpu_cycles_total{domain="web",uuid="6a5d2c3e-8d3b-4b8e-9f4a-2b1c0d9e8f7a"} 1000
+libvirt_domain_perf_cpu_cycles_total{domain="web1",uuid="6a5d2c3e-8d3b-4b8e-9f4a-2b1c0d9e8f01"} 3000
+# HELP libvirt_domain_perf_task_clock_seconds_total Task clock time of the domain, in seconds.
+# TYPE libvirt_domain_perf_task_clock_seconds_total counter
+libvirt_domain_perf_task_clock_seconds_total{domain="web",uuid="6a5d2c3e-8d3b-4b8e-9f4a-2b1c0d9e8f7a"} 2.5
+`
+
+	err := testutil.GatherAndCompare(reg, strings.NewReader(expected),
+		"libvirt_domain_perf_cpu_cycles_total",
+		"libvirt_domain_perf_task_clock_seconds_total")
+	if err != nil {
+		t.Error(err)
+	}
+
+	// the stats of both domains are read at once
+	if n := f.Calls("ConnectGetAllDomainStats"); n != 1 {
+		t.Errorf("ConnectGetAllDomainStats called %d times, want 1", n)
+	}
+}
@@ -0,0 +1,161 @@
+package exporter
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+var labelNameRE = regexp.MustCompile("^[a-zA-Z_][a-zA-Z0-9_]*$")
+
+// ValidLabelName reports whether the name could be used as a label name.
+func ValidLabelName(name string) bool {
+	return labelNameRE.MatchString(name)
+}
+
+// RelabelConfig changes the labels of every metric before exposing, so
+// it's not necessary to add metric_relabel_configs to every Prometheus.
+// Labels are dropped first, then renamed, and static labels are added
+// at last, overriding the existing ones.
+type RelabelConfig struct {
+	Drop   []string          `yaml:"drop"`
+	Rename map[string]string `yaml:"rename"`
+	Static map[string]string `yaml:"static"`
+}
+
+// Validate checks the label names of the rules.
+func (c RelabelConfig) Validate() error {
+	for from, to := range c.Rename {
+		if !labelNameRE.MatchString(to) {
+			return errors.Errorf("invalid label name %q to rename %s", to, from)
+		}
+	}
+
+	for name := range c.Static {
+		if !labelNameRE.MatchString(name) {
+			return errors.Errorf("invalid static label name %q", name)
+		}
+	}
+
+	return nil
+}
+
+func (c RelabelConfig) empty() bool {
+	return len(c.Drop) == 0 && len(c.Rename) == 0 && len(c.Static) == 0
+}
+
+func (c RelabelConfig) relabel(pairs []*dto.LabelPair) []*dto.LabelPair {
+	labels := make(map[string]string, len(pairs)+len(c.Static))
+	for _, pair := range pairs {
+		labels[pair.GetName()] = pair.GetValue()
+	}
+
+	for _, name := range c.Drop {
+		delete(labels, name)
+	}
+
+	for from, to := range c.Rename {
+		value, ok := labels[from]
+		if !ok {
+			continue
+		}
+
+		delete(labels, from)
+		labels[to] = value
+	}
+
+	for name, value := range c.Static {
+		labels[name] = value
+	}
+
+	result := make([]*dto.LabelPair, 0, len(labels))
+	for name, value := range labels {
+		name, value := name, value
+		result = append(result, &dto.LabelPair{Name: &name, Value: &value})
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].GetName() < result[j].GetName()
+	})
+
+	return result
+}
+
+// signature identifies the label set of a metric in its family
+func signature(pairs []*dto.LabelPair) string {
+	var sb strings.Builder
+	for _, pair := range pairs {
+		sb.WriteString(pair.GetName())
+		sb.WriteByte(0xff)
+		sb.WriteString(pair.GetValue())
+		sb.WriteByte(0xff)
+	}
+
+	return sb.String()
+}
+
+// Relabeler applies a RelabelConfig to gathered metrics, the config could
+// be updated at any time.
+type Relabeler struct {
+	mu  sync.RWMutex
+	cfg RelabelConfig
+
+	// former names of the renamed metrics, see EnableLegacyNames
+	aliases map[string]string
+}
+
+// Update replaces the rules, it takes effect from the next gathering.
+func (r *Relabeler) Update(cfg RelabelConfig) error {
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	r.cfg = cfg
+	r.mu.Unlock()
+
+	return nil
+}
+
+// Gatherer wraps g, applying the rules to every gathered metric. Metrics
+// end up with the same labels after relabeling, e.g. dropping the uuid of
+// domains with the same name, only the first one is kept.
+func (r *Relabeler) Gatherer(g prometheus.Gatherer) prometheus.Gatherer {
+	return prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+		mfs, err := g.Gather()
+
+		r.mu.RLock()
+		cfg, aliases := r.cfg, r.aliases
+		r.mu.RUnlock()
+
+		if cfg.empty() {
+			return addLegacy(mfs, aliases), err
+		}
+
+		for _, mf := range mfs {
+			seen := make(map[string]struct{}, len(mf.Metric))
+			metrics := mf.Metric[:0]
+
+			for _, m := range mf.Metric {
+				m.Label = cfg.relabel(m.Label)
+
+				sig := signature(m.Label)
+				if _, ok := seen[sig]; ok {
+					continue
+				}
+
+				seen[sig] = struct{}{}
+				metrics = append(metrics, m)
+			}
+
+			mf.Metric = metrics
+		}
+
+		return addLegacy(mfs, aliases), err
+	})
+}
@@ -0,0 +1,161 @@
+package exporter_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/f1shl3gs/libvirt_exporter/exporter"
+)
+
+// newReads returns a registry with the read bytes of vda of two domains
+// named web.
+func newReads() *prometheus.Registry {
+	reads := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "libvirt_block_read_bytes_total",
+		Help: "Number of bytes read from a block device, in bytes.",
+	}, []string{"domain", "target_device", "uuid"})
+	reads.WithLabelValues("web", "vda", "6a5d2c3e-8d3b-4b8e-9f4a-2b1c0d9e8f7a").Add(4096)
+	reads.WithLabelValues("web", "vda", "6a5d2c3e-8d3b-4b8e-9f4a-2b1c0d9e8f7b").Add(1024)
+
+	reg := prometheus.NewPedanticRegistry()
+	reg.MustRegister(reads)
+
+	return reg
+}
+
+func TestRelabel(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		config   exporter.RelabelConfig
+		expected string
+	}{
+		{
+			name: "no rules",
+			expected: `
+# HELP libvirt_block_read_bytes_total Number of bytes read from a block device, in bytes.
+# TYPE libvirt_block_read_bytes_total counter
+libvirt_block_read_bytes_total{domain="web",target_device="vda",uuid="6a5d2c3e-8d3b-4b8e-9f4a-2b1c0d9e8f7a"} 4096
+libvirt_block_read_bytes_total{domain="web",target_device="vda",uuid="6a5d2c3e-8d3b-4b8e-9f4a-2b1c0d9e8f7b"} 1024
+`,
+		},
+		{
+			// the series ending up with the same labels are kept once
+			name:   "drop",
+			config: exporter.RelabelConfig{Drop: []string{"uuid"}},
+			expected: `
+# HELP libvirt_block_read_bytes_total Number of bytes read from a block device, in bytes.
+# TYPE libvirt_block_read_bytes_total counter
+libvirt_block_read_bytes_total{domain="web",target_device="vda"} 4096
+`,
+		},
+		{
+			// labels are dropped before renaming, so the dropped uuid
+			// isn't renamed and the renamed domain isn't dropped
+			name: "drop before rename",
+			config: exporter.RelabelConfig{
+				Drop:   []string{"uuid", "vm_name"},
+				Rename: map[string]string{"uuid": "id", "domain": "vm_name"},
+			},
+			expected: `
+# HELP libvirt_block_read_bytes_total Number of bytes read from a block device, in bytes.
+# TYPE libvirt_block_read_bytes_total counter
+libvirt_block_read_bytes_total{target_device="vda",vm_name="web"} 4096
+`,
+		},
+		{
+			// static labels are added at last, overriding renamed ones
+			name: "static after rename",
+			config: exporter.RelabelConfig{
+				Drop:   []string{"uuid"},
+				Rename: map[string]string{"domain": "vm_name", "target_device": "device"},
+				Static: map[string]string{"vm_name": "shared", "zone": "a"},
+			},
+			expected: `
+# HELP libvirt_block_read_bytes_total Number of bytes read from a block device, in bytes.
+# TYPE libvirt_block_read_bytes_total counter
+libvirt_block_read_bytes_total{device="vda",vm_name="shared",zone="a"} 4096
+`,
+		},
+	} {
+		var relabeler exporter.Relabeler
+		if err := relabeler.Update(tc.config); err != nil {
+			t.Fatalf("%s: update failed, %s", tc.name, err)
+		}
+
+		err := testutil.GatherAndCompare(relabeler.Gatherer(newReads()), strings.NewReader(tc.expected),
+			"libvirt_block_read_bytes_total")
+		if err != nil {
+			t.Errorf("%s: %s", tc.name, err)
+		}
+	}
+}
+
+func TestRelabelLegacyNames(t *testing.T) {
+	rss := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "libvirt_domain_memory_rss_bytes",
+		Help: "Resident set size of the process running the domain, in bytes.",
+	}, []string{"domain", "uuid"})
+	rss.WithLabelValues("web", "6a5d2c3e-8d3b-4b8e-9f4a-2b1c0d9e8f7a").Set(512)
+
+	reg := prometheus.NewPedanticRegistry()
+	reg.MustRegister(rss)
+
+	for _, tc := range []struct {
+		name     string
+		config   exporter.RelabelConfig
+		expected string
+	}{
+		{
+			name: "legacy names",
+			expected: `
+# HELP libvirt_domain_info_memory_rss_bytes Resident set size of the process running the domain, in bytes. Deprecated, use libvirt_domain_memory_rss_bytes.
+# TYPE libvirt_domain_info_memory_rss_bytes gauge
+libvirt_domain_info_memory_rss_bytes{domain="web",uuid="6a5d2c3e-8d3b-4b8e-9f4a-2b1c0d9e8f7a"} 512
+# HELP libvirt_domain_memory_rss_bytes Resident set size of the process running the domain, in bytes.
+# TYPE libvirt_domain_memory_rss_bytes gauge
+libvirt_domain_memory_rss_bytes{domain="web",uuid="6a5d2c3e-8d3b-4b8e-9f4a-2b1c0d9e8f7a"} 512
+`,
+		},
+		{
+			// the former names are relabeled as well
+			name:   "legacy names relabeled",
+			config: exporter.RelabelConfig{Drop: []string{"uuid"}, Rename: map[string]string{"domain": "vm_name"}},
+			expected: `
+# HELP libvirt_domain_info_memory_rss_bytes Resident set size of the process running the domain, in bytes. Deprecated, use libvirt_domain_memory_rss_bytes.
+# TYPE libvirt_domain_info_memory_rss_bytes gauge
+libvirt_domain_info_memory_rss_bytes{vm_name="web"} 512
+# HELP libvirt_domain_memory_rss_bytes Resident set size of the process running the domain, in bytes.
+# TYPE libvirt_domain_memory_rss_bytes gauge
+libvirt_domain_memory_rss_bytes{vm_name="web"} 512
+`,
+		},
+	} {
+		var relabeler exporter.Relabeler
+		if err := relabeler.Update(tc.config); err != nil {
+			t.Fatalf("%s: update failed, %s", tc.name, err)
+		}
+		relabeler.EnableLegacyNames("libvirt")
+
+		err := testutil.GatherAndCompare(relabeler.Gatherer(reg), strings.NewReader(tc.expected),
+			"libvirt_domain_memory_rss_bytes",
+			"libvirt_domain_info_memory_rss_bytes")
+		if err != nil {
+			t.Errorf("%s: %s", tc.name, err)
+		}
+	}
+}
+
+func TestRelabelInvalid(t *testing.T) {
+	for _, config := range []exporter.RelabelConfig{
+		{Rename: map[string]string{"domain": "vm-name"}},
+		{Static: map[string]string{"0zone": "a"}},
+	} {
+		var relabeler exporter.Relabeler
+		if err := relabeler.Update(config); err == nil {
+			t.Errorf("expected %+v to fail", config)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package exporter
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// resctrlCollector reports the cache occupancy and memory bandwidth of
+// domains, measured by the monitors of <cachetune> and <memorytune> of
+// the domain XML on hosts with resctrl, for telling noisy neighbors.
+type resctrlCollector struct{ e *Exporter }
+
+func (c resctrlCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.e.cacheOccupancy
+	ch <- c.e.memBandwidth
+	ch <- c.e.memBandwidthLocal
+}
+
+func (c resctrlCollector) CollectDomain(ch chan<- prometheus.Metric, cli *client, d *domainContext) error {
+	return errors.Wrap(c.e.collectResctrl(ch, cli, d), "failed to collect resctrl monitors")
+}
+
+// formats of the fields of the monitors, taking the index of the monitor
+// and then of the bank or node
+const (
+	cacheMonitorCount     = "cpu.cache.monitor.count"
+	cacheMonitor          = "cpu.cache.monitor.%d"
+	cacheBank             = cacheMonitor + ".bank.%d"
+	bandwidthMonitorCount = "memory.bandwidth.monitor.count"
+	bandwidthMonitor      = "memory.bandwidth.monitor.%d"
+	bandwidthNode         = bandwidthMonitor + ".node.%d"
+)
+
+func (e *Exporter) collectResctrl(ch chan<- prometheus.Metric, cli *client, d *domainContext) error {
+	params, err := d.bulkStats()
+	if err != nil {
+		return err
+	}
+
+	// cpu.cache.monitor.<num>.bank.<index>.bytes is the cache occupancy
+	// of the vcpus of the monitor in the cache bank
+	for i := 0; i < params.count(cacheMonitorCount); i++ {
+		monitor := params.string(cacheMonitor+".name", i)
+		vcpus := params.string(cacheMonitor+".vcpus", i)
+		for j := 0; j < params.count(cacheMonitor+".bank.count", i); j++ {
+			bytes, ok := params.float(cacheBank+".bytes", i, j)
+			if !ok {
+				continue
+			}
+
+			bank, _ := params.float(cacheBank+".id", i, j)
+			ch <- e.constMetric(
+				e.cacheOccupancy,
+				prometheus.GaugeValue,
+				bytes,
+				d.name, d.uuid, monitor, vcpus, fmt.Sprint(bank))
+		}
+	}
+
+	// memory.bandwidth.monitor.<num>.node.<index>.bytes.{total,local} are
+	// the bytes the vcpus of the monitor transferred from and to the
+	// memory of the NUMA node, local is the part of the local node
+	for i := 0; i < params.count(bandwidthMonitorCount); i++ {
+		monitor := params.string(bandwidthMonitor+".name", i)
+		vcpus := params.string(bandwidthMonitor+".vcpus", i)
+		for j := 0; j < params.count(bandwidthMonitor+".node.count", i); j++ {
+			node, _ := params.float(bandwidthNode+".id", i, j)
+			if total, ok := params.float(bandwidthNode+".bytes.total", i, j); ok {
+				ch <- e.constMetric(
+					e.memBandwidth,
+					prometheus.CounterValue,
+					total,
+					d.name, d.uuid, monitor, vcpus, fmt.Sprint(node))
+			}
+			if local, ok := params.float(bandwidthNode+".bytes.local", i, j); ok {
+				ch <- e.constMetric(
+					e.memBandwidthLocal,
+					prometheus.CounterValue,
+					local,
+					d.name, d.uuid, monitor, vcpus, fmt.Sprint(node))
+			}
+		}
+	}
+
+	return nil
+}
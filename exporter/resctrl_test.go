@@ -0,0 +1,62 @@
+package exporter_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/digitalocean/go-libvirt"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/f1shl3gs/libvirt_exporter/exporter"
+)
+
+func TestResctrl(t *testing.T) {
+	f := newFake()
+	f.Domains[0].Stats = []libvirt.TypedParam{
+		typedParam("cpu.cache.monitor.count", uint32(1)),
+		typedParam("cpu.cache.monitor.0.name", "vcpus_0-1"),
+		typedParam("cpu.cache.monitor.0.vcpus", "0-1"),
+		typedParam("cpu.cache.monitor.0.bank.count", uint32(2)),
+		typedParam("cpu.cache.monitor.0.bank.0.id", uint32(0)),
+		typedParam("cpu.cache.monitor.0.bank.0.bytes", uint64(3<<20)),
+		typedParam("cpu.cache.monitor.0.bank.1.id", uint32(1)),
+		typedParam("cpu.cache.monitor.0.bank.1.bytes", uint64(1<<20)),
+		typedParam("memory.bandwidth.monitor.count", uint32(1)),
+		typedParam("memory.bandwidth.monitor.0.name", "vcpus_0-1"),
+		typedParam("memory.bandwidth.monitor.0.vcpus", "0-1"),
+		typedParam("memory.bandwidth.monitor.0.node.count", uint32(1)),
+		typedParam("memory.bandwidth.monitor.0.node.0.id", uint32(0)),
+		typedParam("memory.bandwidth.monitor.0.node.0.bytes.total", uint64(8192)),
+		typedParam("memory.bandwidth.monitor.0.node.0.bytes.local", uint64(4096)),
+	}
+
+	reg := newExporter(t, f,
+		exporter.WithCollector("resctrl", true),
+		exporter.WithCollector("perf", true))
+
+	expected := `
+# HELP libvirt_domain_cache_occupancy_bytes Occupancy of the cache bank by the vcpus of the cache monitor, in bytes.
+# TYPE libvirt_domain_cache_occupancy_bytes gauge
+libvirt_domain_cache_occupancy_bytes{bank="0",domain="web",monitor="vcpus_0-1",uuid="6a5d2c3e-8d3b-4b8e-9f4a-2b1c0d9e8f7a",vcpus="0-1"} 3.145728e+06
+libvirt_domain_cache_occupancy_bytes{bank="1",domain="web",monitor="vcpus_0-1",uuid="6a5d2c3e-8d3b-4b8e-9f4a-2b1c0d9e8f7a",vcpus="0-1"} 1.048576e+06
+# HELP libvirt_domain_memory_bandwidth_bytes_total Bytes transferred by the vcpus of the memory bandwidth monitor from and to the memory of the NUMA node.
+# TYPE libvirt_domain_memory_bandwidth_bytes_total counter
+libvirt_domain_memory_bandwidth_bytes_total{domain="web",monitor="vcpus_0-1",node="0",uuid="6a5d2c3e-8d3b-4b8e-9f4a-2b1c0d9e8f7a",vcpus="0-1"} 8192
+# HELP libvirt_domain_memory_bandwidth_local_bytes_total Bytes transferred by the vcpus of the memory bandwidth monitor from and to the local memory of the NUMA node.
+# TYPE libvirt_domain_memory_bandwidth_local_bytes_total counter
+libvirt_domain_memory_bandwidth_local_bytes_total{domain="web",monitor="vcpus_0-1",node="0",uuid="6a5d2c3e-8d3b-4b8e-9f4a-2b1c0d9e8f7a",vcpus="0-1"} 4096
+`
+
+	err := testutil.GatherAndCompare(reg, strings.NewReader(expected),
+		"libvirt_domain_cache_occupancy_bytes",
+		"libvirt_domain_memory_bandwidth_bytes_total",
+		"libvirt_domain_memory_bandwidth_local_bytes_total")
+	if err != nil {
+		t.Error(err)
+	}
+
+	// shared with the perf collector
+	if n := f.Calls("ConnectGetAllDomainStats"); n != 1 {
+		t.Errorf("ConnectGetAllDomainStats called %d times, want 1", n)
+	}
+}
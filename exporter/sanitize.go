@@ -0,0 +1,54 @@
+package exporter
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// sanitize returns s as a label value safe to expose, invalid UTF-8,
+// which fails the metric, and control characters, which break some
+// parsers of the exposition, are replaced by U+FFFD. ok is false if s
+// is changed.
+func sanitize(s string) (string, bool) {
+	// a literal U+FFFD is valid, only the invalid bytes decoded as it
+	// are replaced
+	if utf8.ValidString(s) && strings.IndexFunc(s, unicode.IsControl) < 0 {
+		return s, true
+	}
+
+	return strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return utf8.RuneError
+		}
+
+		return r
+	}, s), false
+}
+
+// constMetric is prometheus.MustNewConstMetric with the label values
+// sanitized, domain names, titles, paths and whatever the guest agent
+// reports aren't trusted.
+func (e *Exporter) constMetric(desc *prometheus.Desc, valueType prometheus.ValueType, value float64, labelValues ...string) prometheus.Metric {
+	var sanitized []string
+	for i, v := range labelValues {
+		clean, ok := sanitize(v)
+		if ok {
+			continue
+		}
+
+		if sanitized == nil {
+			sanitized = append([]string(nil), labelValues...)
+		}
+		sanitized[i] = clean
+		e.sanitized.Inc()
+	}
+
+	if sanitized != nil {
+		labelValues = sanitized
+	}
+
+	return prometheus.MustNewConstMetric(desc, valueType, value, labelValues...)
+}
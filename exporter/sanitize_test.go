@@ -0,0 +1,81 @@
+package exporter_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestSanitize(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		domain    string
+		expected  string
+		sanitized bool
+	}{
+		{
+			name:     "clean",
+			domain:   "wéb-01",
+			expected: "wéb-01",
+		},
+		{
+			name:     "replacement character",
+			domain:   "web\ufffd01",
+			expected: "web\ufffd01",
+		},
+		{
+			name:      "invalid UTF-8",
+			domain:    "web\xff01",
+			expected:  "web\ufffd01",
+			sanitized: true,
+		},
+		{
+			name:      "truncated UTF-8",
+			domain:    "w\xc3",
+			expected:  "w\ufffd",
+			sanitized: true,
+		},
+		{
+			name:      "control characters",
+			domain:    "web\n01\x00\t\x7f",
+			expected:  "web\ufffd01\ufffd\ufffd\ufffd",
+			sanitized: true,
+		},
+	} {
+		f := newFake()
+		f.Domains[0].Name = tc.domain
+		reg := newExporter(t, f)
+
+		expected := `
+# HELP libvirt_domain_state Code of the domain state
+# TYPE libvirt_domain_state gauge
+libvirt_domain_state{domain="` + tc.expected + `",state="running",uuid="6a5d2c3e-8d3b-4b8e-9f4a-2b1c0d9e8f7a"} 1
+`
+
+		err := testutil.GatherAndCompare(reg, strings.NewReader(expected), "libvirt_domain_state")
+		if err != nil {
+			t.Errorf("%s: %s", tc.name, err)
+			continue
+		}
+
+		mfs, err := reg.Gather()
+		if err != nil {
+			t.Fatalf("%s: gather failed, %s", tc.name, err)
+		}
+
+		var sanitized float64
+		for _, mf := range mfs {
+			if mf.GetName() == "libvirt_exporter_sanitized_label_values_total" {
+				sanitized = mf.GetMetric()[0].GetCounter().GetValue()
+			}
+		}
+
+		if tc.sanitized && sanitized == 0 {
+			t.Errorf("%s: sanitized label values not counted", tc.name)
+		}
+		if !tc.sanitized && sanitized != 0 {
+			t.Errorf("%s: %v label values counted as sanitized, want none", tc.name, sanitized)
+		}
+	}
+}
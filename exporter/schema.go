@@ -1,68 +1,68 @@
 package exporter
 
-import "encoding/xml"
+import "github.com/f1shl3gs/libvirt_exporter/schema"
 
-type Domain struct {
-	Devices  Devices  `xml:"devices"`
-	Name     string   `xml:"name"`
-	UUID     string   `xml:"uuid"`
-	Metadata Metadata `xml:"metadata"`
-}
-
-type Metadata struct {
-	NovaInstance NovaInstance `xml:"instance"`
-}
-
-type NovaInstance struct {
-	XMLName xml.Name  `xml:"instance"`
-	Name    string    `xml:"name"`
-	Owner   NovaOwner `xml:"owner"`
-}
-
-type NovaOwner struct {
-	XMLName xml.Name    `xml:"owner"`
-	User    NovaUser    `xml:"user"`
-	Project NovaProject `xml:"project"`
-}
+// The domain XML is described by the schema package, the aliases keep
+// the names used by the collectors.
+type (
+	Domain          = schema.Domain
+	Metadata        = schema.Metadata
+	NovaInstance    = schema.NovaInstance
+	NovaOwner       = schema.NovaOwner
+	NovaUser        = schema.NovaUser
+	NovaProject     = schema.NovaProject
+	Devices         = schema.Devices
+	Disk            = schema.Disk
+	DiskSource      = schema.DiskSource
+	DiskTarget      = schema.DiskTarget
+	DeviceAddress   = schema.DeviceAddress
+	Interface       = schema.Interface
+	InterfaceSource = schema.InterfaceSource
+	InterfaceTarget = schema.InterfaceTarget
+	HostdevSource   = schema.HostdevSource
+)
 
-type NovaUser struct {
-	UserId   string `xml:"uuid,attr"`
-	UserName string `xml:",chardata"`
+type StoragePool struct {
+	Type string `xml:"type,attr"`
+	Name string `xml:"name"`
 }
 
-type NovaProject struct {
-	ProjectId   string `xml:"uuid,attr"`
-	ProjectName string `xml:",chardata"`
+type Network struct {
+	Name    string         `xml:"name"`
+	Forward NetworkForward `xml:"forward"`
+	Bridge  NetworkBridge  `xml:"bridge"`
 }
 
-type Devices struct {
-	Disks      []Disk      `xml:"disk"`
-	Interfaces []Interface `xml:"interface"`
+type NetworkForward struct {
+	Mode string `xml:"mode,attr"`
 }
 
-type Disk struct {
-	Device string     `xml:"device,attr"`
-	Source DiskSource `xml:"source"`
-	Target DiskTarget `xml:"target"`
+type NetworkBridge struct {
+	Name string `xml:"name,attr"`
 }
 
-type DiskSource struct {
-	File string `xml:"file,attr"`
+type NodeDevice struct {
+	Name       string               `xml:"name"`
+	Driver     NodeDeviceDriver     `xml:"driver"`
+	Capability NodeDeviceCapability `xml:"capability"`
 }
 
-type DiskTarget struct {
-	Device string `xml:"dev,attr"`
+type NodeDeviceDriver struct {
+	Name string `xml:"name"`
 }
 
-type Interface struct {
-	Source InterfaceSource `xml:"source"`
-	Target InterfaceTarget `xml:"target"`
+type NodeDeviceCapability struct {
+	Type    string            `xml:"type,attr"`
+	Vendor  NodeDeviceVendor  `xml:"vendor"`
+	Product NodeDeviceProduct `xml:"product"`
 }
 
-type InterfaceSource struct {
-	Bridge string `xml:"bridge,attr"`
+type NodeDeviceVendor struct {
+	ID   string `xml:"id,attr"`
+	Name string `xml:",chardata"`
 }
 
-type InterfaceTarget struct {
-	Device string `xml:"dev,attr"`
+type NodeDeviceProduct struct {
+	ID   string `xml:"id,attr"`
+	Name string `xml:",chardata"`
 }
@@ -0,0 +1,74 @@
+package exporter
+
+import (
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// sevPolicyFlags are the bits of the SEV and SEV-ES guest policy, see
+// the SEV API spec of AMD.
+var sevPolicyFlags = []struct {
+	bit  uint
+	name string
+}{
+	{0, "nodbg"},
+	{1, "noks"},
+	{2, "es"},
+	{3, "nosend"},
+	{4, "domain"},
+	{5, "sev"},
+}
+
+// launchSecurityCollector reports whether domains are confidential VMs,
+// i.e. their memory is encrypted by AMD SEV, SEV-ES or SEV-SNP, for the
+// compliance reports of confidential workloads.
+type launchSecurityCollector struct{ e *Exporter }
+
+func (c launchSecurityCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.e.launchSecurity
+	ch <- c.e.sevPolicy
+}
+
+func (c launchSecurityCollector) CollectDomain(ch chan<- prometheus.Metric, cli *client, d *domainContext) error {
+	c.e.collectLaunchSecurity(ch, d)
+	return nil
+}
+
+func (e *Exporter) collectLaunchSecurity(ch chan<- prometheus.Metric, d *domainContext) {
+	ls := d.schema.LaunchSecurity
+	if ls == nil {
+		ch <- e.constMetric(
+			e.launchSecurity,
+			prometheus.GaugeValue,
+			1,
+			d.name, d.uuid, "none", "")
+		return
+	}
+
+	// SEV-ES is SEV with the es bit of the policy set
+	policy, err := strconv.ParseUint(ls.Policy, 0, 64)
+	typ := ls.Type
+	if typ == "sev" && err == nil && policy&(1<<2) != 0 {
+		typ = "sev-es"
+	}
+
+	ch <- e.constMetric(
+		e.launchSecurity,
+		prometheus.GaugeValue,
+		1,
+		d.name, d.uuid, typ, ls.Policy)
+
+	// the policy of SEV-SNP has a layout of its own
+	if ls.Type != "sev" || err != nil {
+		return
+	}
+
+	for _, flag := range sevPolicyFlags {
+		ch <- e.constMetric(
+			e.sevPolicy,
+			prometheus.GaugeValue,
+			float64(policy>>flag.bit&1),
+			d.name, d.uuid, flag.name)
+	}
+}
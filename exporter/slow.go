@@ -0,0 +1,42 @@
+package exporter
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// slowestDomains is the number of domains listed by the slow scrape
+// warning.
+const slowestDomains = 5
+
+// logSlowScrape logs the collection in logfmt, with the slowest domains
+// and all the phases, so a regression could be caught before Prometheus
+// starts timing out.
+func (e *Exporter) logSlowScrape(result ScrapeResult) {
+	domains := make([]DomainResult, len(result.Domains))
+	copy(domains, result.Domains)
+	sort.SliceStable(domains, func(i, j int) bool { return domains[i].Duration > domains[j].Duration })
+	if len(domains) > slowestDomains {
+		domains = domains[:slowestDomains]
+	}
+
+	slowest := make([]string, 0, len(domains))
+	for _, d := range domains {
+		slowest = append(slowest, d.Name+"="+formatDuration(d.Duration))
+	}
+
+	phases := make([]string, 0, len(result.Phases))
+	for _, p := range result.Phases {
+		phases = append(phases, p.Name+"="+formatDuration(p.Duration))
+	}
+
+	e.logger.Printf("level=warn msg=%q uri=%q duration=%s threshold=%s domains=%d slowest_domains=%q phases=%q\n",
+		"slow scrape", e.uri, formatDuration(result.Duration), e.slowThreshold,
+		len(result.Domains), strings.Join(slowest, ","), strings.Join(phases, ","))
+}
+
+func formatDuration(d time.Duration) string {
+	return fmt.Sprintf("%.3fs", d.Seconds())
+}
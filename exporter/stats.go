@@ -0,0 +1,38 @@
+package exporter
+
+import "expvar"
+
+// Stats are the internal counters of all exporters, published by expvar
+// as libvirt_exporter, e.g. served by expvar.Handler at /debug/vars.
+var Stats = expvar.NewMap("libvirt_exporter")
+
+// names of the counters in Stats
+const (
+	statConnectionsOpened = "connections_opened"
+	statConnectionErrors  = "connection_errors"
+	statScrapes           = "scrapes"
+	statScrapesInFlight   = "scrapes_in_flight"
+	statScrapeErrors      = "scrape_errors"
+	statAgentCommands     = "agent_commands"
+	statAgentTimeouts     = "agent_command_timeouts"
+	statAgentBusy         = "agent_commands_rejected"
+	statCacheHits         = "cache_hits"
+	statCacheMisses       = "cache_misses"
+)
+
+func init() {
+	for _, name := range []string{
+		statConnectionsOpened,
+		statConnectionErrors,
+		statScrapes,
+		statScrapesInFlight,
+		statScrapeErrors,
+		statAgentCommands,
+		statAgentTimeouts,
+		statAgentBusy,
+		statCacheHits,
+		statCacheMisses,
+	} {
+		Stats.Add(name, 0)
+	}
+}
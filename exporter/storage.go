@@ -0,0 +1,133 @@
+package exporter
+
+import (
+	"encoding/xml"
+	"sort"
+
+	"github.com/digitalocean/go-libvirt"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type storageCollector struct{ e *Exporter }
+
+func (c storageCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.e.pools
+	if c.e.volumes {
+		ch <- c.e.volumeCapacity
+		ch <- c.e.volumeAllocation
+	}
+}
+
+func (c storageCollector) Collect(ch chan<- prometheus.Metric, cli *client) error {
+	return errors.Wrap(c.e.collectStoragePools(ch, cli), "failed to collect storage pools")
+}
+
+func (e *Exporter) collectStoragePools(ch chan<- prometheus.Metric, cli *client) error {
+	pools, _, err := cli.ConnectListAllStoragePools(1, 0)
+	if err != nil {
+		return errors.Wrap(err, "failed to list storage pools")
+	}
+
+	// a pool failing to report doesn't fail the others, nor the counts
+	types := make(map[string]int)
+	for _, pool := range pools {
+		xmlDesc, err := cli.StoragePoolGetXMLDesc(pool, 0)
+		if err != nil {
+			e.logger.Printf("get xml desc of pool %s failed, %s\n", pool.Name, err)
+			continue
+		}
+
+		var schema StoragePool
+		if err = xml.Unmarshal([]byte(xmlDesc), &schema); err != nil {
+			e.logger.Printf("unmarshal pool %s failed, %s\n", pool.Name, err)
+			continue
+		}
+
+		types[schema.Type]++
+
+		if !e.volumes {
+			continue
+		}
+
+		// volumes of inactive pools cannot be listed
+		active, err := cli.StoragePoolIsActive(pool)
+		if err != nil {
+			e.logger.Printf("check pool %s failed, %s\n", pool.Name, err)
+			continue
+		}
+
+		if active != 1 {
+			continue
+		}
+
+		if err = e.collectStorageVolumes(ch, cli, pool); err != nil {
+			e.logger.Printf("collect volumes of pool %s failed, %s\n", pool.Name, err)
+		}
+	}
+
+	for typ, n := range types {
+		ch <- e.constMetric(
+			e.pools,
+			prometheus.GaugeValue,
+			float64(n),
+			typ)
+	}
+
+	return nil
+}
+
+// volumeInfo is a volume of a pool, with its sizes in bytes
+type volumeInfo struct {
+	vol        libvirt.StorageVol
+	capacity   uint64
+	allocation uint64
+}
+
+func (e *Exporter) collectStorageVolumes(ch chan<- prometheus.Metric, cli *client, pool libvirt.StoragePool) error {
+	vols, _, err := cli.StoragePoolListAllVolumes(pool, 1, 0)
+	if err != nil {
+		return errors.Wrapf(err, "failed to list volumes of pool %s", pool.Name)
+	}
+
+	// a volume may be deleted meanwhile, it doesn't fail the others
+	infos := make([]volumeInfo, 0, len(vols))
+	for _, vol := range vols {
+		_, capacity, allocation, err := cli.StorageVolGetInfo(vol)
+		if err != nil {
+			e.logger.Printf("get info of volume %s/%s failed, %s\n", pool.Name, vol.Name, err)
+			continue
+		}
+
+		infos = append(infos, volumeInfo{vol: vol, capacity: capacity, allocation: allocation})
+	}
+
+	// the limit keeps the volumes taking most of the pool
+	if e.volumesLimit > 0 && len(infos) > e.volumesLimit {
+		sort.Slice(infos, func(i, j int) bool {
+			return infos[i].allocation > infos[j].allocation
+		})
+		infos = infos[:e.volumesLimit]
+	}
+
+	for _, info := range infos {
+		path, err := cli.StorageVolGetPath(info.vol)
+		if err != nil {
+			e.logger.Printf("get path of volume %s/%s failed, %s\n", pool.Name, info.vol.Name, err)
+			continue
+		}
+
+		ch <- e.constMetric(
+			e.volumeCapacity,
+			prometheus.GaugeValue,
+			float64(info.capacity),
+			pool.Name, info.vol.Name, path)
+		ch <- e.constMetric(
+			e.volumeAllocation,
+			prometheus.GaugeValue,
+			float64(info.allocation),
+			pool.Name, info.vol.Name, path)
+	}
+
+	return nil
+}
@@ -0,0 +1,39 @@
+package exporter
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// tpmCollector reports the virtual TPMs of domains, which Windows 11 and
+// attestation need.
+type tpmCollector struct{ e *Exporter }
+
+func (c tpmCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.e.tpms
+	ch <- c.e.tpmInfo
+}
+
+func (c tpmCollector) CollectDomain(ch chan<- prometheus.Metric, cli *client, d *domainContext) error {
+	c.e.collectTPMs(ch, d)
+	return nil
+}
+
+func (e *Exporter) collectTPMs(ch chan<- prometheus.Metric, d *domainContext) {
+	tpms := d.schema.Devices.TPMs
+	ch <- e.constMetric(
+		e.tpms,
+		prometheus.GaugeValue,
+		float64(len(tpms)),
+		d.name, d.uuid)
+
+	for _, tpm := range tpms {
+		ch <- e.constMetric(
+			e.tpmInfo,
+			prometheus.GaugeValue,
+			1,
+			d.name, d.uuid,
+			tpm.Model,
+			tpm.Backend.Type,
+			tpm.Backend.Version)
+	}
+}
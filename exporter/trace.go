@@ -0,0 +1,124 @@
+package exporter
+
+import (
+	"crypto/rand"
+	"sync"
+	"time"
+)
+
+// Span is a timed step of a collection: the collection of a host, of
+// a domain, or a libvirt RPC. Spans of a collection share the TraceID,
+// and the root one has a zero ParentID.
+type Span struct {
+	TraceID    [16]byte
+	ID         [8]byte
+	ParentID   [8]byte
+	Name       string
+	Start      time.Time
+	End        time.Time
+	Attributes map[string]string
+	Err        error
+}
+
+// SpanExporter receives the spans of every collection once it's done,
+// it must not block the collection.
+type SpanExporter interface {
+	ExportSpans(spans []Span)
+}
+
+// WithSpanExporter traces every collection, with a child span for every
+// domain and every libvirt RPC.
+func WithSpanExporter(exporter SpanExporter) Option {
+	return func(e *Exporter) {
+		e.spans = exporter
+	}
+}
+
+// trace collects the spans of a collection, a nil trace records nothing,
+// so the collectors don't have to check whether tracing is enabled.
+type trace struct {
+	mu    sync.Mutex
+	id    [16]byte
+	spans []Span
+	done  bool
+}
+
+// root is the index of the root span, noSpan of no span at all.
+const (
+	root   = 0
+	noSpan = -1
+)
+
+func newTrace() *trace {
+	t := &trace{}
+	rand.Read(t.id[:])
+	return t
+}
+
+// start begins a span under the parent, it returns the index to end
+// the span with.
+func (t *trace) start(parent int, name string, attrs map[string]string) int {
+	return t.record(parent, name, attrs, time.Now(), time.Time{}, nil)
+}
+
+// end finishes the span started by start.
+func (t *trace) end(span int, err error) {
+	if t == nil || span == noSpan {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if !t.done {
+		t.spans[span].End = time.Now()
+		t.spans[span].Err = err
+	}
+}
+
+// record adds a span, spans recorded after the trace was exported, e.g.
+// of an abandoned guest agent command, are dropped.
+func (t *trace) record(parent int, name string, attrs map[string]string, start, end time.Time, err error) int {
+	if t == nil {
+		return noSpan
+	}
+
+	span := Span{
+		TraceID:    t.id,
+		Name:       name,
+		Start:      start,
+		End:        end,
+		Attributes: attrs,
+		Err:        err,
+	}
+	rand.Read(span.ID[:])
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.done {
+		return noSpan
+	}
+
+	if parent != noSpan && parent < len(t.spans) {
+		span.ParentID = t.spans[parent].ID
+	}
+	t.spans = append(t.spans, span)
+
+	return len(t.spans) - 1
+}
+
+// export hands the spans over to the exporter, the trace is read only
+// afterwards.
+func (t *trace) export(exporter SpanExporter) {
+	if t == nil {
+		return
+	}
+
+	t.mu.Lock()
+	t.done = true
+	spans := t.spans
+	t.mu.Unlock()
+
+	exporter.ExportSpans(spans)
+}
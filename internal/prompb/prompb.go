@@ -0,0 +1,5 @@
+// Package prompb holds the messages of the Prometheus remote write
+// protocol, so the whole Prometheus module isn't needed for them.
+package prompb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative remote.proto
@@ -0,0 +1,355 @@
+package schema
+
+// Devices are the devices of the domain.
+type Devices struct {
+	Emulator    string         `xml:"emulator"`
+	Disks       []Disk         `xml:"disk"`
+	Filesystems []Filesystem   `xml:"filesystem"`
+	Controllers []Controller   `xml:"controller"`
+	Interfaces  []Interface    `xml:"interface"`
+	Hostdevs    []Hostdev      `xml:"hostdev"`
+	Channels    []Channel      `xml:"channel"`
+	Serials     []CharDevice   `xml:"serial"`
+	Consoles    []CharDevice   `xml:"console"`
+	Inputs      []Input        `xml:"input"`
+	Graphics    []Graphics     `xml:"graphics"`
+	Videos      []Video        `xml:"video"`
+	Sounds      []Typed        `xml:"sound"`
+	TPMs        []TPM          `xml:"tpm"`
+	RNGs        []RNG          `xml:"rng"`
+	Memories    []MemoryDevice `xml:"memory"`
+	Watchdog    *Watchdog      `xml:"watchdog"`
+	MemBalloon  *MemBalloon    `xml:"memballoon"`
+}
+
+// Disk is a disk, cdrom, floppy or lun. Type tells which attributes of
+// the source are set: file, block (dev), dir, network (protocol, name
+// and hosts), volume (pool and volume), nvme or vhostuser.
+type Disk struct {
+	Type         string        `xml:"type,attr"`
+	Device       string        `xml:"device,attr"`
+	Driver       DiskDriver    `xml:"driver"`
+	Source       DiskSource    `xml:"source"`
+	BackingStore *BackingStore `xml:"backingStore"`
+	Target       DiskTarget    `xml:"target"`
+	IOTune       *IOTune       `xml:"iotune"`
+	Serial       string        `xml:"serial"`
+	WWN          string        `xml:"wwn"`
+	ReadOnly     *struct{}     `xml:"readonly"`
+	Shareable    *struct{}     `xml:"shareable"`
+	Boot         *Boot         `xml:"boot"`
+	Alias        Alias         `xml:"alias"`
+	Address      DeviceAddress `xml:"address"`
+}
+
+type DiskDriver struct {
+	Name     string `xml:"name,attr"`
+	Type     string `xml:"type,attr"`
+	Cache    string `xml:"cache,attr"`
+	IO       string `xml:"io,attr"`
+	Discard  string `xml:"discard,attr"`
+	IOThread int    `xml:"iothread,attr"`
+}
+
+type DiskSource struct {
+	// type file
+	File string `xml:"file,attr"`
+
+	// type block
+	Dev string `xml:"dev,attr"`
+
+	// type dir
+	Dir string `xml:"dir,attr"`
+
+	// type network, e.g. protocol rbd and name pool/image
+	Protocol string           `xml:"protocol,attr"`
+	Name     string           `xml:"name,attr"`
+	Hosts    []DiskSourceHost `xml:"host"`
+	Auth     *DiskAuth        `xml:"auth"`
+
+	// type volume
+	Pool   string `xml:"pool,attr"`
+	Volume string `xml:"volume,attr"`
+	Mode   string `xml:"mode,attr"`
+
+	// type nvme and vhostuser
+	Type      string         `xml:"type,attr"`
+	Namespace string         `xml:"namespace,attr"`
+	Path      string         `xml:"path,attr"`
+	Address   *DeviceAddress `xml:"address"`
+
+	Index int `xml:"index,attr"`
+}
+
+type DiskSourceHost struct {
+	Name      string `xml:"name,attr"`
+	Port      string `xml:"port,attr"`
+	Transport string `xml:"transport,attr"`
+	Socket    string `xml:"socket,attr"`
+}
+
+type DiskAuth struct {
+	Username string `xml:"username,attr"`
+	Secret   struct {
+		Type  string `xml:"type,attr"`
+		UUID  string `xml:"uuid,attr"`
+		Usage string `xml:"usage,attr"`
+	} `xml:"secret"`
+}
+
+// BackingStore is the image a disk is layered on, it's nested for every
+// layer of the chain.
+type BackingStore struct {
+	Type         string        `xml:"type,attr"`
+	Index        int           `xml:"index,attr"`
+	Format       Typed         `xml:"format"`
+	Source       DiskSource    `xml:"source"`
+	BackingStore *BackingStore `xml:"backingStore"`
+}
+
+type DiskTarget struct {
+	Device    string `xml:"dev,attr"`
+	Bus       string `xml:"bus,attr"`
+	Removable string `xml:"removable,attr"`
+}
+
+// IOTune limits the I/O of a disk, in bytes and operations per second.
+type IOTune struct {
+	TotalBytesSec uint64 `xml:"total_bytes_sec"`
+	ReadBytesSec  uint64 `xml:"read_bytes_sec"`
+	WriteBytesSec uint64 `xml:"write_bytes_sec"`
+	TotalIOPSSec  uint64 `xml:"total_iops_sec"`
+	ReadIOPSSec   uint64 `xml:"read_iops_sec"`
+	WriteIOPSSec  uint64 `xml:"write_iops_sec"`
+	GroupName     string `xml:"group_name"`
+}
+
+type Boot struct {
+	Order int `xml:"order,attr"`
+}
+
+// DeviceAddress is the address of a device on its bus, pci address use
+// domain, bus, slot and function, drive address use controller, bus,
+// target and unit, usb address use bus and device or port.
+type DeviceAddress struct {
+	Type       string `xml:"type,attr"`
+	Domain     string `xml:"domain,attr"`
+	Bus        string `xml:"bus,attr"`
+	Slot       string `xml:"slot,attr"`
+	Function   string `xml:"function,attr"`
+	Controller string `xml:"controller,attr"`
+	Target     string `xml:"target,attr"`
+	Unit       string `xml:"unit,attr"`
+	Device     string `xml:"device,attr"`
+	Port       string `xml:"port,attr"`
+	UUID       string `xml:"uuid,attr"`
+}
+
+type Filesystem struct {
+	Type       string `xml:"type,attr"`
+	AccessMode string `xml:"accessmode,attr"`
+	Driver     Typed  `xml:"driver"`
+	Source     struct {
+		Dir  string `xml:"dir,attr"`
+		File string `xml:"file,attr"`
+		Name string `xml:"name,attr"`
+	} `xml:"source"`
+	Target struct {
+		Dir string `xml:"dir,attr"`
+	} `xml:"target"`
+	ReadOnly *struct{} `xml:"readonly"`
+	Alias    Alias     `xml:"alias"`
+}
+
+type Controller struct {
+	Type    string        `xml:"type,attr"`
+	Index   int           `xml:"index,attr"`
+	Model   string        `xml:"model,attr"`
+	Alias   Alias         `xml:"alias"`
+	Address DeviceAddress `xml:"address"`
+}
+
+// Interface is a network interface. Type tells which attributes of the
+// source are set: network, bridge, direct (dev and mode), hostdev,
+// user, ethernet or vhostuser.
+type Interface struct {
+	Type      string          `xml:"type,attr"`
+	MAC       MAC             `xml:"mac"`
+	Source    InterfaceSource `xml:"source"`
+	Target    InterfaceTarget `xml:"target"`
+	Model     Typed           `xml:"model"`
+	Driver    InterfaceDriver `xml:"driver"`
+	Bandwidth *Bandwidth      `xml:"bandwidth"`
+	MTU       *struct {
+		Size int `xml:"size,attr"`
+	} `xml:"mtu"`
+	Link *struct {
+		State string `xml:"state,attr"`
+	} `xml:"link"`
+	Boot    *Boot         `xml:"boot"`
+	Alias   Alias         `xml:"alias"`
+	Address DeviceAddress `xml:"address"`
+}
+
+type MAC struct {
+	Address string `xml:"address,attr"`
+}
+
+type InterfaceSource struct {
+	Network   string `xml:"network,attr"`
+	Portgroup string `xml:"portgroup,attr"`
+	Bridge    string `xml:"bridge,attr"`
+	Dev       string `xml:"dev,attr"`
+	Mode      string `xml:"mode,attr"`
+	Type      string `xml:"type,attr"`
+	Path      string `xml:"path,attr"`
+
+	// type hostdev
+	Address *DeviceAddress `xml:"address"`
+}
+
+type InterfaceTarget struct {
+	Device  string `xml:"dev,attr"`
+	Managed string `xml:"managed,attr"`
+}
+
+type InterfaceDriver struct {
+	Name   string `xml:"name,attr"`
+	Queues int    `xml:"queues,attr"`
+}
+
+// Bandwidth is the QoS of an interface, average and peak rates are in
+// KiB/s and bursts in KiB.
+type Bandwidth struct {
+	Inbound  *BandwidthLimit `xml:"inbound"`
+	Outbound *BandwidthLimit `xml:"outbound"`
+}
+
+type BandwidthLimit struct {
+	Average uint64 `xml:"average,attr"`
+	Peak    uint64 `xml:"peak,attr"`
+	Burst   uint64 `xml:"burst,attr"`
+	Floor   uint64 `xml:"floor,attr"`
+}
+
+// Hostdev is a device of the host passed through to the domain, the
+// source address is on the host and Address on the guest.
+type Hostdev struct {
+	Mode    string        `xml:"mode,attr"`
+	Type    string        `xml:"type,attr"`
+	Managed string        `xml:"managed,attr"`
+	Model   string        `xml:"model,attr"`
+	Source  HostdevSource `xml:"source"`
+	Boot    *Boot         `xml:"boot"`
+	Alias   Alias         `xml:"alias"`
+	Address DeviceAddress `xml:"address"`
+}
+
+type HostdevSource struct {
+	Address *DeviceAddress `xml:"address"`
+	Vendor  struct {
+		ID string `xml:"id,attr"`
+	} `xml:"vendor"`
+	Product struct {
+		ID string `xml:"id,attr"`
+	} `xml:"product"`
+	Adapter struct {
+		Name string `xml:"name,attr"`
+	} `xml:"adapter"`
+}
+
+// Channel is a communication channel between the host and the guest,
+// e.g. of the guest agent, whose target name is org.qemu.guest_agent.0.
+type Channel struct {
+	Type   string `xml:"type,attr"`
+	Source struct {
+		Mode string `xml:"mode,attr"`
+		Path string `xml:"path,attr"`
+	} `xml:"source"`
+	Target struct {
+		Type  string `xml:"type,attr"`
+		Name  string `xml:"name,attr"`
+		State string `xml:"state,attr"`
+	} `xml:"target"`
+	Alias Alias `xml:"alias"`
+}
+
+type CharDevice struct {
+	Type   string `xml:"type,attr"`
+	Source struct {
+		Path string `xml:"path,attr"`
+	} `xml:"source"`
+	Target struct {
+		Type string `xml:"type,attr"`
+		Port string `xml:"port,attr"`
+	} `xml:"target"`
+	Alias Alias `xml:"alias"`
+}
+
+type Input struct {
+	Type string `xml:"type,attr"`
+	Bus  string `xml:"bus,attr"`
+}
+
+type Graphics struct {
+	Type     string `xml:"type,attr"`
+	Port     int    `xml:"port,attr"`
+	AutoPort string `xml:"autoport,attr"`
+	Listen   string `xml:"listen,attr"`
+}
+
+type Video struct {
+	Model struct {
+		Type  string `xml:"type,attr"`
+		VRAM  uint64 `xml:"vram,attr"`
+		Heads int    `xml:"heads,attr"`
+	} `xml:"model"`
+	Alias Alias `xml:"alias"`
+}
+
+// TPM is a TPM device, the model is tpm-tis, tpm-crb or tpm-spapr, and
+// the backend is passthrough or emulator.
+type TPM struct {
+	Model   string `xml:"model,attr"`
+	Backend struct {
+		Type    string `xml:"type,attr"`
+		Version string `xml:"version,attr"`
+		Device  struct {
+			Path string `xml:"path,attr"`
+		} `xml:"device"`
+	} `xml:"backend"`
+	Alias Alias `xml:"alias"`
+}
+
+type RNG struct {
+	Model   string `xml:"model,attr"`
+	Backend struct {
+		Model string `xml:"model,attr"`
+		Path  string `xml:",chardata"`
+	} `xml:"backend"`
+	Alias Alias `xml:"alias"`
+}
+
+// MemoryDevice is hot plugged memory, e.g. a dimm.
+type MemoryDevice struct {
+	Model  string `xml:"model,attr"`
+	Access string `xml:"access,attr"`
+	Target struct {
+		Size Memory `xml:"size"`
+		Node int    `xml:"node"`
+	} `xml:"target"`
+	Alias Alias `xml:"alias"`
+}
+
+type Watchdog struct {
+	Model  string `xml:"model,attr"`
+	Action string `xml:"action,attr"`
+}
+
+type MemBalloon struct {
+	Model string `xml:"model,attr"`
+	Stats *struct {
+		Period int `xml:"period,attr"`
+	} `xml:"stats"`
+	Alias Alias `xml:"alias"`
+}
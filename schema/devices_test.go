@@ -0,0 +1,122 @@
+package schema
+
+import "testing"
+
+func TestDisks(t *testing.T) {
+	disks := loadDomain(t, "instance.xml").Devices.Disks
+	if len(disks) != 5 {
+		t.Fatalf("%d disks, want 5", len(disks))
+	}
+
+	file := disks[0]
+	if file.Type != "file" || file.Source.File != "/var/lib/nova/instances/6a5d2c3e-8d3b-4b8e-9f4a-2b1c0d9e8f7a/disk" || file.Source.Index != 2 {
+		t.Errorf("file disk source %+v", file.Source)
+	}
+	if file.Driver.Type != "qcow2" || file.Driver.Cache != "none" || file.Driver.Discard != "unmap" {
+		t.Errorf("file disk driver %+v", file.Driver)
+	}
+	if file.BackingStore == nil || file.BackingStore.Format.Type != "raw" || file.BackingStore.Source.File != "/var/lib/nova/instances/_base/4f1c2d3e" {
+		t.Errorf("file disk backing store %+v", file.BackingStore)
+	} else if file.BackingStore.BackingStore == nil || file.BackingStore.BackingStore.Type != "" {
+		t.Errorf("the chain should end with an empty backing store, got %+v", file.BackingStore.BackingStore)
+	}
+	if file.IOTune == nil || file.IOTune.TotalBytesSec != 100<<20 || file.IOTune.TotalIOPSSec != 1000 || file.IOTune.GroupName != "gold" {
+		t.Errorf("file disk iotune %+v", file.IOTune)
+	}
+	if file.Target.Device != "vda" || file.Target.Bus != "virtio" || file.Alias.Name != "virtio-disk0" {
+		t.Errorf("file disk target %+v alias %q", file.Target, file.Alias.Name)
+	}
+	if file.Boot == nil || file.Boot.Order != 1 || file.Address.Type != "pci" || file.Address.Bus != "0x04" {
+		t.Errorf("file disk boot %+v address %+v", file.Boot, file.Address)
+	}
+
+	block := disks[1]
+	if block.Type != "block" || block.Source.Dev != "/dev/mapper/vg0-data" || block.Target.Device != "vdb" {
+		t.Errorf("block disk %+v", block)
+	}
+
+	network := disks[2]
+	if network.Type != "network" || network.Source.Protocol != "rbd" || network.Source.Name != "volumes/volume-2c9e4a1b" {
+		t.Errorf("network disk source %+v", network.Source)
+	}
+	if len(network.Source.Hosts) != 2 || network.Source.Hosts[1].Name != "10.0.0.12" || network.Source.Hosts[1].Port != "6789" {
+		t.Errorf("network disk hosts %+v", network.Source.Hosts)
+	}
+	if auth := network.Source.Auth; auth == nil || auth.Username != "cinder" || auth.Secret.Type != "ceph" {
+		t.Errorf("network disk source auth %+v, want the ceph secret of cinder", auth)
+	}
+
+	volume := disks[3]
+	if volume.Type != "volume" || volume.Source.Pool != "default" || volume.Source.Volume != "scratch.qcow2" {
+		t.Errorf("volume disk source %+v", volume.Source)
+	}
+
+	cdrom := disks[4]
+	if cdrom.Device != "cdrom" || cdrom.ReadOnly == nil || cdrom.Source.File != "" {
+		t.Errorf("empty cdrom %+v", cdrom)
+	}
+	if cdrom.Address.Type != "drive" || cdrom.Address.Controller != "0" || cdrom.Address.Unit != "0" {
+		t.Errorf("cdrom address %+v", cdrom.Address)
+	}
+}
+
+func TestInterfaces(t *testing.T) {
+	ifaces := loadDomain(t, "instance.xml").Devices.Interfaces
+	if len(ifaces) != 4 {
+		t.Fatalf("%d interfaces, want 4", len(ifaces))
+	}
+
+	network := ifaces[0]
+	if network.Type != "network" || network.Source.Network != "default" || network.Source.Portgroup != "web" {
+		t.Errorf("network interface source %+v", network.Source)
+	}
+	if network.MAC.Address != "fa:16:3e:12:34:56" || network.Target.Device != "tap1a2b3c4d-5e" || network.Model.Type != "virtio" {
+		t.Errorf("network interface mac %q target %q model %q", network.MAC.Address, network.Target.Device, network.Model.Type)
+	}
+	if network.Driver.Name != "vhost" || network.Driver.Queues != 4 {
+		t.Errorf("network interface driver %+v", network.Driver)
+	}
+	if network.Bandwidth == nil || network.Bandwidth.Inbound == nil || network.Bandwidth.Inbound.Peak != 5000 || network.Bandwidth.Outbound == nil || network.Bandwidth.Outbound.Average != 128 {
+		t.Errorf("network interface bandwidth %+v", network.Bandwidth)
+	}
+	if network.MTU == nil || network.MTU.Size != 1450 || network.Link != nil {
+		t.Errorf("network interface mtu %+v link %+v", network.MTU, network.Link)
+	}
+
+	bridge := ifaces[1]
+	if bridge.Type != "bridge" || bridge.Source.Bridge != "br-ex" || bridge.Target.Device != "vnet3" {
+		t.Errorf("bridge interface %+v", bridge)
+	}
+	if bridge.Link == nil || bridge.Link.State != "up" || bridge.Bandwidth != nil {
+		t.Errorf("bridge interface link %+v bandwidth %+v", bridge.Link, bridge.Bandwidth)
+	}
+
+	direct := ifaces[2]
+	if direct.Type != "direct" || direct.Source.Dev != "eno2" || direct.Source.Mode != "bridge" || direct.Target.Device != "macvtap0" {
+		t.Errorf("direct interface %+v", direct)
+	}
+
+	hostdev := ifaces[3]
+	if hostdev.Type != "hostdev" || hostdev.Source.Address == nil || hostdev.Source.Address.Bus != "0x3b" || hostdev.Source.Address.Function != "0x1" {
+		t.Errorf("hostdev interface source %+v", hostdev.Source)
+	}
+	if hostdev.Target.Device != "" || hostdev.Alias.Name != "hostdev0" {
+		t.Errorf("hostdev interface target %q alias %q", hostdev.Target.Device, hostdev.Alias.Name)
+	}
+}
+
+func TestMemoryDevices(t *testing.T) {
+	devices := loadDomain(t, "instance.xml").Devices
+	if len(devices.Memories) != 1 {
+		t.Fatalf("%d memory devices, want 1", len(devices.Memories))
+	}
+
+	dimm := devices.Memories[0]
+	if dimm.Model != "dimm" || dimm.Target.Size.Bytes() != 2<<30 || dimm.Target.Node != 0 {
+		t.Errorf("dimm %+v, want 2 GiB on node 0", dimm)
+	}
+
+	if devices.MemBalloon == nil || devices.MemBalloon.Stats == nil || devices.MemBalloon.Stats.Period != 10 {
+		t.Errorf("memballoon %+v, want stats every 10s", devices.MemBalloon)
+	}
+}
@@ -0,0 +1,338 @@
+// Package schema is the XML description of libvirt domains, as returned
+// by virDomainGetXMLDesc, see https://libvirt.org/formatdomain.html.
+//
+// Optional elements whose presence matters are pointers, all the other
+// elements and attributes are values, which are empty if missing.
+package schema
+
+import (
+	"encoding/xml"
+	"strings"
+)
+
+// Domain is the root element of the domain XML.
+type Domain struct {
+	XMLName     xml.Name `xml:"domain"`
+	Type        string   `xml:"type,attr"`
+	ID          string   `xml:"id,attr,omitempty"`
+	Name        string   `xml:"name"`
+	UUID        string   `xml:"uuid"`
+	Title       string   `xml:"title"`
+	Description string   `xml:"description"`
+	Metadata    Metadata `xml:"metadata"`
+
+	MaxMemory     *MaxMemory     `xml:"maxMemory"`
+	Memory        Memory         `xml:"memory"`
+	CurrentMemory Memory         `xml:"currentMemory"`
+	MemoryBacking *MemoryBacking `xml:"memoryBacking"`
+
+	VCPU       VCPU      `xml:"vcpu"`
+	IOThreads  int       `xml:"iothreads"`
+	CPUTune    *CPUTune  `xml:"cputune"`
+	NUMATune   *NUMATune `xml:"numatune"`
+	Resource   *Resource `xml:"resource"`
+	CPU        *CPU      `xml:"cpu"`
+	OS         OS        `xml:"os"`
+	Features   *Features `xml:"features"`
+	Clock      *Clock    `xml:"clock"`
+	OnPoweroff string    `xml:"on_poweroff"`
+	OnReboot   string    `xml:"on_reboot"`
+	OnCrash    string    `xml:"on_crash"`
+
+	Devices Devices `xml:"devices"`
+
+	LaunchSecurity *LaunchSecurity `xml:"launchSecurity"`
+}
+
+// Metadata is the custom metadata of applications managing the domain.
+type Metadata struct {
+	NovaInstance NovaInstance `xml:"instance"`
+
+	// InnerXML keeps the raw metadata, which could contain elements
+	// of any namespace
+	InnerXML []byte `xml:",innerxml"`
+}
+
+// NovaInstance is the metadata OpenStack Nova adds to its instances.
+type NovaInstance struct {
+	XMLName xml.Name  `xml:"instance"`
+	Name    string    `xml:"name"`
+	Owner   NovaOwner `xml:"owner"`
+}
+
+type NovaOwner struct {
+	XMLName xml.Name    `xml:"owner"`
+	User    NovaUser    `xml:"user"`
+	Project NovaProject `xml:"project"`
+}
+
+type NovaUser struct {
+	UserId   string `xml:"uuid,attr"`
+	UserName string `xml:",chardata"`
+}
+
+type NovaProject struct {
+	ProjectId   string `xml:"uuid,attr"`
+	ProjectName string `xml:",chardata"`
+}
+
+// Memory is an amount of memory, the unit defaults to KiB.
+type Memory struct {
+	Unit  string `xml:"unit,attr"`
+	Value uint64 `xml:",chardata"`
+}
+
+// units of memory in libvirt, the SI ones are powers of 1000
+var memoryUnits = map[string]uint64{
+	"":      1024,
+	"b":     1,
+	"byte":  1,
+	"bytes": 1,
+	"kb":    1000,
+	"k":     1024,
+	"kib":   1024,
+	"mb":    1000 * 1000,
+	"m":     1024 * 1024,
+	"mib":   1024 * 1024,
+	"gb":    1000 * 1000 * 1000,
+	"g":     1024 * 1024 * 1024,
+	"gib":   1024 * 1024 * 1024,
+	"tb":    1000 * 1000 * 1000 * 1000,
+	"t":     1024 * 1024 * 1024 * 1024,
+	"tib":   1024 * 1024 * 1024 * 1024,
+	"pb":    1000 * 1000 * 1000 * 1000 * 1000,
+	"p":     1024 * 1024 * 1024 * 1024 * 1024,
+	"pib":   1024 * 1024 * 1024 * 1024 * 1024,
+	"eb":    1000 * 1000 * 1000 * 1000 * 1000 * 1000,
+	"e":     1024 * 1024 * 1024 * 1024 * 1024 * 1024,
+	"eib":   1024 * 1024 * 1024 * 1024 * 1024 * 1024,
+}
+
+// Bytes returns the amount in bytes, 0 if the unit is unknown.
+func (m Memory) Bytes() uint64 {
+	return m.Value * memoryUnits[strings.ToLower(m.Unit)]
+}
+
+// MaxMemory is the run time maximum of memory with hotplug slots.
+type MaxMemory struct {
+	Memory
+	Slots int `xml:"slots,attr"`
+}
+
+type MemoryBacking struct {
+	HugePages    *HugePages `xml:"hugepages"`
+	NoSharePages *struct{}  `xml:"nosharepages"`
+	Locked       *struct{}  `xml:"locked"`
+	Source       Typed      `xml:"source"`
+	Access       Moded      `xml:"access"`
+	Allocation   struct {
+		Mode    string `xml:"mode,attr"`
+		Threads int    `xml:"threads,attr"`
+	} `xml:"allocation"`
+	Discard *struct{} `xml:"discard"`
+}
+
+type HugePages struct {
+	Pages []HugePage `xml:"page"`
+}
+
+type HugePage struct {
+	Size    uint64 `xml:"size,attr"`
+	Unit    string `xml:"unit,attr"`
+	Nodeset string `xml:"nodeset,attr"`
+}
+
+// Bytes returns the size of the page in bytes.
+func (p HugePage) Bytes() uint64 {
+	return Memory{Unit: p.Unit, Value: p.Size}.Bytes()
+}
+
+type VCPU struct {
+	Placement string `xml:"placement,attr"`
+	CPUSet    string `xml:"cpuset,attr"`
+	Current   int    `xml:"current,attr"`
+	Value     int    `xml:",chardata"`
+}
+
+// CPUTune is the CPU tunables, periods and quotas are in microseconds.
+type CPUTune struct {
+	Shares         uint64 `xml:"shares"`
+	Period         uint64 `xml:"period"`
+	Quota          int64  `xml:"quota"`
+	GlobalPeriod   uint64 `xml:"global_period"`
+	GlobalQuota    int64  `xml:"global_quota"`
+	EmulatorPeriod uint64 `xml:"emulator_period"`
+	EmulatorQuota  int64  `xml:"emulator_quota"`
+	IOThreadPeriod uint64 `xml:"iothread_period"`
+	IOThreadQuota  int64  `xml:"iothread_quota"`
+
+	VCPUPins     []VCPUPin     `xml:"vcpupin"`
+	EmulatorPin  *CPUSet       `xml:"emulatorpin"`
+	IOThreadPins []IOThreadPin `xml:"iothreadpin"`
+	VCPUScheds   []Sched       `xml:"vcpusched"`
+	CacheTunes   []CacheTune   `xml:"cachetune"`
+	MemoryTunes  []MemoryTune  `xml:"memorytune"`
+}
+
+type CPUSet struct {
+	CPUSet string `xml:"cpuset,attr"`
+}
+
+type VCPUPin struct {
+	VCPU   int    `xml:"vcpu,attr"`
+	CPUSet string `xml:"cpuset,attr"`
+}
+
+type IOThreadPin struct {
+	IOThread int    `xml:"iothread,attr"`
+	CPUSet   string `xml:"cpuset,attr"`
+}
+
+type Sched struct {
+	VCPUs     string `xml:"vcpus,attr"`
+	Scheduler string `xml:"scheduler,attr"`
+	Priority  int    `xml:"priority,attr"`
+}
+
+// CacheTune allocates the host CPU cache to the vCPUs, and monitors its
+// occupancy with resctrl.
+type CacheTune struct {
+	VCPUs    string         `xml:"vcpus,attr"`
+	Caches   []CacheBank    `xml:"cache"`
+	Monitors []CacheMonitor `xml:"monitor"`
+}
+
+type CacheBank struct {
+	ID    int    `xml:"id,attr"`
+	Level int    `xml:"level,attr"`
+	Type  string `xml:"type,attr"`
+	Size  uint64 `xml:"size,attr"`
+	Unit  string `xml:"unit,attr"`
+}
+
+type CacheMonitor struct {
+	Level int    `xml:"level,attr"`
+	VCPUs string `xml:"vcpus,attr"`
+}
+
+// MemoryTune allocates the memory bandwidth to the vCPUs, in percents.
+type MemoryTune struct {
+	VCPUs string `xml:"vcpus,attr"`
+	Nodes []struct {
+		ID        int `xml:"id,attr"`
+		Bandwidth int `xml:"bandwidth,attr"`
+	} `xml:"node"`
+	Monitors []struct {
+		VCPUs string `xml:"vcpus,attr"`
+	} `xml:"monitor"`
+}
+
+type NUMATune struct {
+	Memory struct {
+		Mode      string `xml:"mode,attr"`
+		Nodeset   string `xml:"nodeset,attr"`
+		Placement string `xml:"placement,attr"`
+	} `xml:"memory"`
+	MemNodes []struct {
+		CellID  int    `xml:"cellid,attr"`
+		Mode    string `xml:"mode,attr"`
+		Nodeset string `xml:"nodeset,attr"`
+	} `xml:"memnode"`
+}
+
+type Resource struct {
+	Partition string `xml:"partition"`
+}
+
+type CPU struct {
+	Mode     string `xml:"mode,attr"`
+	Match    string `xml:"match,attr"`
+	Check    string `xml:"check,attr"`
+	Model    string `xml:"model"`
+	Vendor   string `xml:"vendor"`
+	Topology *struct {
+		Sockets int `xml:"sockets,attr"`
+		Dies    int `xml:"dies,attr"`
+		Cores   int `xml:"cores,attr"`
+		Threads int `xml:"threads,attr"`
+	} `xml:"topology"`
+	Features []struct {
+		Policy string `xml:"policy,attr"`
+		Name   string `xml:"name,attr"`
+	} `xml:"feature"`
+	NUMA *struct {
+		Cells []struct {
+			ID     int    `xml:"id,attr"`
+			CPUs   string `xml:"cpus,attr"`
+			Memory uint64 `xml:"memory,attr"`
+			Unit   string `xml:"unit,attr"`
+		} `xml:"cell"`
+	} `xml:"numa"`
+}
+
+type OS struct {
+	Type struct {
+		Arch    string `xml:"arch,attr"`
+		Machine string `xml:"machine,attr"`
+		Value   string `xml:",chardata"`
+	} `xml:"type"`
+	Firmware string `xml:"firmware,attr"`
+	Loader   *struct {
+		ReadOnly string `xml:"readonly,attr"`
+		Secure   string `xml:"secure,attr"`
+		Type     string `xml:"type,attr"`
+		Path     string `xml:",chardata"`
+	} `xml:"loader"`
+	Boots []struct {
+		Dev string `xml:"dev,attr"`
+	} `xml:"boot"`
+}
+
+// Features are the hypervisor features turned on, only the presence of
+// most of them matters.
+type Features struct {
+	ACPI   *struct{} `xml:"acpi"`
+	APIC   *struct{} `xml:"apic"`
+	PAE    *struct{} `xml:"pae"`
+	HyperV *struct {
+		Mode string `xml:"mode,attr"`
+	} `xml:"hyperv"`
+	SMM *struct {
+		State string `xml:"state,attr"`
+	} `xml:"smm"`
+}
+
+type Clock struct {
+	Offset string `xml:"offset,attr"`
+	Timers []struct {
+		Name       string `xml:"name,attr"`
+		TickPolicy string `xml:"tickpolicy,attr"`
+		Present    string `xml:"present,attr"`
+	} `xml:"timer"`
+}
+
+// LaunchSecurity is the confidential computing technology of the domain,
+// e.g. AMD SEV.
+type LaunchSecurity struct {
+	Type            string `xml:"type,attr"`
+	CBitPos         int    `xml:"cbitpos"`
+	ReducedPhysBits int    `xml:"reducedPhysBits"`
+	Policy          string `xml:"policy"`
+	DHCert          string `xml:"dhCert"`
+	Session         string `xml:"session"`
+}
+
+// Typed is an element with a type attribute only.
+type Typed struct {
+	Type string `xml:"type,attr"`
+}
+
+// Moded is an element with a mode attribute only.
+type Moded struct {
+	Mode string `xml:"mode,attr"`
+}
+
+// Alias is the name of a device given by libvirt, e.g. virtio-disk0.
+type Alias struct {
+	Name string `xml:"name,attr"`
+}
@@ -0,0 +1,199 @@
+package schema
+
+import (
+	"encoding/xml"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// loadDomain unmarshals the domain XML in testdata.
+func loadDomain(t *testing.T, name string) *Domain {
+	t.Helper()
+
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var d Domain
+	if err = xml.Unmarshal(data, &d); err != nil {
+		t.Fatalf("unmarshal %s failed, %s", name, err)
+	}
+
+	return &d
+}
+
+func TestDomainRoundTrip(t *testing.T) {
+	d := loadDomain(t, "instance.xml")
+
+	data, err := xml.Marshal(d)
+	if err != nil {
+		t.Fatalf("marshal failed, %s", err)
+	}
+
+	var again Domain
+	if err = xml.Unmarshal(data, &again); err != nil {
+		t.Fatalf("unmarshal the marshaled domain failed, %s", err)
+	}
+
+	// the raw metadata is written back along with the parsed elements
+	d.Metadata.InnerXML = nil
+	again.Metadata.InnerXML = nil
+	if !reflect.DeepEqual(d, &again) {
+		t.Errorf("domain changed by the round trip\nwant %+v\ngot  %+v", d, &again)
+	}
+}
+
+func TestDomain(t *testing.T) {
+	d := loadDomain(t, "instance.xml")
+
+	if d.Type != "kvm" || d.ID != "7" {
+		t.Errorf("type %q id %q, want kvm and 7", d.Type, d.ID)
+	}
+	if d.Name != "instance-0000002a" || d.UUID != "6a5d2c3e-8d3b-4b8e-9f4a-2b1c0d9e8f7a" {
+		t.Errorf("name %q uuid %q", d.Name, d.UUID)
+	}
+	if d.VCPU.Value != 8 || d.VCPU.Current != 4 || d.VCPU.Placement != "static" {
+		t.Errorf("vcpu %+v, want 8 vCPUs with 4 current", d.VCPU)
+	}
+	if d.IOThreads != 2 {
+		t.Errorf("iothreads %d, want 2", d.IOThreads)
+	}
+	if d.MaxMemory == nil || d.MaxMemory.Slots != 16 || d.MaxMemory.Bytes() != 32<<30 {
+		t.Errorf("max memory %+v, want 32 GiB with 16 slots", d.MaxMemory)
+	}
+	if d.Memory.Bytes() != 8<<30 || d.CurrentMemory.Bytes() != 8<<30 {
+		t.Errorf("memory %d current %d, want 8 GiB", d.Memory.Bytes(), d.CurrentMemory.Bytes())
+	}
+	if d.CPU == nil || d.CPU.Topology == nil || d.CPU.Topology.Threads != 2 || d.CPU.NUMA == nil || len(d.CPU.NUMA.Cells) != 2 {
+		t.Errorf("cpu %+v, want 2 threads per core and 2 NUMA cells", d.CPU)
+	}
+	if d.OnCrash != "destroy" {
+		t.Errorf("on_crash %q, want destroy", d.OnCrash)
+	}
+	if d.LaunchSecurity != nil {
+		t.Errorf("launch security %+v, want none", d.LaunchSecurity)
+	}
+}
+
+func TestMetadata(t *testing.T) {
+	d := loadDomain(t, "instance.xml")
+
+	nova := d.Metadata.NovaInstance
+	if nova.Name != "web-01" {
+		t.Errorf("nova name %q, want web-01", nova.Name)
+	}
+	if nova.Owner.User.UserName != "alice" || nova.Owner.User.UserId != "1f7b2f0a9c4e4a3b8d6e5f4a3b2c1d0e" {
+		t.Errorf("nova user %+v", nova.Owner.User)
+	}
+	if nova.Owner.Project.ProjectName != "shop" || nova.Owner.Project.ProjectId != "9e8d7c6b5a4f4e3d2c1b0a9f8e7d6c5b" {
+		t.Errorf("nova project %+v", nova.Owner.Project)
+	}
+	if len(d.Metadata.InnerXML) == 0 {
+		t.Error("raw metadata is empty")
+	}
+}
+
+func TestMemoryBacking(t *testing.T) {
+	mb := loadDomain(t, "instance.xml").MemoryBacking
+	if mb == nil {
+		t.Fatal("memory backing is missing")
+	}
+
+	if mb.HugePages == nil || len(mb.HugePages.Pages) != 2 {
+		t.Fatalf("hugepages %+v, want 2 page sizes", mb.HugePages)
+	}
+	if got := mb.HugePages.Pages[0].Bytes(); got != 2<<20 {
+		t.Errorf("first page size %d, want 2 MiB", got)
+	}
+	if got := mb.HugePages.Pages[1].Bytes(); got != 1<<30 || mb.HugePages.Pages[1].Nodeset != "1" {
+		t.Errorf("second page size %d of nodes %q, want 1 GiB of node 1", got, mb.HugePages.Pages[1].Nodeset)
+	}
+	if mb.NoSharePages == nil || mb.Locked == nil || mb.Discard == nil {
+		t.Errorf("nosharepages, locked and discard expected, got %+v", mb)
+	}
+	if mb.Source.Type != "memfd" || mb.Access.Mode != "shared" {
+		t.Errorf("source %q access %q, want memfd and shared", mb.Source.Type, mb.Access.Mode)
+	}
+	if mb.Allocation.Mode != "immediate" || mb.Allocation.Threads != 8 {
+		t.Errorf("allocation %+v, want immediate with 8 threads", mb.Allocation)
+	}
+}
+
+func TestCPUTune(t *testing.T) {
+	ct := loadDomain(t, "instance.xml").CPUTune
+	if ct == nil {
+		t.Fatal("cputune is missing")
+	}
+
+	if ct.Shares != 4096 || ct.Period != 100000 || ct.Quota != -1 {
+		t.Errorf("shares %d period %d quota %d", ct.Shares, ct.Period, ct.Quota)
+	}
+	if ct.GlobalQuota != 400000 || ct.EmulatorQuota != 50000 || ct.IOThreadQuota != -1 {
+		t.Errorf("global quota %d emulator quota %d iothread quota %d", ct.GlobalQuota, ct.EmulatorQuota, ct.IOThreadQuota)
+	}
+	if len(ct.VCPUPins) != 4 || ct.VCPUPins[3].VCPU != 3 || ct.VCPUPins[3].CPUSet != "5" {
+		t.Errorf("vcpupins %+v", ct.VCPUPins)
+	}
+	if ct.EmulatorPin == nil || ct.EmulatorPin.CPUSet != "0-1" {
+		t.Errorf("emulatorpin %+v, want 0-1", ct.EmulatorPin)
+	}
+	if len(ct.IOThreadPins) != 2 || ct.IOThreadPins[1].IOThread != 2 {
+		t.Errorf("iothreadpins %+v", ct.IOThreadPins)
+	}
+	if len(ct.VCPUScheds) != 1 || ct.VCPUScheds[0].Scheduler != "fifo" || ct.VCPUScheds[0].Priority != 1 {
+		t.Errorf("vcpuscheds %+v", ct.VCPUScheds)
+	}
+	if len(ct.CacheTunes) != 1 || len(ct.CacheTunes[0].Caches) != 1 || len(ct.CacheTunes[0].Monitors) != 1 {
+		t.Fatalf("cachetunes %+v", ct.CacheTunes)
+	}
+	if cache := ct.CacheTunes[0].Caches[0]; cache.Level != 3 || cache.Size != 3 || cache.Unit != "MiB" {
+		t.Errorf("cache %+v, want 3 MiB of L3", cache)
+	}
+	if len(ct.MemoryTunes) != 1 || len(ct.MemoryTunes[0].Nodes) != 1 || ct.MemoryTunes[0].Nodes[0].Bandwidth != 60 {
+		t.Errorf("memorytunes %+v", ct.MemoryTunes)
+	}
+}
+
+func TestMemoryBytes(t *testing.T) {
+	tests := []struct {
+		xml  string
+		want uint64
+	}{
+		{`<memory>1024</memory>`, 1 << 20},
+		{`<memory unit='b'>512</memory>`, 512},
+		{`<memory unit='bytes'>512</memory>`, 512},
+		{`<memory unit='KB'>2</memory>`, 2000},
+		{`<memory unit='k'>2</memory>`, 2 << 10},
+		{`<memory unit='KiB'>2</memory>`, 2 << 10},
+		{`<memory unit='MB'>3</memory>`, 3000000},
+		{`<memory unit='M'>3</memory>`, 3 << 20},
+		{`<memory unit='MiB'>3</memory>`, 3 << 20},
+		{`<memory unit='GB'>4</memory>`, 4000000000},
+		{`<memory unit='G'>4</memory>`, 4 << 30},
+		{`<memory unit='GiB'>4</memory>`, 4 << 30},
+		{`<memory unit='TB'>5</memory>`, 5000000000000},
+		{`<memory unit='T'>5</memory>`, 5 << 40},
+		{`<memory unit='TiB'>5</memory>`, 5 << 40},
+		{`<memory unit='PB'>6</memory>`, 6000000000000000},
+		{`<memory unit='P'>6</memory>`, 6 << 50},
+		{`<memory unit='PiB'>6</memory>`, 6 << 50},
+		{`<memory unit='EB'>7</memory>`, 7000000000000000000},
+		{`<memory unit='E'>7</memory>`, 7 << 60},
+		{`<memory unit='EiB'>7</memory>`, 7 << 60},
+		{`<memory unit='parsec'>1</memory>`, 0},
+	}
+
+	for _, test := range tests {
+		var m Memory
+		if err := xml.Unmarshal([]byte(test.xml), &m); err != nil {
+			t.Fatalf("unmarshal %s failed, %s", test.xml, err)
+		}
+
+		if got := m.Bytes(); got != test.want {
+			t.Errorf("%s is %d bytes, want %d", test.xml, got, test.want)
+		}
+	}
+}